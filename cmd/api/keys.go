@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"h-two/internal/config"
+	"h-two/internal/keyring"
+	"os"
+)
+
+// runKeys implements the `keys` subcommand:
+//
+//	h-two keys rotate [-dir keys] [-alg RS256|EdDSA] [-keep 2]
+//	h-two keys list [-dir keys]
+func runKeys(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s keys rotate|list [flags]", os.Args[0])
+	}
+	fs := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", cfg.Auth.KeysDir, "keyring directory")
+	switch args[0] {
+	case "rotate":
+		alg := fs.String("alg", keyring.AlgRS256, "signing algorithm: RS256 or EdDSA")
+		keep := fs.Int("keep", 2, "number of previous keys to keep for verification")
+		fs.Parse(args[1:])
+		key, err := keyring.Rotate(*dir, *alg, *keep)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("New active key %s (%s) written to %s\n", key.Kid, key.Alg, *dir)
+		fmt.Println("Running instances switch to it within a few seconds, the next time they sign a token or serve the JWKS.")
+		return nil
+	case "list":
+		fs.Parse(args[1:])
+		keys, err := keyring.Load(*dir)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys.Keys() {
+			status := "previous"
+			if i == 0 {
+				status = "active"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.Kid, key.Alg, key.CreatedAt.Format("2006-01-02 15:04:05"), status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+}
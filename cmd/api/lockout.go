@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"h-two/internal/config"
+	"h-two/internal/database"
+	"h-two/internal/logging"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"os"
+	"time"
+)
+
+// runLockout implements the `lockout` subcommand, which lets an operator
+// check and lift account lockouts:
+//
+//	h-two lockout status EMAIL
+//	h-two lockout unlock EMAIL
+func runLockout(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s lockout status|unlock EMAIL", os.Args[0])
+	}
+	fs := flag.NewFlagSet("lockout "+args[0], flag.ExitOnError)
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s lockout %s EMAIL", os.Args[0], args[0])
+	}
+	email := fs.Arg(0)
+	limiter, err := newLoginLimiter(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		until, apiErr := limiter.LockedUntil(ctx, email)
+		if apiErr != nil {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		if until == nil {
+			fmt.Printf("%s is not locked out\n", email)
+		} else {
+			fmt.Printf("%s is locked out until %s\n", email, until.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	case "unlock":
+		if apiErr := limiter.Unlock(ctx, email); apiErr != nil {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		fmt.Printf("Unlocked %s\n", email)
+		return nil
+	default:
+		return fmt.Errorf("unknown lockout command %q", args[0])
+	}
+}
+
+// newLoginLimiter opens the shared login limit store. Lockouts kept by the
+// memory store live inside the server process and cannot be reached here.
+func newLoginLimiter(cfg *config.Config) (services.LoginLimiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLimits.Store != "postgres" {
+		return nil, fmt.Errorf("the %s login limit store is not shared; restart the server to clear its lockouts", cfg.LoginLimits.Store)
+	}
+	logger := logging.New(cfg.Log, os.Stderr)
+	db := database.New(cfg.Database, logging.NewGormLogger(logger, time.Duration(cfg.Log.SlowQueryThreshold)))
+	return services.NewLoginLimiter(repository.NewLoginThrottleRepository(db.Db), server.NewLoginLimits(cfg.LoginLimits), logger), nil
+}
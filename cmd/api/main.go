@@ -1,26 +1,73 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"github.com/gin-gonic/gin"
+	"h-two/internal/config"
+	"h-two/internal/logging"
 	"h-two/internal/server"
+	"h-two/internal/tracing"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// Usage: h-two [-config FILE] [flags] [keys|migrate|lockout ...]
 func main() {
-	//dbService := database.New()
-	//err := database.EnableUuidExtension(dbService.Db)
-	//if err != nil {
-	//	log.Fatalf("Failed to enable UUID extension: %v", err)
-	//}
-	//err = models.Migrate(dbService.Db)
-	//if err != nil {
-	//	log.Fatalf("Failed to migrate database: %v", err)
-	//}
+	cfg, args, err := config.Parse(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Subcommands validate only what they use, so `keys rotate` works before
+	// a database is configured
+	if len(args) > 0 {
+		switch args[0] {
+		case "keys":
+			err = runKeys(cfg, args[1:])
+		case "migrate":
+			err = runMigrate(cfg, args[1:])
+		case "lockout":
+			err = runLockout(cfg, args[1:])
+		default:
+			log.Fatalf("unknown command %q (expected keys, migrate or lockout)", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	logger := logging.New(cfg.Log, os.Stdout)
+	// Anything still using the log package goes through the same handler
+	slog.SetDefault(logger)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
 	gin.SetMode(gin.ReleaseMode)
-	mainServer := server.NewServer()
+	mainServer := server.NewServer(cfg, logger)
 
-	err := mainServer.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal kills the process without waiting for the drain
+		<-ctx.Done()
+		stop()
+	}()
+	err = mainServer.ListenAndServe(ctx)
+	// Flush the spans of the last requests before exiting
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		logger.Warn("Failed to flush traces", "error", flushErr)
+	}
 	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+		logger.Error("Server stopped", "error", err)
+		os.Exit(1)
 	}
+	logger.Info("Server stopped")
 }
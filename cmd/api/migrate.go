@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"h-two/internal/config"
+	"h-two/internal/database"
+	"h-two/internal/logging"
+	"h-two/internal/migrations"
+	"os"
+	"time"
+)
+
+// migrationsDir is where `migrate create` writes new files; they are embedded
+// into the binary from there.
+const migrationsDir = "internal/migrations/sql"
+
+// runMigrate implements the `migrate` subcommand:
+//
+//	h-two migrate up [-steps 0]
+//	h-two migrate down [-steps 1]
+//	h-two migrate status
+//	h-two migrate create [-dir internal/migrations/sql] NAME
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s migrate up|down|status|create [flags]", os.Args[0])
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "up":
+		steps := fs.Int("steps", 0, "number of migrations to apply, 0 for all")
+		fs.Parse(args[1:])
+		migrator, err := newMigrator(cfg)
+		if err != nil {
+			return err
+		}
+		done, err := migrator.Up(*steps)
+		printMigrations("Applied", done)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return nil
+	case "down":
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		fs.Parse(args[1:])
+		migrator, err := newMigrator(cfg)
+		if err != nil {
+			return err
+		}
+		done, err := migrator.Down(*steps)
+		printMigrations("Rolled back", done)
+		return err
+	case "status":
+		fs.Parse(args[1:])
+		migrator, err := newMigrator(cfg)
+		if err != nil {
+			return err
+		}
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	case "create":
+		dir := fs.String("dir", migrationsDir, "directory to write the migration files to")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: %s migrate create [-dir DIR] NAME", os.Args[0])
+		}
+		up, down, err := migrations.Create(*dir, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s\nCreated %s\n", up, down)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+func newMigrator(cfg *config.Config) (*migrations.Migrator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	embedded, err := migrations.Embedded()
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.NewGormLogger(logging.New(cfg.Log, os.Stderr), time.Duration(cfg.Log.SlowQueryThreshold))
+	return migrations.New(database.New(cfg.Database, logger).Db, embedded), nil
+}
+
+func printMigrations(verb string, done []*migrations.Migration) {
+	for _, m := range done {
+		fmt.Printf("%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+}
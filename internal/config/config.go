@@ -0,0 +1,286 @@
+// Package config loads the application configuration from defaults, an
+// optional YAML or TOML file, environment variables and command line flags,
+// in increasing order of precedence, and validates it once at startup.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "30s" in
+// configuration files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	Mail     MailConfig     `yaml:"mail" toml:"mail"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing" toml:"tracing"`
+	// LoginLimits throttles password and MFA login attempts
+	LoginLimits LoginLimitConfig `yaml:"loginLimits" toml:"loginLimits"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port" toml:"port"`
+	// AppURL is the public base URL used in links sent by email
+	AppURL       string   `yaml:"appUrl" toml:"appUrl"`
+	ReadTimeout  Duration `yaml:"readTimeout" toml:"readTimeout"`
+	WriteTimeout Duration `yaml:"writeTimeout" toml:"writeTimeout"`
+	IdleTimeout  Duration `yaml:"idleTimeout" toml:"idleTimeout"`
+	// DrainDelay is how long readiness fails before the listener closes on
+	// shutdown, so load balancers stop sending traffic first
+	DrainDelay Duration `yaml:"drainDelay" toml:"drainDelay"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	ShutdownTimeout Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout"`
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed. With none, the client
+	// address is always the one the connection came from.
+	TrustedProxies []string `yaml:"trustedProxies" toml:"trustedProxies"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Name     string `yaml:"name" toml:"name"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	SSLMode  string `yaml:"sslMode" toml:"sslMode"`
+	// Connection pool settings; zero leaves the database/sql default
+	MaxOpenConns    int      `yaml:"maxOpenConns" toml:"maxOpenConns"`
+	MaxIdleConns    int      `yaml:"maxIdleConns" toml:"maxIdleConns"`
+	ConnMaxLifetime Duration `yaml:"connMaxLifetime" toml:"connMaxLifetime"`
+	ConnMaxIdleTime Duration `yaml:"connMaxIdleTime" toml:"connMaxIdleTime"`
+	// RequestTimeout bounds the database work done for one request; zero
+	// means no limit
+	RequestTimeout Duration `yaml:"requestTimeout" toml:"requestTimeout"`
+}
+
+// DSN returns the Postgres connection URL.
+func (c DatabaseConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+type AuthConfig struct {
+	// KeysDir holds the token signing keyring
+	KeysDir string `yaml:"keysDir" toml:"keysDir"`
+	// TokenRevocationStore is "postgres" or "memory"
+	TokenRevocationStore string `yaml:"tokenRevocationStore" toml:"tokenRevocationStore"`
+	// EmailVerificationPolicy is "restricted" or "off"
+	EmailVerificationPolicy string `yaml:"emailVerificationPolicy" toml:"emailVerificationPolicy"`
+	MfaIssuer               string `yaml:"mfaIssuer" toml:"mfaIssuer"`
+}
+
+type MailConfig struct {
+	// Driver is "log" or "smtp"
+	Driver string `yaml:"driver" toml:"driver"`
+	// LogFile is where the log driver writes messages; empty means stdout
+	LogFile string     `yaml:"logFile" toml:"logFile"`
+	From    string     `yaml:"from" toml:"from"`
+	SMTP    SMTPConfig `yaml:"smtp" toml:"smtp"`
+}
+
+type LogConfig struct {
+	// Level is "debug", "info", "warn" or "error"
+	Level string `yaml:"level" toml:"level"`
+	// Format is "json" or "text"
+	Format string `yaml:"format" toml:"format"`
+	// SlowQueryThreshold logs queries taking longer than this as warnings;
+	// zero turns it off
+	SlowQueryThreshold Duration `yaml:"slowQueryThreshold" toml:"slowQueryThreshold"`
+}
+
+type LoginLimitConfig struct {
+	// Store is "postgres" or "memory"; memory only suits a single instance
+	Store string `yaml:"store" toml:"store"`
+	// IpAttempts login attempts are allowed from one address per IpWindow
+	IpAttempts int      `yaml:"ipAttempts" toml:"ipAttempts"`
+	IpWindow   Duration `yaml:"ipWindow" toml:"ipWindow"`
+	// MaxFailures failed logins within FailureWindow lock the account for
+	// Lockout, which doubles with every further lockout up to MaxLockout
+	MaxFailures   int      `yaml:"maxFailures" toml:"maxFailures"`
+	FailureWindow Duration `yaml:"failureWindow" toml:"failureWindow"`
+	Lockout       Duration `yaml:"lockout" toml:"lockout"`
+	MaxLockout    Duration `yaml:"maxLockout" toml:"maxLockout"`
+}
+
+type TracingConfig struct {
+	// Exporter is "none", "stdout" or "otlp"
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL used by the otlp exporter
+	Endpoint    string `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string `yaml:"serviceName" toml:"serviceName"`
+	// SampleRatio is the fraction of new traces recorded; requests continuing
+	// a trace follow the caller's sampling decision
+	SampleRatio float64 `yaml:"sampleRatio" toml:"sampleRatio"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+// Default returns the configuration used for anything not set elsewhere.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(time.Minute),
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Database: DatabaseConfig{
+			Host:           "localhost",
+			Port:           5432,
+			SSLMode:        "require",
+			RequestTimeout: Duration(10 * time.Second),
+		},
+		Auth: AuthConfig{
+			KeysDir:                 "keys",
+			TokenRevocationStore:    "postgres",
+			EmailVerificationPolicy: "restricted",
+			MfaIssuer:               "h-two",
+		},
+		Mail: MailConfig{
+			Driver: "log",
+		},
+		Log: LogConfig{
+			Level:              "info",
+			Format:             "json",
+			SlowQueryThreshold: Duration(200 * time.Millisecond),
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "h-two",
+			SampleRatio: 1,
+		},
+		LoginLimits: LoginLimitConfig{
+			Store:         "postgres",
+			IpAttempts:    20,
+			IpWindow:      Duration(time.Minute),
+			MaxFailures:   5,
+			FailureWindow: Duration(15 * time.Minute),
+			Lockout:       Duration(time.Minute),
+			MaxLockout:    Duration(time.Hour),
+		},
+	}
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server port %d is out of range", c.Server.Port)
+	if c.Server.AppURL != "" {
+		u, err := url.Parse(c.Server.AppURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "app URL %q is not an absolute URL", c.Server.AppURL)
+	}
+	check(c.Server.ReadTimeout > 0 && c.Server.WriteTimeout > 0 && c.Server.IdleTimeout > 0, "server timeouts must be positive")
+	check(c.Server.DrainDelay >= 0 && c.Server.ShutdownTimeout > 0, "server drain delay cannot be negative and shutdown timeout must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "trusted proxy %q is not an IP address or CIDR range", proxy)
+	}
+
+	check(c.Database.Host != "", "database host is required")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "database port %d is out of range", c.Database.Port)
+	check(c.Database.Name != "", "database name is required")
+	check(c.Database.User != "", "database user is required")
+	check(oneOf(c.Database.SSLMode, sslModes...), "database sslmode %q must be one of %s", c.Database.SSLMode, strings.Join(sslModes, ", "))
+	check(c.Database.MaxOpenConns >= 0 && c.Database.MaxIdleConns >= 0, "database pool sizes cannot be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database max idle connections (%d) cannot exceed max open connections (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	check(c.Database.ConnMaxLifetime >= 0 && c.Database.ConnMaxIdleTime >= 0, "database connection lifetimes cannot be negative")
+	check(c.Database.RequestTimeout >= 0, "database request timeout cannot be negative")
+
+	check(c.Auth.KeysDir != "", "auth keys directory is required")
+	check(oneOf(c.Auth.TokenRevocationStore, "postgres", "memory"), "token revocation store %q must be postgres or memory", c.Auth.TokenRevocationStore)
+	check(oneOf(c.Auth.EmailVerificationPolicy, "restricted", "off"), "email verification policy %q must be restricted or off", c.Auth.EmailVerificationPolicy)
+	check(c.Auth.MfaIssuer != "", "MFA issuer is required")
+
+	check(oneOf(c.Mail.Driver, "log", "smtp"), "mail driver %q must be log or smtp", c.Mail.Driver)
+	if c.Mail.Driver == "smtp" {
+		check(c.Mail.SMTP.Host != "", "SMTP host is required by the smtp mail driver")
+		check(c.Mail.SMTP.Port > 0 && c.Mail.SMTP.Port < 65536, "SMTP port %d is out of range", c.Mail.SMTP.Port)
+		check(c.Mail.From != "", "mail from address is required by the smtp mail driver")
+	}
+
+	check(oneOf(c.Log.Level, "debug", "info", "warn", "error"), "log level %q must be debug, info, warn or error", c.Log.Level)
+	check(oneOf(c.Log.Format, "json", "text"), "log format %q must be json or text", c.Log.Format)
+	check(c.Log.SlowQueryThreshold >= 0, "slow query threshold cannot be negative")
+
+	check(oneOf(c.LoginLimits.Store, "postgres", "memory"), "login limit store %q must be postgres or memory", c.LoginLimits.Store)
+	check(c.LoginLimits.IpAttempts > 0 && c.LoginLimits.IpWindow > 0, "login limits per IP address must be positive")
+	check(c.LoginLimits.MaxFailures > 0 && c.LoginLimits.FailureWindow > 0, "login failure limits must be positive")
+	check(c.LoginLimits.Lockout > 0 && c.LoginLimits.MaxLockout >= c.LoginLimits.Lockout, "login lockout must be positive and no longer than the max lockout")
+
+	check(oneOf(c.Tracing.Exporter, "none", "stdout", "otlp"), "tracing exporter %q must be none, stdout or otlp", c.Tracing.Exporter)
+	if c.Tracing.Exporter == "otlp" {
+		u, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "tracing endpoint %q is not an http or https URL", c.Tracing.Endpoint)
+	}
+	check(c.Tracing.ServiceName != "", "tracing service name is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing sample ratio %v must be between 0 and 1", c.Tracing.SampleRatio)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// AppURL returns the public base URL, defaulting to the local server.
+func (c *Config) AppURL() string {
+	if c.Server.AppURL != "" {
+		return strings.TrimSuffix(c.Server.AppURL, "/")
+	}
+	return fmt.Sprintf("http://localhost:%d", c.Server.Port)
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Load is Parse followed by Validate.
+func Load(args []string) (*Config, []string, error) {
+	cfg, rest, err := Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, rest, nil
+}
+
+// Parse builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and the flags in args, without validating it.
+// It returns the arguments left over after the flags.
+func Parse(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	// Flags are parsed first to find -config, but applied last
+	flags := flag.NewFlagSet("h-two", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML configuration file")
+	var overrides Config
+	flags.IntVar(&overrides.Server.Port, "port", 0, "HTTP port")
+	flags.StringVar(&overrides.Server.AppURL, "app-url", "", "public base URL used in emailed links")
+	flags.StringVar(&overrides.Database.Host, "db-host", "", "Postgres host")
+	flags.IntVar(&overrides.Database.Port, "db-port", 0, "Postgres port")
+	flags.StringVar(&overrides.Database.Name, "db-name", "", "Postgres database")
+	flags.StringVar(&overrides.Database.SSLMode, "db-sslmode", "", "Postgres sslmode")
+	flags.StringVar(&overrides.Auth.KeysDir, "keys-dir", "", "token signing keyring directory")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := loadEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = overrides.Server.Port
+		case "app-url":
+			cfg.Server.AppURL = overrides.Server.AppURL
+		case "db-host":
+			cfg.Database.Host = overrides.Database.Host
+		case "db-port":
+			cfg.Database.Port = overrides.Database.Port
+		case "db-name":
+			cfg.Database.Name = overrides.Database.Name
+		case "db-sslmode":
+			cfg.Database.SSLMode = overrides.Database.SSLMode
+		case "keys-dir":
+			cfg.Auth.KeysDir = overrides.Auth.KeysDir
+		}
+	})
+	return cfg, flags.Args(), nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides cfg with every non-empty variable, keeping the names
+// used before the config package existed.
+func loadEnv(cfg *Config) error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	num := func(name string, dst *int) {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	float := func(name string, dst *float64) {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	dur := func(name string, dst *Duration) {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", name, v))
+				return
+			}
+			*dst = Duration(d)
+		}
+	}
+
+	num("PORT", &cfg.Server.Port)
+	str("APP_URL", &cfg.Server.AppURL)
+	dur("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	dur("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	dur("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	dur("SERVER_DRAIN_DELAY", &cfg.Server.DrainDelay)
+	dur("SERVER_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	list("SERVER_TRUSTED_PROXIES", &cfg.Server.TrustedProxies)
+
+	str("DB_HOST", &cfg.Database.Host)
+	num("DB_PORT", &cfg.Database.Port)
+	str("DB_DATABASE", &cfg.Database.Name)
+	str("DB_USERNAME", &cfg.Database.User)
+	str("DB_PASSWORD", &cfg.Database.Password)
+	str("DB_SSLMODE", &cfg.Database.SSLMode)
+	num("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	num("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	dur("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	dur("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+	dur("DB_REQUEST_TIMEOUT", &cfg.Database.RequestTimeout)
+
+	str("JWT_KEYS_DIR", &cfg.Auth.KeysDir)
+	str("TOKEN_REVOCATION_STORE", &cfg.Auth.TokenRevocationStore)
+	str("EMAIL_VERIFICATION_POLICY", &cfg.Auth.EmailVerificationPolicy)
+	str("MFA_ISSUER", &cfg.Auth.MfaIssuer)
+
+	str("MAIL_DRIVER", &cfg.Mail.Driver)
+	str("MAIL_LOG_FILE", &cfg.Mail.LogFile)
+	str("MAIL_FROM", &cfg.Mail.From)
+	str("SMTP_HOST", &cfg.Mail.SMTP.Host)
+	num("SMTP_PORT", &cfg.Mail.SMTP.Port)
+	str("SMTP_USERNAME", &cfg.Mail.SMTP.Username)
+	str("SMTP_PASSWORD", &cfg.Mail.SMTP.Password)
+	str("LOG_LEVEL", &cfg.Log.Level)
+	str("LOG_FORMAT", &cfg.Log.Format)
+	dur("LOG_SLOW_QUERY_THRESHOLD", &cfg.Log.SlowQueryThreshold)
+
+	str("LOGIN_LIMIT_STORE", &cfg.LoginLimits.Store)
+	num("LOGIN_LIMIT_IP_ATTEMPTS", &cfg.LoginLimits.IpAttempts)
+	dur("LOGIN_LIMIT_IP_WINDOW", &cfg.LoginLimits.IpWindow)
+	num("LOGIN_LIMIT_MAX_FAILURES", &cfg.LoginLimits.MaxFailures)
+	dur("LOGIN_LIMIT_FAILURE_WINDOW", &cfg.LoginLimits.FailureWindow)
+	dur("LOGIN_LIMIT_LOCKOUT", &cfg.LoginLimits.Lockout)
+	dur("LOGIN_LIMIT_MAX_LOCKOUT", &cfg.LoginLimits.MaxLockout)
+
+	str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	str("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %v", errs)
+	}
+	return nil
+}
@@ -1,13 +1,16 @@
 package database
 
 import (
-	"fmt"
+	"context"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/joho/godotenv/autoload"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"h-two/internal/config"
+	"h-two/internal/metrics"
+	"h-two/internal/tracing"
 	"log"
-	"os"
+	"time"
 )
 
 // Service represents a service that interacts with a database.
@@ -16,31 +19,57 @@ type DbService struct {
 	Db *gorm.DB
 }
 
-var (
-	database   = os.Getenv("DB_DATABASE")
-	password   = os.Getenv("DB_PASSWORD")
-	username   = os.Getenv("DB_USERNAME")
-	port       = os.Getenv("DB_PORT")
-	host       = os.Getenv("DB_HOST")
-	dbInstance *DbService
-)
-
-func New() *DbService {
-	// Reuse Connection
-	if dbInstance != nil {
-		return dbInstance
+// New opens a connection pool, logging queries through logger. Each call
+// opens its own pool, which the caller closes when done with it.
+func New(cfg config.DatabaseConfig, logger gormlogger.Interface) *DbService {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{TranslateError: true, Logger: logger})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatal(err)
 	}
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=require", username, password, host, port, database)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	sqlDB, err := db.DB()
 	if err != nil {
 		log.Fatal(err)
 	}
-	dbInstance = &DbService{
+	if err := metrics.RegisterDBStats(sqlDB, cfg.Name); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+	}
+	return &DbService{
 		Db: db,
 	}
-	return dbInstance
 }
 
-func EnableUuidExtension(db *gorm.DB) error {
-	return db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";").Error
+// Ping checks that the database is reachable.
+func (s *DbService) Ping(ctx context.Context) error {
+	sqlDB, err := s.Db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the connection pool, waiting for queries in progress.
+func (s *DbService) Close() error {
+	sqlDB, err := s.Db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
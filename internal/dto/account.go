@@ -0,0 +1,41 @@
+package dto
+
+import "time"
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type DeleteAccountResponse struct {
+	// DeletionScheduledAt is when the account will be purged unless the
+	// deletion is cancelled
+	DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+}
+
+// AccountExport is everything stored about a user, as handed out for data
+// portability requests.
+type AccountExport struct {
+	ExportedAt   time.Time                  `json:"exportedAt"`
+	Profile      AccountProfile             `json:"profile"`
+	Memberships  []*GetOrganizationResponse `json:"memberships"`
+	LoginHistory []*LoginEventResponse      `json:"loginHistory"`
+}
+
+type AccountProfile struct {
+	UserId              string     `json:"userId"`
+	FirstName           string     `json:"firstName"`
+	LastName            string     `json:"lastName"`
+	Email               string     `json:"email"`
+	Phone               string     `json:"phone"`
+	EmailVerifiedAt     *time.Time `json:"emailVerifiedAt"`
+	MfaEnabled          bool       `json:"mfaEnabled"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt"`
+}
+
+type LoginEventResponse struct {
+	Method    string    `json:"method"`
+	IpAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
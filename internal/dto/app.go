@@ -1,7 +1,15 @@
 package dto
 
 type ApiSuccessResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
-	Data    any    `json:"data,omitempty"`
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	Data    any       `json:"data,omitempty"`
+	Meta    *PageMeta `json:"meta,omitempty"`
+}
+
+// PageMeta describes a page of a cursor-paginated list. NextCursor is null
+// on the last page.
+type PageMeta struct {
+	NextCursor *string `json:"nextCursor"`
+	Limit      int     `json:"limit"`
 }
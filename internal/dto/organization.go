@@ -1,16 +1,92 @@
 package dto
 
+import "time"
+
 type GetOrganizationResponse struct {
-	OrgId       string `json:"orgId"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	OrgId       string     `json:"orgId"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	RequireMfa  bool       `json:"requireMfa"`
+	Role        string     `json:"role,omitempty"`
+	JoinedAt    *time.Time `json:"joinedAt,omitempty"`
+}
+
+type ListOrganizationsQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort" binding:"omitempty,oneof=name joinedAt"`
+	Order  string `form:"order" binding:"omitempty,oneof=asc desc"`
 }
 
 type CreateOrganizationRequest struct {
-	Name        string `json:"name"binding:"required"`
+	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 }
 
+type UpdateOrganizationRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Description *string `json:"description" binding:"omitempty,max=100"`
+}
+
+type DeleteOrganizationResponse struct {
+	OrgId string `json:"orgId"`
+	// RestorableUntil is when the organization will be purged for good
+	RestorableUntil time.Time `json:"restorableUntil"`
+}
+
 type AddUserToOrganizationRequest struct {
 	UserId string `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+type TransferOwnershipRequest struct {
+	UserId string `json:"userId" binding:"required"`
+}
+
+type OrganizationMemberResponse struct {
+	OrgId  string `json:"orgId"`
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type UpdateMfaPolicyRequest struct {
+	RequireMfa *bool `json:"requireMfa" binding:"required"`
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type InvitationResponse struct {
+	Id        string    `json:"id"`
+	OrgId     string    `json:"orgId"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListMembersQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+	Role   string `form:"role" binding:"omitempty,oneof=owner admin member"`
+	// Q matches the start of the first name, last name, full name or email
+	Q     string `form:"q" binding:"omitempty,max=100"`
+	Sort  string `form:"sort" binding:"omitempty,oneof=name email joinedAt"`
+	Order string `form:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+type MemberResponse struct {
+	UserId    string    `json:"userId"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
 }
@@ -1,11 +1,16 @@
 package dto
 
+import "time"
+
 type CreateUserRequest struct {
-	FirstName string `json:"firstName"binding:"required"`
-	LastName  string `json:"lastName"binding:"required"`
-	Email     string `json:"email"binding:"required"`
-	Password  string `json:"password"binding:"required"`
+	FirstName string `json:"firstName" binding:"required"`
+	LastName  string `json:"lastName" binding:"required"`
+	Email     string `json:"email" binding:"required"`
+	Password  string `json:"password" binding:"required"`
 	Phone     string `json:"phone"`
+	// InviteToken optionally accepts an organization invitation sent to the
+	// same address
+	InviteToken string `json:"inviteToken"`
 }
 
 type UserResponse struct {
@@ -16,22 +21,138 @@ type UserResponse struct {
 	Phone     string `json:"phone,omitempty"`
 }
 type CreateUserResponse struct {
-	AccessToken string       `json:"accessToken"`
-	User        UserResponse `json:"user"`
+	AccessToken        string                      `json:"accessToken"`
+	RefreshToken       string                      `json:"refreshToken"`
+	User               UserResponse                `json:"user"`
+	JoinedOrganization *OrganizationMemberResponse `json:"joinedOrganization,omitempty"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"binding:"required"`
-	Password string `json:"password"binding:"required"`
+	Email       string `json:"email" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+	InviteToken string `json:"inviteToken"`
 }
 
 type LoginResponse struct {
-	AccessToken string `json:"accessToken"`
-	User        struct {
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// MfaRequired is set instead of the tokens when the user has MFA enabled;
+	// MfaToken must then be exchanged at /auth/login/mfa
+	MfaRequired bool   `json:"mfaRequired,omitempty"`
+	MfaToken    string `json:"mfaToken,omitempty"`
+	// MfaEnrollmentRequired means an organization of the user requires MFA
+	// and the user has not enrolled yet
+	MfaEnrollmentRequired bool `json:"mfaEnrollmentRequired,omitempty"`
+	User                  *struct {
 		UserId    string `json:"userId"`
 		FirstName string `json:"firstName"`
 		LastName  string `json:"lastName"`
 		Email     string `json:"email"`
 		Phone     string `json:"phone"`
-	} `json:"user"`
+	} `json:"user,omitempty"`
+	// JoinedOrganization is set when the login accepted an invitation
+	JoinedOrganization *OrganizationMemberResponse `json:"joinedOrganization,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type EnrollTotpResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioningUri"`
+}
+
+type ConfirmTotpRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MfaCodeRequest carries either a TOTP code or a recovery code.
+type MfaCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type LoginMfaRequest struct {
+	MfaToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+	InviteToken  string `json:"inviteToken"`
+}
+
+// UpdateProfileRequest changes only the fields that are present. An empty
+// phone clears it.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"firstName" binding:"omitnil,min=1,max=100"`
+	LastName  *string `json:"lastName" binding:"omitnil,min=1,max=100"`
+	Phone     *string `json:"phone" binding:"omitnil,phone"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=8,max=72"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required,email,max=100"`
+	Password string `json:"password" binding:"required"`
+}
+
+type EmailChangeResponse struct {
+	NewEmail  string    `json:"newEmail"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=user:read user:write organizations:read organizations:write"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"required,min=1,max=365"`
+}
+
+type PersonalAccessTokenResponse struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIp string     `json:"lastUsedIp"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// CreatePersonalAccessTokenResponse carries the token itself, which is only
+// ever shown here.
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessTokenResponse
+	Token string `json:"token"`
 }
@@ -5,12 +5,19 @@ const (
 	InternalServerError = "Something went wrong"
 	UnAuthorized        = "Unauthorized"
 	UserNotFound        = "User not found"
+	TooManyRequests     = "Too many requests"
 )
 
 type ApiError struct {
 	Status     string `json:"status"`
 	Message    string `json:"message"`
 	StatusCode int    `json:"statusCode"`
+	// RequestId identifies the request in the logs; it is filled in when the
+	// error is written
+	RequestId string `json:"requestId,omitempty"`
+	// RetryAfter is the number of seconds to wait before retrying, sent as
+	// the Retry-After header
+	RetryAfter int `json:"retryAfter,omitempty"`
 }
 
 type FieldError struct {
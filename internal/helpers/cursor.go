@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// EncodeCursor turns a keyset position into an opaque, URL-safe cursor.
+// Clients must treat it as a black box.
+func EncodeCursor(position any) string {
+	raw, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor reverses EncodeCursor into position.
+func DecodeCursor(cursor string, position any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, position)
+}
@@ -5,30 +5,60 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/iancoleman/strcase"
 	"h-two/internal/errors"
+	"h-two/internal/logging"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+// AbortWithError writes err as the response, stamped with the request ID,
+// and stops the handler chain.
+func AbortWithError(c *gin.Context, err *errors.ApiError) {
+	stamped := *err
+	stamped.RequestId = logging.RequestID(c.Request.Context())
+	if stamped.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(stamped.RetryAfter))
+	}
+	c.AbortWithStatusJSON(stamped.StatusCode, &stamped)
+}
+
 func ParseRequestBody(c *gin.Context, req interface{}) any {
 	if bindErr := c.ShouldBindJSON(&req); bindErr != nil {
-		if validationErrs, ok := bindErr.(validator.ValidationErrors); ok {
-			// Handle validation errors
-			var res []errors.FieldError
-			for _, e := range validationErrs {
-				// Extract the field name and the error message
-				fieldName := strings.Split(e.Namespace(), ".")[1]
-				fieldName = strcase.ToLowerCamel(fieldName)
-				errorMessage := e.ActualTag()
-				// Translate each error one at a time
-				res = append(res, errors.FieldError{Field: fieldName, Message: errorMessage})
-			}
-			c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": res})
-
-		} else {
+		if !writeValidationErrors(c, bindErr) {
 			// Handle other errors (like invalid JSON)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "requestId": logging.RequestID(c.Request.Context())})
+		}
+		return bindErr
+	}
+	return nil
+}
+
+// ParseQuery binds and validates query string parameters using `form` tags.
+func ParseQuery(c *gin.Context, req interface{}) any {
+	if bindErr := c.ShouldBindQuery(req); bindErr != nil {
+		if !writeValidationErrors(c, bindErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "requestId": logging.RequestID(c.Request.Context())})
 		}
 		return bindErr
 	}
 	return nil
 }
+
+func writeValidationErrors(c *gin.Context, bindErr error) bool {
+	validationErrs, ok := bindErr.(validator.ValidationErrors)
+	if !ok {
+		return false
+	}
+	// Handle validation errors
+	var res []errors.FieldError
+	for _, e := range validationErrs {
+		// Extract the field name and the error message
+		fieldName := strings.Split(e.Namespace(), ".")[1]
+		fieldName = strcase.ToLowerCamel(fieldName)
+		errorMessage := e.ActualTag()
+		// Translate each error one at a time
+		res = append(res, errors.FieldError{Field: fieldName, Message: errorMessage})
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": res, "requestId": logging.RequestID(c.Request.Context())})
+	return true
+}
@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"regexp"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{3,19}$`)
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		_ = v.RegisterValidation("phone", validatePhone)
+	}
+}
+
+// validatePhone accepts an optional leading plus followed by digits, spaces,
+// dashes and parentheses. An empty value passes so the number can be cleared.
+func validatePhone(fl validator.FieldLevel) bool {
+	phone := fl.Field().String()
+	return phone == "" || phonePattern.MatchString(phone)
+}
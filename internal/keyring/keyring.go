@@ -0,0 +1,329 @@
+// Package keyring manages the asymmetric keys used to sign access tokens.
+//
+// A keyring directory holds one PKCS#8 PEM file per key plus a keyring.json
+// manifest naming the active key. Only the active key signs new tokens;
+// previous keys are kept so tokens they signed stay valid until they expire.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"h-two/internal/helpers"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	manifestFile = "keyring.json"
+	// reloadInterval limits how often an unknown kid triggers a reload from
+	// disk, so garbage tokens cannot make us hit the filesystem constantly.
+	reloadInterval = 30 * time.Second
+	// manifestCheckInterval limits how often signing and serving the JWKS
+	// look at the manifest for a rotation, keeping the filesystem off the
+	// login path.
+	manifestCheckInterval = 2 * time.Second
+)
+
+var ErrNoKeys = errors.New("keyring: no signing keys found")
+
+type Key struct {
+	Kid       string
+	Alg       string
+	CreatedAt time.Time
+	Private   crypto.Signer
+}
+
+type manifestEntry struct {
+	Kid       string    `json:"kid"`
+	Alg       string    `json:"alg"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type manifest struct {
+	Active string          `json:"active"`
+	Keys   []manifestEntry `json:"keys"`
+}
+
+type Keyring struct {
+	mu         sync.RWMutex
+	dir        string
+	active     *Key
+	keys       map[string]*Key
+	lastReload time.Time
+	// loaded is the manifest the keys were read from, to notice rotations
+	loaded    os.FileInfo
+	lastCheck time.Time
+}
+
+// GenerateKey creates a new key for the given algorithm.
+func GenerateKey(alg string) (*Key, error) {
+	kid, err := helpers.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	var private crypto.Signer
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("keyring: unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Kid: kid, Alg: alg, CreatedAt: time.Now().UTC(), Private: private}, nil
+}
+
+// New builds an in-memory keyring. The first key is the active one.
+func New(active *Key, previous ...*Key) *Keyring {
+	k := &Keyring{active: active, keys: map[string]*Key{active.Kid: active}}
+	for _, key := range previous {
+		k.keys[key.Kid] = key
+	}
+	return k
+}
+
+// Load reads the keyring stored in dir.
+func Load(dir string) (*Keyring, error) {
+	k := &Keyring{dir: dir}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the keyring from disk, picking up rotations done by the CLI
+// or another instance.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	// Stat before reading, so a rotation in between is noticed next time
+	info, err := os.Stat(filepath.Join(k.dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoKeys
+	}
+	if err != nil {
+		return err
+	}
+	active, keys, err := readDir(k.dir)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.keys = keys
+	k.lastReload = time.Now()
+	k.lastCheck = k.lastReload
+	k.loaded = info
+	return nil
+}
+
+// refresh reloads the keyring if the manifest changed since it was read,
+// looking at most once per manifestCheckInterval. An instance only signs
+// with its own active key, so without this it would never notice a rotation.
+// If the reload fails the current keys are kept.
+func (k *Keyring) refresh() {
+	if k.dir == "" {
+		return
+	}
+	k.mu.Lock()
+	if time.Since(k.lastCheck) < manifestCheckInterval {
+		k.mu.Unlock()
+		return
+	}
+	k.lastCheck = time.Now()
+	loaded := k.loaded
+	k.mu.Unlock()
+	info, err := os.Stat(filepath.Join(k.dir, manifestFile))
+	if err != nil {
+		return
+	}
+	// Rotate replaces the manifest by renaming a new file over it
+	if loaded != nil && os.SameFile(loaded, info) && loaded.ModTime().Equal(info.ModTime()) && loaded.Size() == info.Size() {
+		return
+	}
+	k.Reload()
+}
+
+func readDir(dir string) (*Key, map[string]*Key, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNoKeys
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, nil, fmt.Errorf("keyring: invalid manifest: %w", err)
+	}
+	keys := make(map[string]*Key, len(m.Keys))
+	for _, entry := range m.Keys {
+		private, err := readPrivateKey(filepath.Join(dir, entry.Kid+".pem"))
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[entry.Kid] = &Key{Kid: entry.Kid, Alg: entry.Alg, CreatedAt: entry.CreatedAt, Private: private}
+	}
+	active, ok := keys[m.Active]
+	if !ok {
+		return nil, nil, ErrNoKeys
+	}
+	return active, keys, nil
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("keyring: %s is not PEM encoded", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("keyring: %s does not hold a signing key", path)
+	}
+	return signer, nil
+}
+
+// Rotate generates a new active key in dir, keeping at most keep previous
+// keys for verification. The new key is written before the manifest so a
+// concurrent reader never sees a manifest pointing at a missing file.
+func Rotate(dir string, alg string, keep int) (*Key, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	var m manifest
+	raw, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err == nil {
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("keyring: invalid manifest: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, key.Kid+".pem"), pemBytes, 0o600); err != nil {
+		return nil, err
+	}
+
+	// Newest first
+	entries := append([]manifestEntry{{Kid: key.Kid, Alg: key.Alg, CreatedAt: key.CreatedAt}}, m.Keys...)
+	var dropped []manifestEntry
+	if len(entries) > keep+1 {
+		dropped = entries[keep+1:]
+		entries = entries[:keep+1]
+	}
+	out, err := json.MarshalIndent(manifest{Active: key.Kid, Keys: entries}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, manifestFile)); err != nil {
+		return nil, err
+	}
+	for _, entry := range dropped {
+		os.Remove(filepath.Join(dir, entry.Kid+".pem"))
+	}
+	return key, nil
+}
+
+// Keys returns every key in the ring, active key first.
+func (k *Keyring) Keys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := []*Key{k.active}
+	for kid, key := range k.keys {
+		if kid != k.active.Kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Sign signs the claims with the active key and stamps its kid in the header.
+// A key rotated in on disk becomes active here without a restart.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.refresh()
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+	token := jwt.NewWithClaims(signingMethod(active.Alg), claims)
+	token.Header["kid"] = active.Kid
+	return token.SignedString(active.Private)
+}
+
+func (k *Keyring) lookup(kid string) (*Key, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.lastReload) > reloadInterval
+	k.mu.RUnlock()
+	if !ok && stale && k.dir != "" {
+		// Another instance may have rotated since we last looked
+		if err := k.Reload(); err == nil {
+			k.mu.RLock()
+			key, ok = k.keys[kid]
+			k.mu.RUnlock()
+		}
+	}
+	return key, ok
+}
+
+// Parse verifies a token against the key named by its kid header. The
+// algorithm must match the key's, so a token cannot pick a weaker one.
+func (k *Keyring) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("keyring: unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.Alg {
+			return nil, fmt.Errorf("keyring: unexpected signing method %v", token.Header["alg"])
+		}
+		return key.Private.Public(), nil
+	})
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"log/slog"
+	"time"
+)
+
+// GormLogger sends GORM's query log to slog. Queries are logged with their
+// placeholders only, so bound values such as password hashes and emails
+// never reach the log. Failed queries are errors, queries slower than the
+// threshold are warnings and everything else is logged at debug level.
+type GormLogger struct {
+	logger        *slog.Logger
+	slowThreshold time.Duration
+	silent        bool
+}
+
+func NewGormLogger(logger *slog.Logger, slowThreshold time.Duration) *GormLogger {
+	return &GormLogger{logger: logger.With("component", "gorm"), slowThreshold: slowThreshold}
+}
+
+func (l *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	copied := *l
+	copied.silent = level == gormlogger.Silent
+	return &copied
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelInfo, fmt.Sprintf(msg, args...))
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelWarn, fmt.Sprintf(msg, args...))
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelError, fmt.Sprintf(msg, args...))
+}
+
+func (l *GormLogger) log(ctx context.Context, level slog.Level, msg string, attrs ...any) {
+	if !l.silent {
+		l.logger.Log(ctx, level, msg, attrs...)
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	level, msg := slog.LevelDebug, "Query"
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		level, msg = slog.LevelError, "Query failed"
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold:
+		level, msg = slog.LevelWarn, "Slow query"
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration", elapsed}
+	if level == slog.LevelError {
+		attrs = append(attrs, "error", err)
+	}
+	l.logger.Log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter drops the bound values from logged queries.
+func (l *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
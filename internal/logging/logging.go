@@ -0,0 +1,93 @@
+// Package logging builds the structured logger shared by the server,
+// services and database layer. Every record is stamped with the ID of the
+// request it was logged for, and with its trace and span IDs when traced.
+// Passwords, tokens and email addresses are redacted before anything is
+// written.
+package logging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"h-two/internal/config"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger writing to w in the configured format and level.
+func New(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(cfg.Level)}
+	var inner slog.Handler
+	if cfg.Format == "text" {
+		inner = slog.NewTextHandler(w, opts)
+	} else {
+		inner = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&handler{inner: inner})
+}
+
+// Discard returns a logger that drops everything, for tests and tools.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+func parseLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// handler redacts every record and adds the request ID from the context it
+// is logged with.
+type handler struct {
+	inner slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	if id := RequestID(ctx); id != "" {
+		redacted.AddAttrs(slog.String("requestId", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		redacted.AddAttrs(slog.String("traceId", span.TraceID().String()), slog.String("spanId", span.SpanID().String()))
+	}
+	return h.inner.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &handler{inner: h.inner.WithAttrs(redacted)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{inner: h.inner.WithGroup(name)}
+}
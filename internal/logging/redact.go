@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values are never logged.
+// Keys are compared in lower case with separators removed, so "new_password"
+// and "refreshToken" both match.
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie", "recoverycode", "totp"}
+
+// sensitiveExactKeys only match whole keys, since the fragments are common
+// in harmless keys such as "statusCode".
+var sensitiveExactKeys = []string{"code", "dsn"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(key))
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	for _, exact := range sensitiveExactKeys {
+		if key == exact {
+			return true
+		}
+	}
+	return false
+}
+
+// redactString masks the local part of every email address in s, keeping
+// the domain, which is usually enough to follow a problem.
+func redactString(s string) string {
+	return emailPattern.ReplaceAllString(s, "***@$1")
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, a := range group {
+			attrs[i] = redactAttr(a)
+		}
+		return slog.Group(attr.Key, attrs...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, redactString(v.String()))
+		default:
+			return slog.Any(attr.Key, redactValue(v))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// redactValue logs structs and maps by their JSON form, with sensitive
+// fields and email addresses redacted like attributes.
+func redactValue(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return redactString(fmt.Sprintf("%v", v))
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return redactString(string(data))
+	}
+	return redactJSON(decoded)
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if isSensitiveKey(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	case string:
+		return redactString(v)
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"fmt"
+	"h-two/internal/config"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers transactional email such as password reset links.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// LogSender writes messages to a writer instead of delivering them. It is
+// meant for local development, where the links can be copied from the log.
+type LogSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *LogSender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "----- mail %s -----\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+func NewLogSender(w io.Writer) *LogSender {
+	return &LogSender{w: w}
+}
+
+// SMTPSender delivers messages through an SMTP relay using PLAIN auth.
+type SMTPSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Addr, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		s.From, msg.To, msg.Subject, msg.Body)
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, []byte(body))
+}
+
+// NewSender builds the sender selected by the mail driver. "smtp" delivers
+// through the configured SMTP server; "log" writes messages to the log file,
+// or to stdout when no file is configured.
+func NewSender(cfg config.MailConfig) (Sender, error) {
+	if cfg.Driver == "smtp" {
+		return &SMTPSender{
+			Addr:     fmt.Sprintf("%s:%d", cfg.SMTP.Host, cfg.SMTP.Port),
+			From:     cfg.From,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+		}, nil
+	}
+	if cfg.LogFile == "" {
+		return NewLogSender(os.Stdout), nil
+	}
+	f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogSender(f), nil
+}
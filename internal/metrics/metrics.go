@@ -0,0 +1,93 @@
+// Package metrics defines the Prometheus metrics h-two exposes on /metrics.
+// They live in their own registry, alongside the Go runtime and process
+// collectors, so tests and tools importing the packages stay isolated from
+// the global default registry.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "htwo"
+
+// Results recorded by the login and registration counters.
+const (
+	ResultSuccess     = "success"
+	ResultMfaRequired = "mfa_required"
+	// ResultRejected is a client error, such as a wrong password
+	ResultRejected = "rejected"
+	// ResultError is a server error
+	ResultError = "error"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by step (password or mfa) and result.",
+	}, []string{"step", "result"})
+
+	Registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Registrations by result.",
+	}, []string{"result"})
+
+	BcryptDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bcrypt_duration_seconds",
+		Help:      "Time spent hashing and verifying passwords.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	DbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "GORM statement latency by operation and table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HttpRequests,
+		HttpRequestDuration,
+		Logins,
+		Registrations,
+		BcryptDuration,
+		DbQueryDuration,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Result maps the HTTP status of a failed operation to a result label.
+func Result(statusCode int) string {
+	if statusCode >= http.StatusInternalServerError {
+		return ResultError
+	}
+	return ResultRejected
+}
@@ -1,19 +1,82 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/keyring"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 )
 
-func AuthMiddleware(c *gin.Context) {
+// PersonalAccessTokenAuthenticator is the part of
+// services.PersonalAccessTokenService AuthMiddleware needs.
+type PersonalAccessTokenAuthenticator interface {
+	AuthenticatePersonalAccessToken(ctx context.Context, raw string) (*models.PersonalAccessToken, *errors.ApiError)
+}
+
+// AuthMiddleware returns a handler that authenticates the bearer access token
+// against the signing keyring and rejects tokens that were revoked through
+// logout. Personal access tokens are accepted too, but only when the route
+// lists scopes and the token was granted all of them; without scopes the
+// route is for signed-in sessions only.
+func AuthMiddleware(keys *keyring.Keyring, revocations repository.TokenRevocationRepository, tokens PersonalAccessTokenAuthenticator, scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer "+models.PersonalAccessTokenPrefix) {
+			authenticatePersonalAccessToken(c, tokens, strings.TrimPrefix(header, "Bearer "), scopes)
+			return
+		}
+		authenticate(c, keys, revocations)
+	}
+}
+
+func authenticatePersonalAccessToken(c *gin.Context, tokens PersonalAccessTokenAuthenticator, raw string, scopes []string) {
+	if tokens == nil {
+		helpers.AbortWithError(c, &errors.ApiError{
+			Message:    "Invalid Token",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		})
+		return
+	}
+	token, apiErr := tokens.AuthenticatePersonalAccessToken(c.Request.Context(), raw)
+	if apiErr != nil {
+		helpers.AbortWithError(c, apiErr)
+		return
+	}
+	if len(scopes) == 0 {
+		helpers.AbortWithError(c, &errors.ApiError{
+			Message:    "Personal access tokens cannot be used here, sign in instead",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		})
+		return
+	}
+	if !token.HasScopes(scopes...) {
+		helpers.AbortWithError(c, &errors.ApiError{
+			Message:    fmt.Sprintf("Token is missing the %s scope", strings.Join(scopes, ", ")),
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		})
+		return
+	}
+	c.Set("userId", token.UserId)
+	c.Set("personalAccessTokenId", token.Id)
+	c.Set("mfa", token.Mfa)
+	c.Next()
+}
+
+func authenticate(c *gin.Context, keys *keyring.Keyring, revocations repository.TokenRevocationRepository) {
 	tokenStr := c.GetHeader("Authorization")
 	if tokenStr == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, errors.ApiError{
+		helpers.AbortWithError(c, &errors.ApiError{
 			Message:    "Invalid Token",
 			StatusCode: http.StatusUnauthorized,
 			Status:     errors.UnAuthorized,
@@ -21,7 +84,7 @@ func AuthMiddleware(c *gin.Context) {
 		return
 	}
 	if !strings.HasPrefix(tokenStr, "Bearer ") {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, errors.ApiError{
+		helpers.AbortWithError(c, &errors.ApiError{
 			Message:    "Invalid Token",
 			StatusCode: http.StatusUnauthorized,
 			Status:     errors.UnAuthorized,
@@ -29,20 +92,9 @@ func AuthMiddleware(c *gin.Context) {
 		return
 	}
 	tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, errors.ApiError{
-			Message:    "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-			Status:     errors.InternalServerError,
-		})
-		return
-	}
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
+	token, err := keys.Parse(tokenStr)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, errors.ApiError{
+		helpers.AbortWithError(c, &errors.ApiError{
 			Message:    "Unauthorized",
 			StatusCode: http.StatusUnauthorized,
 			Status:     errors.UnAuthorized,
@@ -53,7 +105,7 @@ func AuthMiddleware(c *gin.Context) {
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		if exp, ok := claims["exp"].(float64); ok {
 			if time.Now().Unix() > int64(exp) {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, errors.ApiError{
+				helpers.AbortWithError(c, &errors.ApiError{
 					Message:    "Token has expired",
 					StatusCode: http.StatusUnauthorized,
 					Status:     errors.UnAuthorized,
@@ -61,17 +113,52 @@ func AuthMiddleware(c *gin.Context) {
 				return
 			}
 		} else {
-			c.AbortWithStatusJSON(http.StatusBadRequest, errors.ApiError{
+			helpers.AbortWithError(c, &errors.ApiError{
 				Message:    "Invalid Token",
 				StatusCode: http.StatusBadRequest,
 				Status:     errors.UnAuthorized,
 			})
 			return
 		}
-		c.Set("userId", claims["userId"])
+		jti, _ := claims["jti"].(string)
+		userId, _ := claims["userId"].(string)
+		if jti == "" || userId == "" || claims["typ"] != services.AccessTokenType {
+			helpers.AbortWithError(c, &errors.ApiError{
+				Message:    "Invalid Token",
+				StatusCode: http.StatusUnauthorized,
+				Status:     errors.UnAuthorized,
+			})
+			return
+		}
+		sid, _ := claims["sid"].(string)
+		revoked, err := revocations.IsTokenRevoked(c.Request.Context(), jti, userId, sid, services.TokenIssuedAt(claims))
+		if err != nil {
+			helpers.AbortWithError(c, &errors.ApiError{
+				Message:    "Internal server error",
+				StatusCode: http.StatusInternalServerError,
+				Status:     errors.InternalServerError,
+			})
+			return
+		}
+		if revoked {
+			helpers.AbortWithError(c, &errors.ApiError{
+				Message:    "Token has been revoked",
+				StatusCode: http.StatusUnauthorized,
+				Status:     errors.UnAuthorized,
+			})
+			return
+		}
+		c.Set("userId", userId)
+		c.Set("jti", jti)
+		c.Set("tokenExpiresAt", time.Unix(int64(claims["exp"].(float64)), 0))
+		if sid != "" {
+			c.Set("sessionId", sid)
+		}
+		mfa, _ := claims["mfa"].(bool)
+		c.Set("mfa", mfa)
 
 	} else {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, errors.ApiError{
+		helpers.AbortWithError(c, &errors.ApiError{
 			Message:    "Unauthorized",
 			StatusCode: http.StatusUnauthorized,
 			Status:     errors.UnAuthorized,
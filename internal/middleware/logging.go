@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/logging"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits the IDs accepted from clients, so a header cannot
+// inject arbitrary text into the logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestID takes the request ID from the X-Request-ID header, or generates
+// one, and echoes it in the response. Everything logged with the request
+// context is stamped with it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id, _ = helpers.GenerateOpaqueToken(16)
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// AccessLog logs one line per request once it completes. The query string is
+// left out since it can carry tokens.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"clientIp", c.ClientIP(),
+		}
+		if userId := c.GetString("userId"); userId != "" {
+			attrs = append(attrs, "userId", userId)
+		}
+		logger.Log(c.Request.Context(), level, "Request completed", attrs...)
+	}
+}
+
+// Recovery turns a panic into a logged 500 response.
+func Recovery(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		logger.ErrorContext(c.Request.Context(), "Request panicked", "panic", recovered)
+		helpers.AbortWithError(c, &errors.ApiError{
+			Status:     "error",
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+		})
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"h-two/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// standardMethods are the methods recorded under their own name; any other
+// method is recorded as OTHER.
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Metrics records the count and latency of requests per route template.
+// Requests matching no route share one label, and methods outside the
+// standard set share another, so scanners cannot blow up the number of
+// series.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		if !standardMethods[method] {
+			method = "OTHER"
+		}
+		metrics.HttpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HttpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+)
+
+// OrganizationMfaChecker decides whether a session may access an
+// organization given whether it was authenticated with a second factor.
+type OrganizationMfaChecker interface {
+	CheckMfaPolicy(ctx context.Context, userId string, orgId string, mfaAuthenticated bool) *errors.ApiError
+}
+
+// RequireOrganizationMfa enforces the MFA policy of the organization named by
+// the :orgId route parameter. It must run after AuthMiddleware.
+func RequireOrganizationMfa(checker OrganizationMfaChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := checker.CheckMfaPolicy(c.Request.Context(), c.GetString("userId"), c.Param("orgId"), c.GetBool("mfa"))
+		if err != nil {
+			helpers.AbortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+)
+
+// LoginIpLimiter is the part of services.LoginLimiter LoginRateLimit needs.
+type LoginIpLimiter interface {
+	CheckIp(ctx context.Context, ip string) *errors.ApiError
+}
+
+// LoginRateLimit rejects login attempts from a client address that is over
+// its limit with 429 Too Many Requests and a Retry-After header. Accounts
+// are locked out by the login itself, which knows whether it failed.
+func LoginRateLimit(limiter LoginIpLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := limiter.CheckIp(c.Request.Context(), c.ClientIP()); err != nil {
+			helpers.AbortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/services"
+	"time"
+)
+
+// RequestContext records the client on the request context for the services
+// and, if timeout is positive, cancels the work done for the request,
+// database queries included, once it runs longer than that.
+func RequestContext(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := services.WithClientInfo(c.Request.Context(), services.ClientInfo{
+			IpAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+)
+
+// VerifiedEmailChecker decides whether a user may perform actions that need
+// a verified email address.
+type VerifiedEmailChecker interface {
+	RequireVerifiedEmail(ctx context.Context, userId string) *errors.ApiError
+}
+
+// RequireVerifiedEmail blocks the request when the authenticated user has
+// not verified their email address. It must run after AuthMiddleware.
+func RequireVerifiedEmail(checker VerifiedEmailChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checker.RequireVerifiedEmail(c.Request.Context(), c.GetString("userId")); err != nil {
+			helpers.AbortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
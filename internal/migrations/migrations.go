@@ -0,0 +1,297 @@
+// Package migrations applies the versioned SQL migrations embedded in the
+// binary and records them in the schema_migrations table.
+//
+// Migrations live in sql/ as pairs of NNNN_name.up.sql and NNNN_name.down.sql
+// files and are applied in version order, each in its own transaction.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey identifies the advisory lock that serializes concurrent migrators.
+const lockKey = 72_616_732
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a migration and when it was applied, if it has been.
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// SchemaBehindError is returned by Migrator.Check when migrations are pending.
+type SchemaBehindError struct {
+	Pending []*Migration
+}
+
+func (e *SchemaBehindError) Error() string {
+	return fmt.Sprintf("database schema is behind: %d pending migration(s), starting with %04d_%s",
+		len(e.Pending), e.Pending[0].Version, e.Pending[0].Name)
+}
+
+// Load parses migrations from fsys, ordered by version. Every version needs
+// both an up and a down file.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Embedded returns the migrations compiled into the binary.
+func Embedded() ([]*Migration, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []*Migration
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`).Error
+}
+
+func (m *Migrator) applied(tx *gorm.DB) (map[int64]time.Time, error) {
+	var rows []struct {
+		Version   int64
+		AppliedAt time.Time
+	}
+	if err := tx.Raw("SELECT version, applied_at FROM schema_migrations").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// Status lists every known migration with the time it was applied.
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied, in order.
+func (m *Migrator) Pending() ([]*Migration, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Check returns a *SchemaBehindError if any migration is pending.
+func (m *Migrator) Check() error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return &SchemaBehindError{Pending: pending}
+	}
+	return nil
+}
+
+// Up applies up to steps pending migrations, or all of them if steps is 0,
+// and returns the ones it applied.
+func (m *Migrator) Up(steps int) ([]*Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+	var done []*Migration
+	for _, migration := range pending {
+		applied, err := m.run(migration, true)
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if applied {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns the ones it rolled back.
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	var done []*Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		rolledBack, err := m.run(migration, false)
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if rolledBack {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// run applies or rolls back one migration in a transaction holding the
+// migration lock. It reports false if another migrator got there first.
+func (m *Migrator) run(migration *Migration, up bool) (bool, error) {
+	ran := false
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Raw("SELECT count(*) FROM schema_migrations WHERE version = ?", migration.Version).Scan(&count).Error; err != nil {
+			return err
+		}
+		if alreadyApplied := count > 0; alreadyApplied == up {
+			return nil
+		}
+		if up {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error; err != nil {
+				return err
+			}
+		}
+		ran = true
+		return nil
+	})
+	return ran, err
+}
+
+// New returns a migrator for the given migrations, usually Embedded().
+func New(db *gorm.DB, migrations []*Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+var createName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create writes an empty up and down file for a new migration to dir,
+// numbered after the highest version already there, and returns their paths.
+func Create(dir string, name string) (string, string, error) {
+	if !createName.MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q must be lower case letters, digits and underscores", name)
+	}
+	existing, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := int64(1)
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+	up, down := base+".up.sql", base+".down.sql"
+	if err := os.WriteFile(up, []byte("-- "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- Undo "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
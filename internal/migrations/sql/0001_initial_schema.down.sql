@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS email_changes;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_tokens;
+DROP TABLE IF EXISTS user_token_revocations;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS user_organizations;
+DROP TABLE IF EXISTS organizations;
+DROP TABLE IF EXISTS users;
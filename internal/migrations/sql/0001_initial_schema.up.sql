@@ -0,0 +1,160 @@
+-- Baseline of the schema previously created by GORM AutoMigrate. Every
+-- statement is idempotent so databases set up by hand can adopt it, and
+-- columns added after a table first appeared are added separately because
+-- CREATE TABLE IF NOT EXISTS leaves an older table as it is.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id uuid DEFAULT uuid_generate_v4(),
+    email varchar(100) NOT NULL UNIQUE,
+    password varchar(100) NOT NULL,
+    first_name varchar(100) NOT NULL,
+    last_name varchar(100) NOT NULL,
+    phone varchar(100) NOT NULL,
+    email_verified_at timestamptz,
+    totp_secret varchar(64) NOT NULL DEFAULT '',
+    totp_last_step bigint NOT NULL DEFAULT 0,
+    mfa_enabled_at timestamptz,
+    deletion_scheduled_at timestamptz,
+    PRIMARY KEY (id, user_id)
+);
+-- Accounts that predate email verification were never asked to verify, so
+-- they count as verified from the day they signed up
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'email_verified_at') THEN
+        ALTER TABLE users ADD COLUMN email_verified_at timestamptz;
+        UPDATE users SET email_verified_at = COALESCE(created_at, now());
+    END IF;
+END $$;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users (deletion_scheduled_at);
+
+CREATE TABLE IF NOT EXISTS organizations (
+    org_id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    name varchar(100) NOT NULL,
+    description varchar(100) NOT NULL,
+    owner uuid NOT NULL,
+    require_mfa boolean NOT NULL DEFAULT false,
+    deleted_at timestamptz
+);
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_mfa boolean NOT NULL DEFAULT false;
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_organizations_deleted_at ON organizations (deleted_at);
+
+CREATE TABLE IF NOT EXISTS user_organizations (
+    org_id uuid,
+    user_id uuid,
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    role varchar(20) NOT NULL DEFAULT 'member',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+ALTER TABLE user_organizations ADD COLUMN IF NOT EXISTS role varchar(20) NOT NULL DEFAULT 'member';
+ALTER TABLE user_organizations ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid PRIMARY KEY,
+    user_id uuid NOT NULL,
+    family_id uuid NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    replaced_by uuid,
+    mfa boolean NOT NULL DEFAULT false,
+    created_at timestamptz
+);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS mfa boolean NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti varchar(64) PRIMARY KEY,
+    user_id uuid NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id uuid PRIMARY KEY,
+    revoked_before timestamptz NOT NULL,
+    except_session_id varchar(64) NOT NULL DEFAULT ''
+);
+ALTER TABLE user_token_revocations ADD COLUMN IF NOT EXISTS except_session_id varchar(64) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    purpose varchar(32) NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    code_hash varchar(64) NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    org_id uuid NOT NULL,
+    email varchar(100) NOT NULL,
+    role varchar(20) NOT NULL,
+    invited_by uuid NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    accepted_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_invitations_org_id ON invitations (org_id);
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations (email);
+
+CREATE TABLE IF NOT EXISTS email_changes (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    old_email varchar(100) NOT NULL,
+    new_email varchar(100) NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    cancel_token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    confirmed_at timestamptz,
+    cancelled_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_email_changes_user_id ON email_changes (user_id);
+CREATE INDEX IF NOT EXISTS idx_email_changes_new_email ON email_changes (new_email);
+
+CREATE TABLE IF NOT EXISTS login_events (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    method varchar(20) NOT NULL,
+    ip_address varchar(45) NOT NULL DEFAULT '',
+    user_agent varchar(255) NOT NULL DEFAULT '',
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id ON login_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events (created_at);
+
+-- Memberships created before roles existed default to member; give each
+-- organization's owner their role back
+UPDATE user_organizations SET role = 'owner' FROM organizations
+WHERE organizations.org_id = user_organizations.org_id
+  AND organizations.owner = user_organizations.user_id
+  AND user_organizations.role <> 'owner';
@@ -0,0 +1,2 @@
+-- Undo login_throttles
+DROP TABLE IF EXISTS login_throttles;
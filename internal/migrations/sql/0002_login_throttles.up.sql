@@ -0,0 +1,11 @@
+-- login_throttles: login attempt counters and account lockouts, used when
+-- the login limit store is postgres
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key varchar(320) PRIMARY KEY,
+    attempts integer NOT NULL DEFAULT 0,
+    window_ends timestamptz NOT NULL,
+    lockouts integer NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_login_throttles_updated_at ON login_throttles (updated_at);
@@ -0,0 +1,2 @@
+-- Undo personal_access_tokens
+DROP TABLE IF EXISTS personal_access_tokens;
@@ -0,0 +1,16 @@
+-- personal_access_tokens: hashed, scoped tokens users create for scripts and
+-- CI
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    scopes varchar(255) NOT NULL,
+    mfa boolean NOT NULL DEFAULT false,
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz,
+    last_used_ip varchar(45) NOT NULL DEFAULT '',
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
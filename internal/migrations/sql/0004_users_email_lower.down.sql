@@ -0,0 +1,2 @@
+-- Undo users_email_lower
+DROP INDEX IF EXISTS idx_users_email_lower;
@@ -0,0 +1,4 @@
+-- users_email_lower: email addresses are unique regardless of case, so
+-- Jane@example.com and jane@example.com cannot belong to two accounts.
+-- Fails if such duplicates already exist; merge or rename them first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
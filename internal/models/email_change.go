@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// EmailChange is a pending request to move a user to a new address. The
+// confirmation token is sent to NewEmail and the cancel token to OldEmail;
+// only their hashes are stored.
+type EmailChange struct {
+	Id              string     `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	UserId          string     `json:"userId" gorm:"type:uuid;not null;index"`
+	OldEmail        string     `json:"oldEmail" gorm:"type:varchar(100);not null"`
+	NewEmail        string     `json:"newEmail" gorm:"type:varchar(100);not null;index"`
+	TokenHash       string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	CancelTokenHash string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	ExpiresAt       time.Time  `json:"expiresAt" gorm:"not null"`
+	ConfirmedAt     *time.Time `json:"confirmedAt"`
+	CancelledAt     *time.Time `json:"cancelledAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
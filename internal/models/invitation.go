@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Invitation lets an owner or admin invite someone by email, whether or not
+// they have an account yet. Only the hash of the emailed token is stored.
+type Invitation struct {
+	Id         string     `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	OrgId      string     `json:"orgId" gorm:"type:uuid;not null;index"`
+	Email      string     `json:"email" gorm:"type:varchar(100);not null;index"`
+	Role       string     `json:"role" gorm:"type:varchar(20);not null"`
+	InvitedBy  string     `json:"invitedBy" gorm:"type:uuid;not null"`
+	TokenHash  string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`
+	AcceptedAt *time.Time `json:"acceptedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Ways a user can start a session, as recorded in their login history.
+const (
+	LoginMethodPassword     = "password"
+	LoginMethodMfa          = "mfa"
+	LoginMethodRegistration = "registration"
+)
+
+// LoginEvent records a successful sign-in for the user's login history.
+type LoginEvent struct {
+	Id        string    `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	UserId    string    `json:"userId" gorm:"type:uuid;not null;index"`
+	Method    string    `json:"method" gorm:"type:varchar(20);not null"`
+	IpAddress string    `json:"ipAddress" gorm:"type:varchar(45);not null;default:''"`
+	UserAgent string    `json:"userAgent" gorm:"type:varchar(255);not null;default:''"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LoginThrottle counts login attempts for a client address or an account
+// within a fixed window, and records when an account is locked out.
+type LoginThrottle struct {
+	// Key is "ip:" followed by the address or "account:" followed by the
+	// normalized email
+	Key        string    `json:"key" gorm:"type:varchar(320);primarykey"`
+	Attempts   int       `json:"attempts" gorm:"not null;default:0"`
+	WindowEnds time.Time `json:"windowEnds" gorm:"not null"`
+	// Lockouts counts the lockouts so far, which lengthen each time
+	Lockouts    int        `json:"lockouts" gorm:"not null;default:0"`
+	LockedUntil *time.Time `json:"lockedUntil"`
+	UpdatedAt   time.Time  `json:"updatedAt" gorm:"index"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MfaRecoveryCode is a hashed one-time code that can stand in for a TOTP
+// code when the user has lost their authenticator.
+type MfaRecoveryCode struct {
+	Id        string     `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	UserId    string     `json:"userId" gorm:"type:uuid;not null;index"`
+	CodeHash  string     `json:"-" gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
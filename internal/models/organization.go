@@ -1,14 +1,49 @@
 package models
 
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Membership roles, from most to least privileged. Owners and admins manage
+// members and settings; every organization has exactly one owner.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type Organization struct {
-	OrgId       string `json:"orgId"gorm:"type:uuid;default:uuid_generate_v4();primarykey""`
-	Name        string `json:"name"gorm:"type:varchar(100);not null"`
-	Description string `json:"description"gorm:"type:varchar(100);not null"`
-	Owner       string `json:"owner"gorm:"type:uuid;not null"`
+	OrgId       string `json:"orgId" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	Name        string `json:"name" gorm:"type:varchar(100);not null"`
+	Description string `json:"description" gorm:"type:varchar(100);not null"`
+	Owner       string `json:"owner" gorm:"type:uuid;not null"`
+	RequireMfa  bool   `json:"requireMfa" gorm:"not null;default:false"`
+	// DeletedAt marks an organization deleted by its owner. It can be restored
+	// until the grace period ends, after which it is purged.
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	// Role and JoinedAt describe the requesting user's membership, filled in
+	// by queries that join it. They are not columns of organizations.
+	Role     string     `json:"role,omitempty" gorm:"->;-:migration"`
+	JoinedAt *time.Time `json:"joinedAt,omitempty" gorm:"->;-:migration"`
 }
 
 type UserOrganization struct {
-	OrgId  string `json:"orgId,omitempty"gorm:"type:uuid;references:Organization"`
-	UserId string `json:"userId"gorm:"type:uuid;references:User"`
-	Id     string `json:"id"gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	OrgId  string `json:"orgId,omitempty" gorm:"type:uuid;references:Organization"`
+	UserId string `json:"userId" gorm:"type:uuid;references:User"`
+	Id     string `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	Role   string `json:"role" gorm:"type:varchar(20);not null;default:member"`
+	// CreatedAt is when the user joined the organization
+	CreatedAt time.Time `json:"createdAt" gorm:"not null;default:now()"`
+}
+
+// OrganizationMember is a membership joined with the member's profile, as
+// returned by member listings.
+type OrganizationMember struct {
+	UserId    string    `json:"userId"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
 }
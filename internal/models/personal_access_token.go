@@ -0,0 +1,53 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, so they can
+// be told apart from JWTs and spotted by secret scanners.
+const PersonalAccessTokenPrefix = "htwo_pat_"
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeUserRead           = "user:read"
+	ScopeUserWrite          = "user:write"
+	ScopeOrganizationsRead  = "organizations:read"
+	ScopeOrganizationsWrite = "organizations:write"
+)
+
+// PersonalAccessToken is a long-lived credential a user creates for scripts
+// and CI. It only works on routes covered by its scopes. Only the hash of the
+// token is stored; Prefix keeps its first characters so users can tell their
+// tokens apart. Scopes is space separated, and Mfa records whether the token
+// was created in a session that passed MFA.
+type PersonalAccessToken struct {
+	Id         string     `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	UserId     string     `json:"userId" gorm:"type:uuid;not null;index"`
+	Name       string     `json:"name" gorm:"type:varchar(100);not null"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(16);not null"`
+	TokenHash  string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	Scopes     string     `json:"scopes" gorm:"type:varchar(255);not null"`
+	Mfa        bool       `json:"mfa" gorm:"not null;default:false"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIp string     `json:"lastUsedIp" gorm:"type:varchar(45);not null;default:''"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (t *PersonalAccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// HasScopes reports whether the token was granted every one of scopes.
+func (t *PersonalAccessToken) HasScopes(scopes ...string) bool {
+	granted := t.ScopeList()
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
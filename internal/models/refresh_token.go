@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RefreshToken is a server-side record of an opaque refresh token. Tokens
+// issued from the same login share a FamilyId so that replaying an already
+// rotated token can revoke the whole chain.
+type RefreshToken struct {
+	Id         string     `json:"id" gorm:"type:uuid;primarykey"`
+	UserId     string     `json:"userId" gorm:"type:uuid;not null;index"`
+	FamilyId   string     `json:"familyId" gorm:"type:uuid;not null;index"`
+	TokenHash  string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	ReplacedBy *string    `json:"replacedBy" gorm:"type:uuid"`
+	// Mfa records whether the session was started with a second factor
+	Mfa       bool      `json:"mfa" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"createdAt"`
+}
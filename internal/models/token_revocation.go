@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// RevokedToken blacklists a single access token by its jti until it would
+// have expired anyway.
+type RevokedToken struct {
+	Jti       string    `json:"jti" gorm:"type:varchar(64);primarykey"`
+	UserId    string    `json:"userId" gorm:"type:uuid;not null;index"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"not null;index"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserTokenRevocation invalidates every access token issued to a user at or
+// before RevokedBefore, except those of ExceptSessionId when it is set.
+type UserTokenRevocation struct {
+	UserId          string    `json:"userId" gorm:"type:uuid;primarykey"`
+	RevokedBefore   time.Time `json:"revokedBefore" gorm:"not null"`
+	ExceptSessionId string    `json:"exceptSessionId" gorm:"type:varchar(64);not null;default:''"`
+}
@@ -1,17 +1,25 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type User struct {
 	gorm.Model
-	UserId    string `json:"userId"gorm:"type:uuid;default:uuid_generate_v4();primarykey""`
-	Email     string `json:"email"gorm:"type:varchar(100);unique;not null"`
-	Password  string `json:"password"gorm:"type:varchar(100);not null"`
-	FirstName string `json:"firstName"gorm:"type:varchar(100);not null"`
-	LastName  string `json:"lastName"gorm:"type:varchar(100);not null"`
-	Phone     string `json:"phone"gorm:"type:varchar(100);not null"`
-}
-
-func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &Organization{}, &UserOrganization{})
+	UserId    string `json:"userId" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	Email     string `json:"email" gorm:"type:varchar(100);unique;not null"`
+	Password  string `json:"password" gorm:"type:varchar(100);not null"`
+	FirstName string `json:"firstName" gorm:"type:varchar(100);not null"`
+	LastName  string `json:"lastName" gorm:"type:varchar(100);not null"`
+	Phone     string `json:"phone" gorm:"type:varchar(100);not null"`
+	// EmailVerifiedAt is nil until the user follows the link sent on registration
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// TotpSecret is set when enrollment starts; MfaEnabledAt once it is confirmed
+	TotpSecret   string     `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	TotpLastStep int64      `json:"-" gorm:"not null;default:0"`
+	MfaEnabledAt *time.Time `json:"mfaEnabledAt"`
+	// DeletionScheduledAt is set when the user asks to delete their account;
+	// the account is purged once it passes unless the request is cancelled
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt" gorm:"index"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken is a hashed, single-use token emailed to a user to prove they
+// control their address, e.g. for password resets and email verification.
+type UserToken struct {
+	Id        string     `json:"id" gorm:"type:uuid;default:uuid_generate_v4();primarykey"`
+	UserId    string     `json:"userId" gorm:"type:uuid;not null;index"`
+	Purpose   string     `json:"purpose" gorm:"type:varchar(32);not null"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	ExpiresAt time.Time  `json:"expiresAt" gorm:"not null"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type EmailChangeRepository interface {
+	CreateEmailChange(ctx context.Context, change *models.EmailChange) error
+	GetEmailChangeByHash(ctx context.Context, hash string) (*models.EmailChange, error)
+	GetEmailChangeByCancelHash(ctx context.Context, hash string) (*models.EmailChange, error)
+	ApplyEmailChange(ctx context.Context, change *models.EmailChange) (bool, error)
+	CancelEmailChange(ctx context.Context, change *models.EmailChange) (bool, bool, error)
+}
+
+type DefaultEmailChangeRepository struct {
+	db *gorm.DB
+}
+
+// CreateEmailChange stores a new request and cancels any pending ones of the
+// same user, so only the latest link can be confirmed.
+func (r *DefaultEmailChangeRepository) CreateEmailChange(ctx context.Context, change *models.EmailChange) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.EmailChange{}).
+			Where("user_id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL", change.UserId).
+			Update("cancelled_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+}
+
+func (r *DefaultEmailChangeRepository) GetEmailChangeByHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	err := conn(ctx, r.db).Where("token_hash = ?", hash).First(&change).Error
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func (r *DefaultEmailChangeRepository) GetEmailChangeByCancelHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	err := conn(ctx, r.db).Where("cancel_token_hash = ?", hash).First(&change).Error
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// ApplyEmailChange moves the user to the new address and marks it verified.
+// It reports false if the request was cancelled, confirmed or expired in the
+// meantime, or if the user's address changed since it was made. Another
+// account holding the address, in any case, fails with gorm.ErrDuplicatedKey
+// through the unique index on lower(users.email), which settles races
+// between claimants.
+func (r *DefaultEmailChangeRepository) ApplyEmailChange(ctx context.Context, change *models.EmailChange) (bool, error) {
+	applied := false
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&models.EmailChange{}).
+			Where("id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > ?", change.Id, now).
+			Update("confirmed_at", now)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		res = tx.Model(&models.User{}).
+			Where("user_id = ? AND email = ?", change.UserId, change.OldEmail).
+			Updates(map[string]interface{}{"email": change.NewEmail, "email_verified_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		applied = true
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+// CancelEmailChange cancels the request. If it had already been applied the
+// user is moved back to the old address, as long as they still use the new
+// one. It reports whether the request was cancelled and whether the address
+// was reverted.
+func (r *DefaultEmailChangeRepository) CancelEmailChange(ctx context.Context, change *models.EmailChange) (bool, bool, error) {
+	cancelled, reverted := false, false
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.EmailChange{}).
+			Where("id = ? AND cancelled_at IS NULL", change.Id).
+			Update("cancelled_at", time.Now())
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		cancelled = true
+		// The row is locked now, so a concurrent confirmation has either
+		// committed or will find the request cancelled
+		var current models.EmailChange
+		if err := tx.Where("id = ?", change.Id).First(&current).Error; err != nil {
+			return err
+		}
+		if current.ConfirmedAt == nil {
+			return nil
+		}
+		res = tx.Model(&models.User{}).
+			Where("user_id = ? AND email = ?", change.UserId, change.NewEmail).
+			Update("email", change.OldEmail)
+		if res.Error != nil {
+			return res.Error
+		}
+		reverted = res.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return false, false, err
+	}
+	return cancelled, reverted, nil
+}
+
+func NewEmailChangeRepository(db *gorm.DB) *DefaultEmailChangeRepository {
+	return &DefaultEmailChangeRepository{db: db}
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type InvitationRepository interface {
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) error
+	GetInvitationByHash(ctx context.Context, hash string) (*models.Invitation, error)
+	GetPendingInvitations(ctx context.Context, orgId string) ([]*models.Invitation, error)
+	DeletePendingInvitations(ctx context.Context, orgId string, email string) error
+	DeleteInvitation(ctx context.Context, orgId string, id string) (bool, error)
+	AcceptInvitation(ctx context.Context, invitation *models.Invitation, userId string) (bool, error)
+}
+
+type DefaultInvitationRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultInvitationRepository) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	return conn(ctx, r.db).Create(invitation).Error
+}
+
+func (r *DefaultInvitationRepository) GetInvitationByHash(ctx context.Context, hash string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := conn(ctx, r.db).Where("token_hash = ?", hash).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (r *DefaultInvitationRepository) GetPendingInvitations(ctx context.Context, orgId string) ([]*models.Invitation, error) {
+	var invitations []*models.Invitation
+	err := conn(ctx, r.db).Where("org_id = ? AND accepted_at IS NULL AND expires_at > ?", orgId, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// DeletePendingInvitations removes outstanding invitations for an address, so
+// inviting someone again replaces the earlier link.
+func (r *DefaultInvitationRepository) DeletePendingInvitations(ctx context.Context, orgId string, email string) error {
+	return conn(ctx, r.db).Where("org_id = ? AND lower(email) = lower(?) AND accepted_at IS NULL", orgId, email).
+		Delete(&models.Invitation{}).Error
+}
+
+func (r *DefaultInvitationRepository) DeleteInvitation(ctx context.Context, orgId string, id string) (bool, error) {
+	res := conn(ctx, r.db).Where("org_id = ? AND id = ? AND accepted_at IS NULL", orgId, id).Delete(&models.Invitation{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// AcceptInvitation marks the invitation accepted and adds the user to the
+// organization in one transaction. It reports false if the invitation was
+// already accepted, revoked or expired in the meantime.
+func (r *DefaultInvitationRepository) AcceptInvitation(ctx context.Context, invitation *models.Invitation, userId string) (bool, error) {
+	accepted := false
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Invitations to a deleted organization can no longer be accepted
+		var orgs int64
+		if err := tx.Model(&models.Organization{}).Where("org_id = ?", invitation.OrgId).Count(&orgs).Error; err != nil || orgs == 0 {
+			return err
+		}
+		res := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND expires_at > ?", invitation.Id, time.Now()).
+			Update("accepted_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		accepted = true
+		// A user who is already a member keeps their current role
+		var existing int64
+		err := tx.Model(&models.UserOrganization{}).
+			Where("org_id = ? AND user_id = ?", invitation.OrgId, userId).
+			Count(&existing).Error
+		if err != nil || existing > 0 {
+			return err
+		}
+		return tx.Create(&models.UserOrganization{
+			OrgId:  invitation.OrgId,
+			UserId: userId,
+			Role:   invitation.Role,
+		}).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return accepted, nil
+}
+
+func NewInvitationRepository(db *gorm.DB) *DefaultInvitationRepository {
+	return &DefaultInvitationRepository{db: db}
+}
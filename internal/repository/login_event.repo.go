@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+)
+
+type LoginEventRepository interface {
+	RecordLogin(ctx context.Context, event *models.LoginEvent) error
+	GetLoginEvents(ctx context.Context, userId string) ([]*models.LoginEvent, error)
+}
+
+type DefaultLoginEventRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultLoginEventRepository) RecordLogin(ctx context.Context, event *models.LoginEvent) error {
+	return conn(ctx, r.db).Create(event).Error
+}
+
+// GetLoginEvents returns the user's login history, most recent first.
+func (r *DefaultLoginEventRepository) GetLoginEvents(ctx context.Context, userId string) ([]*models.LoginEvent, error) {
+	var events []*models.LoginEvent
+	err := conn(ctx, r.db).Where("user_id = ?", userId).Order("created_at DESC").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func NewLoginEventRepository(db *gorm.DB) *DefaultLoginEventRepository {
+	return &DefaultLoginEventRepository{db: db}
+}
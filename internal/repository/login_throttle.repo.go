@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"sync"
+	"time"
+)
+
+// LoginThrottleRepository stores the login attempt counters and lockouts
+// used to slow down password guessing.
+type LoginThrottleRepository interface {
+	// Hit counts an attempt for key and returns the updated entry. A new
+	// window of the given length starts when the current one has ended.
+	Hit(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error)
+	// GetThrottle returns the entry for key, or nil when there is none.
+	GetThrottle(ctx context.Context, key string) (*models.LoginThrottle, error)
+	// Lock locks key out until the given time as its lockouts-th lockout and
+	// starts counting attempts afresh.
+	Lock(ctx context.Context, key string, until time.Time, lockouts int) error
+	// ResetThrottle forgets key, ending any lockout.
+	ResetThrottle(ctx context.Context, key string) error
+	// DeleteStaleThrottles deletes entries whose window and lockout ended and
+	// which were last touched before the given time.
+	DeleteStaleThrottles(ctx context.Context, before time.Time) (int64, error)
+}
+
+type DefaultLoginThrottleRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultLoginThrottleRepository) Hit(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error) {
+	// A single upsert, so concurrent attempts on other instances are all
+	// counted
+	now := time.Now()
+	var entry models.LoginThrottle
+	err := conn(ctx, r.db).Raw(`INSERT INTO login_throttles (key, attempts, window_ends, lockouts, updated_at)
+VALUES (?, 1, ?, 0, ?)
+ON CONFLICT (key) DO UPDATE SET
+    attempts = CASE WHEN login_throttles.window_ends <= EXCLUDED.updated_at THEN 1 ELSE login_throttles.attempts + 1 END,
+    window_ends = CASE WHEN login_throttles.window_ends <= EXCLUDED.updated_at THEN EXCLUDED.window_ends ELSE login_throttles.window_ends END,
+    updated_at = EXCLUDED.updated_at
+RETURNING *`, key, now.Add(window), now).Scan(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *DefaultLoginThrottleRepository) GetThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	var entry models.LoginThrottle
+	err := conn(ctx, r.db).Where("key = ?", key).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *DefaultLoginThrottleRepository) Lock(ctx context.Context, key string, until time.Time, lockouts int) error {
+	return conn(ctx, r.db).Model(&models.LoginThrottle{}).Where("key = ?", key).Updates(map[string]interface{}{
+		"attempts":     0,
+		"window_ends":  time.Now(),
+		"lockouts":     lockouts,
+		"locked_until": until,
+	}).Error
+}
+
+func (r *DefaultLoginThrottleRepository) ResetThrottle(ctx context.Context, key string) error {
+	return conn(ctx, r.db).Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+func (r *DefaultLoginThrottleRepository) DeleteStaleThrottles(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.db).
+		Where("updated_at < ? AND window_ends < ?", before, before).
+		Where("locked_until IS NULL OR locked_until < ?", before).
+		Delete(&models.LoginThrottle{})
+	return result.RowsAffected, result.Error
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) *DefaultLoginThrottleRepository {
+	return &DefaultLoginThrottleRepository{db: db}
+}
+
+// InMemoryLoginThrottleRepository keeps login throttles in process memory.
+// Like InMemoryTokenRevocationRepository it suits tests and single instance
+// setups; every instance counts attempts on its own.
+type InMemoryLoginThrottleRepository struct {
+	mu      sync.Mutex
+	entries map[string]models.LoginThrottle
+}
+
+func (r *InMemoryLoginThrottleRepository) Hit(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = models.LoginThrottle{Key: key}
+	}
+	if !entry.WindowEnds.After(now) {
+		entry.Attempts = 0
+		entry.WindowEnds = now.Add(window)
+	}
+	entry.Attempts++
+	entry.UpdatedAt = now
+	r.entries[key] = entry
+	return &entry, nil
+}
+
+func (r *InMemoryLoginThrottleRepository) GetThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (r *InMemoryLoginThrottleRepository) Lock(ctx context.Context, key string, until time.Time, lockouts int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[key]
+	if !ok {
+		return nil
+	}
+	entry.Attempts = 0
+	entry.WindowEnds = time.Now()
+	entry.Lockouts = lockouts
+	entry.LockedUntil = &until
+	r.entries[key] = entry
+	return nil
+}
+
+func (r *InMemoryLoginThrottleRepository) ResetThrottle(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+	return nil
+}
+
+func (r *InMemoryLoginThrottleRepository) DeleteStaleThrottles(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for key, entry := range r.entries {
+		if entry.UpdatedAt.Before(before) && entry.WindowEnds.Before(before) && (entry.LockedUntil == nil || entry.LockedUntil.Before(before)) {
+			delete(r.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func NewInMemoryLoginThrottleRepository() *InMemoryLoginThrottleRepository {
+	return &InMemoryLoginThrottleRepository{entries: make(map[string]models.LoginThrottle)}
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type MfaRepository interface {
+	SetTotpSecret(ctx context.Context, userId string, secret string) error
+	EnableTotp(ctx context.Context, userId string) error
+	DisableTotp(ctx context.Context, userId string) error
+	AdvanceTotpStep(ctx context.Context, userId string, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, userId string, hash string) (bool, error)
+}
+
+type DefaultMfaRepository struct {
+	db *gorm.DB
+}
+
+// SetTotpSecret stores a pending secret. MFA stays disabled until EnableTotp
+// is called after the user proves their app produces valid codes.
+func (r *DefaultMfaRepository) SetTotpSecret(ctx context.Context, userId string, secret string) error {
+	return conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+		"mfa_enabled_at": nil,
+	}).Error
+}
+
+func (r *DefaultMfaRepository) EnableTotp(ctx context.Context, userId string) error {
+	return conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", userId).Update("mfa_enabled_at", time.Now()).Error
+}
+
+func (r *DefaultMfaRepository) DisableTotp(ctx context.Context, userId string) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_last_step": 0,
+			"mfa_enabled_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&models.MfaRecoveryCode{}).Error
+	})
+}
+
+// AdvanceTotpStep records the time step of an accepted code. It reports false
+// if a code from the same or a later step was already used.
+func (r *DefaultMfaRepository) AdvanceTotpStep(ctx context.Context, userId string, step int64) (bool, error) {
+	res := conn(ctx, r.db).Model(&models.User{}).
+		Where("user_id = ? AND totp_last_step < ?", userId, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (r *DefaultMfaRepository) ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&models.MfaRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.MfaRecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = models.MfaRecoveryCode{UserId: userId, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *DefaultMfaRepository) ConsumeRecoveryCode(ctx context.Context, userId string, hash string) (bool, error) {
+	res := conn(ctx, r.db).Model(&models.MfaRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func NewMfaRepository(db *gorm.DB) *DefaultMfaRepository {
+	return &DefaultMfaRepository{db: db}
+}
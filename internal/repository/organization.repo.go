@@ -1,28 +1,97 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"gorm.io/gorm"
 	"h-two/internal/models"
+	"strings"
+	"time"
 )
 
+// Sort keys for listings
+const (
+	SortName   = "name"
+	SortEmail  = "email"
+	SortJoined = "joinedAt"
+)
+
+var memberSortColumns = map[string]string{
+	SortName:   "lower(users.first_name || ' ' || users.last_name)",
+	SortEmail:  "lower(users.email)",
+	SortJoined: "user_organizations.created_at",
+}
+
+var organizationSortColumns = map[string]string{
+	SortName:   "lower(organizations.name)",
+	SortJoined: "user_organizations.created_at",
+}
+
+// MemberQuery selects one page of an organization's members, optionally
+// filtered by role and by a name or email prefix.
+type MemberQuery struct {
+	Page
+	Role   string
+	Prefix string
+}
+
+// MemberCursor returns the position of member in the listing order.
+func MemberCursor(sort string, member *models.OrganizationMember) *Cursor {
+	cursor := &Cursor{Sort: sort, Id: member.UserId}
+	switch sort {
+	case SortEmail:
+		cursor.Value = strings.ToLower(member.Email)
+	case SortJoined:
+		cursor.Value = formatCursorTime(member.JoinedAt)
+	default:
+		cursor.Value = strings.ToLower(member.FirstName + " " + member.LastName)
+	}
+	return cursor
+}
+
+// OrganizationCursor returns the position of org in the listing order.
+func OrganizationCursor(sort string, org *models.Organization) *Cursor {
+	cursor := &Cursor{Sort: sort, Id: org.OrgId}
+	if sort == SortJoined && org.JoinedAt != nil {
+		cursor.Value = formatCursorTime(*org.JoinedAt)
+	} else {
+		cursor.Value = strings.ToLower(org.Name)
+	}
+	return cursor
+}
+
 type OrganizationRepository interface {
-	CreateOrganization(org *models.Organization) error
-	GetOrganizationsByUser(userId string) ([]*models.Organization, error)
-	GetOrganizationById(userId string, orgId string) (*models.Organization, error)
-	AddUserToOrganization(orgId string, userId string) error
-	IsUserInOrganization(userId string, orgId string) (bool, error)
-	AreUsersInSameOrganization(userId1 string, userId2 string) (bool, error)
-	Begin() *gorm.DB
+	CreateOrganization(ctx context.Context, org *models.Organization) error
+	GetOrganizationsByUser(ctx context.Context, userId string, page Page) ([]*models.Organization, error)
+	GetOrganizationById(ctx context.Context, userId string, orgId string) (*models.Organization, error)
+	GetMemberships(ctx context.Context, userId string) ([]*models.Organization, error)
+	GetOwnedOrganizations(ctx context.Context, userId string) ([]*models.Organization, error)
+	AddUserToOrganization(ctx context.Context, orgId string, userId string, role string) error
+	UpdateMemberRole(ctx context.Context, orgId string, userId string, role string) error
+	RemoveUserFromOrganization(ctx context.Context, orgId string, userId string) (bool, error)
+	TransferOwnership(ctx context.Context, orgId string, fromUserId string, toUserId string) (bool, error)
+	UpdateOrganization(ctx context.Context, orgId string, updates map[string]interface{}) error
+	SoftDeleteOrganization(ctx context.Context, orgId string) error
+	GetDeletedOrganization(ctx context.Context, userId string, orgId string) (*models.Organization, error)
+	RestoreOrganization(ctx context.Context, orgId string, deletedAfter time.Time) (bool, error)
+	PurgeDeletedOrganizations(ctx context.Context, deletedBefore time.Time) (int64, error)
+	ListOrganizationMembers(ctx context.Context, orgId string, query MemberQuery) ([]*models.OrganizationMember, error)
+	IsUserInOrganization(ctx context.Context, userId string, orgId string) (bool, error)
+	AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error)
+	IsMfaRequiredForUser(ctx context.Context, userId string) (bool, error)
+	SetRequireMfa(ctx context.Context, orgId string, requireMfa bool) error
 }
 
 type DefaultOrganizationRepository struct {
 	db *gorm.DB
 }
 
-func (r *DefaultOrganizationRepository) IsUserInOrganization(userId string, orgId string) (bool, error) {
+func (r *DefaultOrganizationRepository) IsUserInOrganization(ctx context.Context, userId string, orgId string) (bool, error) {
 	var userOrg models.UserOrganization
-	if err := r.db.Where("org_id = ? AND user_id = ?", orgId, userId).First(&userOrg).Error; err != nil {
+	err := conn(ctx, r.db).Joins("JOIN organizations ON organizations.org_id = user_organizations.org_id").
+		Where("user_organizations.org_id = ? AND user_organizations.user_id = ? AND organizations.deleted_at IS NULL", orgId, userId).
+		First(&userOrg).Error
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return false, nil
 		}
@@ -32,38 +101,53 @@ func (r *DefaultOrganizationRepository) IsUserInOrganization(userId string, orgI
 
 }
 
-func (r *DefaultOrganizationRepository) CreateOrganization(org *models.Organization) error {
+// CreateOrganization creates the organization and adds its owner as a
+// member in one transaction.
+func (r *DefaultOrganizationRepository) CreateOrganization(ctx context.Context, org *models.Organization) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		// Add the creator to the organization as its owner
+		return tx.Create(&models.UserOrganization{
+			OrgId:  org.OrgId,
+			UserId: org.Owner,
+			Role:   models.RoleOwner,
+		}).Error
+	})
+}
 
-	// Start a new transaction
-	tx := r.db.Begin()
-	// Check for errors starting the transaction
-	if tx.Error != nil {
-		return tx.Error
+// GetOrganizationsByUser returns one page of the organizations the user
+// belongs to, with their role and join date.
+func (r *DefaultOrganizationRepository) GetOrganizationsByUser(ctx context.Context, userId string, page Page) ([]*models.Organization, error) {
+	sortColumn, ok := organizationSortColumns[page.Sort]
+	if !ok {
+		sortColumn = organizationSortColumns[SortName]
 	}
-	// Create the organization
-	err := r.db.Create(&org).Error
+	tx := conn(ctx, r.db).Table("organizations").
+		Select("organizations.*, user_organizations.role, user_organizations.created_at AS joined_at").
+		Joins("JOIN user_organizations ON organizations.org_id = user_organizations.org_id").
+		Where("user_organizations.user_id = ?", userId)
+	tx, err := paginate(tx, page, sortColumn, "organizations.org_id", page.Sort == SortJoined)
 	if err != nil {
-		return err
-	}
-	// Add the user to the organization
-	userOrg := &models.UserOrganization{
-		OrgId:  org.OrgId,
-		UserId: org.Owner,
+		return nil, err
 	}
-	err = tx.Create(&userOrg).Error
-	if err != nil {
-		tx.Rollback()
-		return err
+	var orgs []*models.Organization
+	if err := tx.Find(&orgs).Error; err != nil {
+		return nil, err
 	}
-	// Commit the transaction
-	tx.Commit()
-	return nil
+	return orgs, nil
 }
-func (r *DefaultOrganizationRepository) GetOrganizationsByUser(userId string) ([]*models.Organization, error) {
+
+// GetMemberships returns every organization the user belongs to, with their
+// role and join date, oldest membership first.
+func (r *DefaultOrganizationRepository) GetMemberships(ctx context.Context, userId string) ([]*models.Organization, error) {
 	var orgs []*models.Organization
-	err := r.db.Table("organizations").
+	err := conn(ctx, r.db).Table("organizations").
+		Select("organizations.*, user_organizations.role, user_organizations.created_at AS joined_at").
 		Joins("JOIN user_organizations ON organizations.org_id = user_organizations.org_id").
 		Where("user_organizations.user_id = ?", userId).
+		Order("user_organizations.created_at").
 		Find(&orgs).Error
 	if err != nil {
 		return nil, err
@@ -71,10 +155,20 @@ func (r *DefaultOrganizationRepository) GetOrganizationsByUser(userId string) ([
 	return orgs, nil
 }
 
-func (r *DefaultOrganizationRepository) GetOrganizationById(userId string, orgId string) (*models.Organization, error) {
+func (r *DefaultOrganizationRepository) GetOwnedOrganizations(ctx context.Context, userId string) ([]*models.Organization, error) {
+	var orgs []*models.Organization
+	err := conn(ctx, r.db).Where("owner = ?", userId).Order("name").Find(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func (r *DefaultOrganizationRepository) GetOrganizationById(ctx context.Context, userId string, orgId string) (*models.Organization, error) {
 
 	var org models.Organization
-	err := r.db.Table("organizations").
+	err := conn(ctx, r.db).Table("organizations").
+		Select("organizations.*, user_organizations.role, user_organizations.created_at AS joined_at").
 		Joins("JOIN user_organizations ON organizations.org_id = user_organizations.org_id").
 		Where("user_organizations.user_id = ? AND organizations.org_id = ?", userId, orgId).
 		First(&org).Error
@@ -84,10 +178,10 @@ func (r *DefaultOrganizationRepository) GetOrganizationById(userId string, orgId
 	return &org, nil
 }
 
-func (r *DefaultOrganizationRepository) AddUserToOrganization(orgId string, userId string) error {
+func (r *DefaultOrganizationRepository) AddUserToOrganization(ctx context.Context, orgId string, userId string, role string) error {
 	// Check if the user already belongs to the organization
 	var userOrg models.UserOrganization
-	if err := r.db.Where("org_id = ? AND user_id = ?", orgId, userId).First(&userOrg).Error; err != nil {
+	if err := conn(ctx, r.db).Where("org_id = ? AND user_id = ?", orgId, userId).First(&userOrg).Error; err != nil {
 		if err != gorm.ErrRecordNotFound {
 			// An error occurred while trying to fetch the record
 			return err
@@ -101,27 +195,197 @@ func (r *DefaultOrganizationRepository) AddUserToOrganization(orgId string, user
 	userOrg = models.UserOrganization{
 		OrgId:  orgId,
 		UserId: userId,
+		Role:   role,
 	}
-	if err := r.db.Create(&userOrg).Error; err != nil {
+	if err := conn(ctx, r.db).Create(&userOrg).Error; err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r *DefaultOrganizationRepository) AreUsersInSameOrganization(userId1 string, userId2 string) (bool, error) {
+func (r *DefaultOrganizationRepository) UpdateMemberRole(ctx context.Context, orgId string, userId string, role string) error {
+	res := conn(ctx, r.db).Model(&models.UserOrganization{}).
+		Where("org_id = ? AND user_id = ?", orgId, userId).
+		Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RemoveUserFromOrganization deletes a membership. The owner's membership is
+// never removed here, so an organization cannot lose its owner.
+func (r *DefaultOrganizationRepository) RemoveUserFromOrganization(ctx context.Context, orgId string, userId string) (bool, error) {
+	res := conn(ctx, r.db).Where("org_id = ? AND user_id = ? AND role <> ?", orgId, userId, models.RoleOwner).
+		Delete(&models.UserOrganization{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// TransferOwnership makes toUserId the owner and demotes fromUserId to admin,
+// keeping organizations.owner in step. It reports false, changing nothing, if
+// fromUserId is not the owner or toUserId is not a member.
+func (r *DefaultOrganizationRepository) TransferOwnership(ctx context.Context, orgId string, fromUserId string, toUserId string) (bool, error) {
+	transferred := false
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Organization{}).
+			Where("org_id = ? AND owner = ?", orgId, fromUserId).
+			Update("owner", toUserId)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		res = tx.Model(&models.UserOrganization{}).
+			Where("org_id = ? AND user_id = ?", orgId, toUserId).
+			Update("role", models.RoleOwner)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		res = tx.Model(&models.UserOrganization{}).
+			Where("org_id = ? AND user_id = ?", orgId, fromUserId).
+			Update("role", models.RoleAdmin)
+		if res.Error != nil {
+			return res.Error
+		}
+		transferred = true
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return transferred, nil
+}
+
+func (r *DefaultOrganizationRepository) UpdateOrganization(ctx context.Context, orgId string, updates map[string]interface{}) error {
+	return conn(ctx, r.db).Model(&models.Organization{}).Where("org_id = ?", orgId).Updates(updates).Error
+}
+
+// SoftDeleteOrganization hides the organization from every query while
+// keeping its data, so the owner can still restore it.
+func (r *DefaultOrganizationRepository) SoftDeleteOrganization(ctx context.Context, orgId string) error {
+	return conn(ctx, r.db).Where("org_id = ?", orgId).Delete(&models.Organization{}).Error
+}
+
+// GetDeletedOrganization returns a soft-deleted organization as seen by one of
+// its members.
+func (r *DefaultOrganizationRepository) GetDeletedOrganization(ctx context.Context, userId string, orgId string) (*models.Organization, error) {
+	var org models.Organization
+	err := conn(ctx, r.db).Unscoped().Table("organizations").
+		Select("organizations.*, user_organizations.role").
+		Joins("JOIN user_organizations ON organizations.org_id = user_organizations.org_id").
+		Where("user_organizations.user_id = ? AND organizations.org_id = ? AND organizations.deleted_at IS NOT NULL", userId, orgId).
+		First(&org).Error
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// RestoreOrganization undeletes an organization deleted after the given time.
+// It reports false once the grace period is over.
+func (r *DefaultOrganizationRepository) RestoreOrganization(ctx context.Context, orgId string, deletedAfter time.Time) (bool, error) {
+	res := conn(ctx, r.db).Unscoped().Model(&models.Organization{}).
+		Where("org_id = ? AND deleted_at > ?", orgId, deletedAfter).
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// PurgeDeletedOrganizations hard-deletes organizations soft-deleted before
+// the given time, together with their memberships and invitations.
+func (r *DefaultOrganizationRepository) PurgeDeletedOrganizations(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var purged int64
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var orgIds []string
+		err := tx.Unscoped().Model(&models.Organization{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+			Pluck("org_id", &orgIds).Error
+		if err != nil || len(orgIds) == 0 {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIds).Delete(&models.UserOrganization{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIds).Delete(&models.Invitation{}).Error; err != nil {
+			return err
+		}
+		res := tx.Unscoped().Where("org_id IN ?", orgIds).Delete(&models.Organization{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
+}
+
+// ListOrganizationMembers returns one page of members joined with their
+// profiles in a single query.
+func (r *DefaultOrganizationRepository) ListOrganizationMembers(ctx context.Context, orgId string, query MemberQuery) ([]*models.OrganizationMember, error) {
+	sortColumn, ok := memberSortColumns[query.Sort]
+	if !ok {
+		sortColumn = memberSortColumns[SortName]
+	}
+	tx := conn(ctx, r.db).Table("user_organizations").
+		Select("users.user_id, users.first_name, users.last_name, users.email, user_organizations.role, user_organizations.created_at AS joined_at").
+		Joins("JOIN users ON users.user_id = user_organizations.user_id AND users.deleted_at IS NULL").
+		Where("user_organizations.org_id = ?", orgId)
+	if query.Role != "" {
+		tx = tx.Where("user_organizations.role = ?", query.Role)
+	}
+	if query.Prefix != "" {
+		prefix := strings.ToLower(escapeLike(query.Prefix)) + "%"
+		tx = tx.Where("lower(users.first_name) LIKE ? OR lower(users.last_name) LIKE ? OR lower(users.first_name || ' ' || users.last_name) LIKE ? OR lower(users.email) LIKE ?",
+			prefix, prefix, prefix, prefix)
+	}
+	tx, err := paginate(tx, query.Page, sortColumn, "users.user_id", query.Sort == SortJoined)
+	if err != nil {
+		return nil, err
+	}
+	var members []*models.OrganizationMember
+	if err := tx.Scan(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *DefaultOrganizationRepository) AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error) {
 	var userOrg1, userOrg2 models.UserOrganization
-	if err := r.db.Where("user_id = ?", userId1).First(&userOrg1).Error; err != nil {
+	if err := conn(ctx, r.db).Where("user_id = ?", userId1).First(&userOrg1).Error; err != nil {
 		return false, err
 	}
-	if err := r.db.Where("user_id = ?", userId2).First(&userOrg2).Error; err != nil {
+	if err := conn(ctx, r.db).Where("user_id = ?", userId2).First(&userOrg2).Error; err != nil {
 		return false, err
 	}
 	return userOrg1.OrgId == userOrg2.OrgId, nil
 }
 
-func (r *DefaultOrganizationRepository) Begin() *gorm.DB {
-	return r.db.Begin()
+// IsMfaRequiredForUser reports whether any organization the user belongs to
+// requires its members to use MFA.
+func (r *DefaultOrganizationRepository) IsMfaRequiredForUser(ctx context.Context, userId string) (bool, error) {
+	var count int64
+	err := conn(ctx, r.db).Table("organizations").
+		Joins("JOIN user_organizations ON organizations.org_id = user_organizations.org_id").
+		Where("user_organizations.user_id = ? AND organizations.require_mfa AND organizations.deleted_at IS NULL", userId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *DefaultOrganizationRepository) SetRequireMfa(ctx context.Context, orgId string, requireMfa bool) error {
+	return conn(ctx, r.db).Model(&models.Organization{}).Where("org_id = ?", orgId).Update("require_mfa", requireMfa).Error
 }
 
 func NewOrganizationRepository(db *gorm.DB) *DefaultOrganizationRepository {
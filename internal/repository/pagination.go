@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Cursor is the position of the last row of a page in a keyset-paginated
+// listing: the value of the sort column plus the row id that breaks ties.
+// Sort and Desc record the ordering it was issued for.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	Id    string `json:"id"`
+}
+
+// Page selects one page of a listing ordered by Sort.
+type Page struct {
+	Sort  string
+	Desc  bool
+	After *Cursor
+	Limit int
+}
+
+// formatCursorTime stores a timestamp in a cursor without losing precision.
+func formatCursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// paginate orders tx by sortColumn and idColumn and, when the page has a
+// cursor, continues strictly after it. timeSorted says the sort column holds
+// timestamps, so the cursor value is compared as one.
+func paginate(tx *gorm.DB, page Page, sortColumn string, idColumn string, timeSorted bool) (*gorm.DB, error) {
+	direction, comparison := "ASC", ">"
+	if page.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if page.After != nil {
+		var value interface{} = page.After.Value
+		if timeSorted {
+			t, err := time.Parse(time.RFC3339Nano, page.After.Value)
+			if err != nil {
+				return nil, err
+			}
+			value = t
+		}
+		tx = tx.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", sortColumn, idColumn, comparison), value, page.After.Id)
+	}
+	return tx.Order(fmt.Sprintf("%s %s, %s %s", sortColumn, direction, idColumn, direction)).Limit(page.Limit), nil
+}
+
+// escapeLike makes user input match literally inside a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type PersonalAccessTokenRepository interface {
+	CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error
+	GetPersonalAccessTokens(ctx context.Context, userId string) ([]*models.PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error)
+	// DeletePersonalAccessToken reports false when the user has no token with
+	// that id.
+	DeletePersonalAccessToken(ctx context.Context, userId string, id string) (bool, error)
+	// DeleteUserPersonalAccessTokens revokes every token of the user.
+	DeleteUserPersonalAccessTokens(ctx context.Context, userId string) error
+	// TouchPersonalAccessToken records when and from where the token was
+	// last used.
+	TouchPersonalAccessToken(ctx context.Context, id string, at time.Time, ip string) error
+}
+
+type DefaultPersonalAccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultPersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error {
+	return conn(ctx, r.db).Create(token).Error
+}
+
+func (r *DefaultPersonalAccessTokenRepository) GetPersonalAccessTokens(ctx context.Context, userId string) ([]*models.PersonalAccessToken, error) {
+	var tokens []*models.PersonalAccessToken
+	err := conn(ctx, r.db).Where("user_id = ?", userId).Order("created_at DESC").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *DefaultPersonalAccessTokenRepository) GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	if err := conn(ctx, r.db).Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *DefaultPersonalAccessTokenRepository) DeletePersonalAccessToken(ctx context.Context, userId string, id string) (bool, error) {
+	res := conn(ctx, r.db).Where("user_id = ? AND id = ?", userId, id).Delete(&models.PersonalAccessToken{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (r *DefaultPersonalAccessTokenRepository) DeleteUserPersonalAccessTokens(ctx context.Context, userId string) error {
+	return conn(ctx, r.db).Where("user_id = ?", userId).Delete(&models.PersonalAccessToken{}).Error
+}
+
+func (r *DefaultPersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id string, at time.Time, ip string) error {
+	return conn(ctx, r.db).Model(&models.PersonalAccessToken{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_used_at": at,
+		"last_used_ip": ip,
+	}).Error
+}
+
+func NewPersonalAccessTokenRepository(db *gorm.DB) *DefaultPersonalAccessTokenRepository {
+	return &DefaultPersonalAccessTokenRepository{db: db}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyId string) error
+	// RevokeUserRefreshTokens revokes every refresh token of the user except
+	// those in exceptFamilyId, if set.
+	RevokeUserRefreshTokens(ctx context.Context, userId string, exceptFamilyId string) error
+}
+
+type DefaultRefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return conn(ctx, r.db).Create(token).Error
+}
+
+func (r *DefaultRefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := conn(ctx, r.db).Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken revokes the token identified by oldId and stores next in
+// its place. It reports false without storing anything if oldId had already
+// been revoked, which happens when two requests race with the same token.
+func (r *DefaultRefreshTokenRepository) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) (bool, error) {
+	rotated := false
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", oldId).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.Id})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return rotated, nil
+}
+
+func (r *DefaultRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	return conn(ctx, r.db).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *DefaultRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userId string, exceptFamilyId string) error {
+	tx := conn(ctx, r.db).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId)
+	if exceptFamilyId != "" {
+		tx = tx.Where("family_id <> ?", exceptFamilyId)
+	}
+	return tx.Update("revoked_at", time.Now()).Error
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) *DefaultRefreshTokenRepository {
+	return &DefaultRefreshTokenRepository{db: db}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"h-two/internal/models"
+	"sync"
+	"time"
+)
+
+// TokenRevocationRepository records access tokens that must be rejected
+// before their natural expiry.
+type TokenRevocationRepository interface {
+	RevokeToken(ctx context.Context, jti string, userId string, expiresAt time.Time) error
+	// RevokeUserTokens revokes every token of the user issued up to
+	// issuedBefore. Tokens of exceptSessionId stay valid unless it is empty.
+	RevokeUserTokens(ctx context.Context, userId string, issuedBefore time.Time, exceptSessionId string) error
+	IsTokenRevoked(ctx context.Context, jti string, userId string, sessionId string, issuedAt time.Time) (bool, error)
+}
+
+type DefaultTokenRevocationRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultTokenRevocationRepository) RevokeToken(ctx context.Context, jti string, userId string, expiresAt time.Time) error {
+	// Opportunistically drop entries that can no longer match a valid token
+	if err := conn(ctx, r.db).Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return conn(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		Jti:       jti,
+		UserId:    userId,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+func (r *DefaultTokenRevocationRepository) RevokeUserTokens(ctx context.Context, userId string, issuedBefore time.Time, exceptSessionId string) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "except_session_id"}),
+	}).Create(&models.UserTokenRevocation{
+		UserId:          userId,
+		RevokedBefore:   issuedBefore,
+		ExceptSessionId: exceptSessionId,
+	}).Error
+}
+
+func (r *DefaultTokenRevocationRepository) IsTokenRevoked(ctx context.Context, jti string, userId string, sessionId string, issuedAt time.Time) (bool, error) {
+	var count int64
+	err := conn(ctx, r.db).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	err = conn(ctx, r.db).Model(&models.UserTokenRevocation{}).
+		Where("user_id = ? AND revoked_before >= ?", userId, issuedAt).
+		Where("except_session_id = '' OR except_session_id <> ?", sessionId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func NewTokenRevocationRepository(db *gorm.DB) *DefaultTokenRevocationRepository {
+	return &DefaultTokenRevocationRepository{db: db}
+}
+
+// InMemoryTokenRevocationRepository keeps revocations in process memory. It
+// is meant for tests and single instance development setups; revocations
+// are lost on restart and are not shared between instances.
+type InMemoryTokenRevocationRepository struct {
+	mu          sync.RWMutex
+	tokens      map[string]time.Time
+	revokedUpTo map[string]models.UserTokenRevocation
+}
+
+func (r *InMemoryTokenRevocationRepository) RevokeToken(ctx context.Context, jti string, userId string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, exp := range r.tokens {
+		if exp.Before(now) {
+			delete(r.tokens, id)
+		}
+	}
+	r.tokens[jti] = expiresAt
+	return nil
+}
+
+func (r *InMemoryTokenRevocationRepository) RevokeUserTokens(ctx context.Context, userId string, issuedBefore time.Time, exceptSessionId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revokedUpTo[userId] = models.UserTokenRevocation{
+		UserId:          userId,
+		RevokedBefore:   issuedBefore,
+		ExceptSessionId: exceptSessionId,
+	}
+	return nil
+}
+
+func (r *InMemoryTokenRevocationRepository) IsTokenRevoked(ctx context.Context, jti string, userId string, sessionId string, issuedAt time.Time) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.tokens[jti]; ok {
+		return true, nil
+	}
+	revocation, ok := r.revokedUpTo[userId]
+	if !ok || issuedAt.After(revocation.RevokedBefore) {
+		return false, nil
+	}
+	return revocation.ExceptSessionId == "" || revocation.ExceptSessionId != sessionId, nil
+}
+
+func NewInMemoryTokenRevocationRepository() *InMemoryTokenRevocationRepository {
+	return &InMemoryTokenRevocationRepository{
+		tokens:      make(map[string]time.Time),
+		revokedUpTo: make(map[string]models.UserTokenRevocation),
+	}
+}
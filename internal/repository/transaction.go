@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// TxManager runs a unit of work in a single database transaction. The
+// context passed to fn carries the transaction, and every repository called
+// with that context reads and writes through it.
+type TxManager interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type DefaultTxManager struct {
+	db *gorm.DB
+}
+
+type txKey struct{}
+
+// WithinTransaction commits when fn returns nil and rolls back otherwise.
+// Called inside another unit of work, fn joins the outer transaction.
+func (m *DefaultTxManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+func NewTxManager(db *gorm.DB) *DefaultTxManager {
+	return &DefaultTxManager{db: db}
+}
+
+// conn returns the handle a repository should use for ctx: the transaction
+// of the enclosing unit of work if there is one, db otherwise. Transactions
+// a repository opens on it become savepoints of the outer transaction.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
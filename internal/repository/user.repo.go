@@ -1,29 +1,35 @@
 package repository
 
 import (
+	"context"
 	"gorm.io/gorm"
 	"h-two/internal/dto"
 	"h-two/internal/models"
+	"time"
 )
 
 type UserRepository interface {
-	CreateUser(user *models.User) (*dto.UserResponse, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserById(userId string) (*models.User, error)
-	Begin() *gorm.DB
-	GetUserOrganization(id string) (*models.User, error)
-	AreUsersInSameOrganization(userId1 string, userId2 string) (bool, error)
+	CreateUser(ctx context.Context, user *models.User) (*dto.UserResponse, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserById(ctx context.Context, userId string) (*models.User, error)
+	GetUserOrganization(ctx context.Context, id string) (*models.User, error)
+	AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error)
+	UpdatePassword(ctx context.Context, userId string, hash string) error
+	MarkEmailVerified(ctx context.Context, userId string) error
+	UpdateProfile(ctx context.Context, userId string, updates map[string]interface{}) error
+	ScheduleDeletion(ctx context.Context, userId string, at *time.Time) error
+	PurgeScheduledDeletions(ctx context.Context, before time.Time) (int64, error)
 }
 
 type DefaultUserRepository struct {
 	db *gorm.DB
 }
 
-func (r *DefaultUserRepository) CreateUser(user *models.User) (*dto.UserResponse, error) {
-	if u := r.db.Where("email = ?", user.Email).First(&models.User{}); u.RowsAffected > 0 {
+func (r *DefaultUserRepository) CreateUser(ctx context.Context, user *models.User) (*dto.UserResponse, error) {
+	if u := conn(ctx, r.db).Where("lower(email) = lower(?)", user.Email).First(&models.User{}); u.RowsAffected > 0 {
 		return &dto.UserResponse{}, gorm.ErrRecordNotFound
 	}
-	err := r.db.Create(&user).Error
+	err := conn(ctx, r.db).Create(&user).Error
 	if err != nil {
 		return &dto.UserResponse{}, err
 	}
@@ -36,9 +42,11 @@ func (r *DefaultUserRepository) CreateUser(user *models.User) (*dto.UserResponse
 	}, nil
 }
 
-func (r *DefaultUserRepository) GetUserByEmail(email string) (*models.User, error) {
+// GetUserByEmail matches the address regardless of case, so accounts stored
+// before addresses were lower-cased are still found.
+func (r *DefaultUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := conn(ctx, r.db).Where("lower(email) = lower(?)", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -46,9 +54,9 @@ func (r *DefaultUserRepository) GetUserByEmail(email string) (*models.User, erro
 
 }
 
-func (r *DefaultUserRepository) GetUserById(userId string) (*models.User, error) {
+func (r *DefaultUserRepository) GetUserById(ctx context.Context, userId string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("user_id = ?", userId).First(&user).Error
+	err := conn(ctx, r.db).Where("user_id = ?", userId).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -56,23 +64,23 @@ func (r *DefaultUserRepository) GetUserById(userId string) (*models.User, error)
 
 }
 
-func (r *DefaultUserRepository) GetUserOrganization(id string) (*models.User, error) {
+func (r *DefaultUserRepository) GetUserOrganization(ctx context.Context, id string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("user_id = ?", id).First(&user).Error
+	err := conn(ctx, r.db).Where("user_id = ?", id).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *DefaultUserRepository) AreUsersInSameOrganization(userId1 string, userId2 string) (bool, error) {
+func (r *DefaultUserRepository) AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error) {
 	var userOrgs1 []models.UserOrganization
 	var userOrgs2 []models.UserOrganization
-	err := r.db.Where("user_id = ?", userId1).Find(&userOrgs1).Error
+	err := conn(ctx, r.db).Where("user_id = ?", userId1).Find(&userOrgs1).Error
 	if err != nil {
 		return false, err
 	}
-	err = r.db.Where("user_id = ?", userId2).Find(&userOrgs2).Error
+	err = conn(ctx, r.db).Where("user_id = ?", userId2).Find(&userOrgs2).Error
 	if err != nil {
 		return false, err
 	}
@@ -92,8 +100,77 @@ func (r *DefaultUserRepository) AreUsersInSameOrganization(userId1 string, userI
 
 	return false, nil
 }
-func (r *DefaultUserRepository) Begin() *gorm.DB {
-	return r.db.Begin()
+func (r *DefaultUserRepository) UpdatePassword(ctx context.Context, userId string, hash string) error {
+	return conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", userId).Update("password", hash).Error
+}
+
+func (r *DefaultUserRepository) MarkEmailVerified(ctx context.Context, userId string) error {
+	return conn(ctx, r.db).Model(&models.User{}).
+		Where("user_id = ? AND email_verified_at IS NULL", userId).
+		Update("email_verified_at", time.Now()).Error
+}
+
+func (r *DefaultUserRepository) UpdateProfile(ctx context.Context, userId string, updates map[string]interface{}) error {
+	return conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", userId).Updates(updates).Error
+}
+
+// ScheduleDeletion sets when the account will be purged; nil cancels it.
+func (r *DefaultUserRepository) ScheduleDeletion(ctx context.Context, userId string, at *time.Time) error {
+	return conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", userId).Update("deletion_scheduled_at", at).Error
+}
+
+// PurgeScheduledDeletions permanently deletes accounts whose deletion was
+// scheduled before the given time, with everything that belongs to them.
+// Organizations they deleted but that are still restorable go with them.
+// Accounts that own an organization again are skipped.
+func (r *DefaultUserRepository) PurgeScheduledDeletions(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var userIds []string
+		err := tx.Model(&models.User{}).
+			Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at < ?", before).
+			Where("NOT EXISTS (SELECT 1 FROM organizations WHERE organizations.owner = users.user_id AND organizations.deleted_at IS NULL)").
+			Pluck("user_id", &userIds).Error
+		if err != nil || len(userIds) == 0 {
+			return err
+		}
+		var orgIds []string
+		err = tx.Unscoped().Model(&models.Organization{}).Where("owner IN ?", userIds).Pluck("org_id", &orgIds).Error
+		if err != nil {
+			return err
+		}
+		if len(orgIds) > 0 {
+			if err := tx.Where("org_id IN ?", orgIds).Delete(&models.UserOrganization{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("org_id IN ?", orgIds).Delete(&models.Invitation{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("org_id IN ?", orgIds).Delete(&models.Organization{}).Error; err != nil {
+				return err
+			}
+		}
+		owned := []interface{}{
+			&models.UserOrganization{},
+			&models.RefreshToken{},
+			&models.RevokedToken{},
+			&models.UserTokenRevocation{},
+			&models.UserToken{},
+			&models.MfaRecoveryCode{},
+			&models.EmailChange{},
+			&models.LoginEvent{},
+			&models.PersonalAccessToken{},
+		}
+		for _, model := range owned {
+			if err := tx.Where("user_id IN ?", userIds).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		res := tx.Unscoped().Where("user_id IN ?", userIds).Delete(&models.User{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
 }
 
 func NewUserRepository(db *gorm.DB) *DefaultUserRepository {
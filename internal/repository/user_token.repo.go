@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"time"
+)
+
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, token *models.UserToken) error
+	GetUserTokenByHash(ctx context.Context, hash string, purpose string) (*models.UserToken, error)
+	ConsumeUserToken(ctx context.Context, id string) (bool, error)
+	InvalidateUserTokens(ctx context.Context, userId string, purpose string) error
+}
+
+type DefaultUserTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *DefaultUserTokenRepository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	return conn(ctx, r.db).Create(token).Error
+}
+
+func (r *DefaultUserTokenRepository) GetUserTokenByHash(ctx context.Context, hash string, purpose string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := conn(ctx, r.db).Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeUserToken marks the token as used. It reports false if the token had
+// already been used, so only one of several concurrent requests wins.
+func (r *DefaultUserTokenRepository) ConsumeUserToken(ctx context.Context, id string) (bool, error) {
+	res := conn(ctx, r.db).Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// InvalidateUserTokens burns every outstanding token of the given purpose so
+// that only the most recently issued one can be used.
+func (r *DefaultUserTokenRepository) InvalidateUserTokens(ctx context.Context, userId string, purpose string) error {
+	return conn(ctx, r.db).Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userId, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+func NewUserTokenRepository(db *gorm.DB) *DefaultUserTokenRepository {
+	return &DefaultUserTokenRepository{db: db}
+}
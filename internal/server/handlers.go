@@ -4,7 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"h-two/internal/dto"
 	"h-two/internal/helpers"
-	"log"
+	"h-two/internal/logging"
 	"net/http"
 )
 
@@ -16,9 +16,9 @@ func (s *Server) RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	resp, err := s.AuthService.CreateUserAndOrganization(c, req)
+	resp, err := s.AuthService.CreateUserAndOrganization(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 
 	}
@@ -37,9 +37,17 @@ func (s *Server) LoginHandler(c *gin.Context) {
 	if perr != nil {
 		return
 	}
-	resp, err := s.AuthService.Login(c, req)
+	resp, err := s.AuthService.Login(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
+		return
+	}
+	if resp.MfaRequired {
+		c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+			Status:  "success",
+			Message: "Two-factor authentication required",
+			Data:    resp,
+		})
 		return
 	}
 
@@ -50,13 +58,143 @@ func (s *Server) LoginHandler(c *gin.Context) {
 	})
 }
 
+func (s *Server) LoginMfaHandler(c *gin.Context) {
+	var req *dto.LoginMfaRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.AuthService.LoginMfa(c.Request.Context(), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Login successful",
+		Data:    resp,
+	})
+}
+
+func (s *Server) RefreshTokenHandler(c *gin.Context) {
+	var req *dto.RefreshTokenRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.AuthService.Refresh(c.Request.Context(), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Token refreshed successfully",
+		Data:    resp,
+	})
+}
+
+func (s *Server) LogoutHandler(c *gin.Context) {
+	if err := s.AuthService.Logout(c.Request.Context(), c.GetString("userId"), c.GetString("sessionId"), c.GetString("jti"), c.GetTime("tokenExpiresAt")); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Logout successful",
+	})
+}
+
+func (s *Server) LogoutAllHandler(c *gin.Context) {
+	if err := s.AuthService.LogoutAll(c.Request.Context(), c.GetString("userId")); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Logged out of all sessions; personal access tokens stay valid until revoked",
+	})
+}
+
+func (s *Server) ForgotPasswordHandler(c *gin.Context) {
+	var req *dto.ForgotPasswordRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.AuthService.ForgotPassword(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "If the email is registered, a password reset link has been sent",
+	})
+}
+
+func (s *Server) ResetPasswordHandler(c *gin.Context) {
+	var req *dto.ResetPasswordRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.AuthService.ResetPassword(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Password reset successful",
+	})
+}
+
+func (s *Server) VerifyEmailHandler(c *gin.Context) {
+	var req *dto.VerifyEmailRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.AuthService.VerifyEmail(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Email verified successfully",
+	})
+}
+
+func (s *Server) ResendVerificationHandler(c *gin.Context) {
+	var req *dto.ResendVerificationRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.AuthService.ResendVerification(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "If the email needs verification, a new link has been sent",
+	})
+}
+
 func (s *Server) GetUserDetailsHandler(c *gin.Context) {
 
 	// Get the user ID from the context
 	userID := c.Params.ByName("id")
-	user, err := s.UserService.GetUserDetails(c, userID)
+	user, err := s.UserService.GetUserDetails(c.Request.Context(), c.GetString("userId"), userID)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
@@ -65,12 +203,258 @@ func (s *Server) GetUserDetailsHandler(c *gin.Context) {
 		Data:    user,
 	})
 }
+
+func (s *Server) UpdateProfileHandler(c *gin.Context) {
+	var req *dto.UpdateProfileRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	user, err := s.UserService.UpdateProfile(c.Request.Context(), c.GetString("userId"), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Profile updated",
+		Data:    user,
+	})
+}
+
+func (s *Server) ChangePasswordHandler(c *gin.Context) {
+	var req *dto.ChangePasswordRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.AuthService.ChangePassword(c.Request.Context(), c.GetString("userId"), c.GetString("sessionId"), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Password changed; other sessions have been signed out",
+	})
+}
+
+func (s *Server) ChangeEmailHandler(c *gin.Context) {
+	var req *dto.ChangeEmailRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.EmailChangeService.RequestEmailChange(c.Request.Context(), c.GetString("userId"), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "A confirmation link has been sent to the new address",
+		Data:    resp,
+	})
+}
+
+func (s *Server) ConfirmEmailChangeHandler(c *gin.Context) {
+	var req *dto.EmailChangeTokenRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.EmailChangeService.ConfirmEmailChange(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Email address changed",
+	})
+}
+
+func (s *Server) CancelEmailChangeHandler(c *gin.Context) {
+	var req *dto.EmailChangeTokenRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.EmailChangeService.CancelEmailChange(c.Request.Context(), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Email change cancelled",
+	})
+}
+
+// ExportAccountHandler returns the account export as a downloadable JSON
+// file rather than the usual response envelope.
+func (s *Server) ExportAccountHandler(c *gin.Context) {
+	export, err := s.AccountService.ExportAccount(c.Request.Context(), c.GetString("userId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="account-export.json"`)
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+func (s *Server) DeleteAccountHandler(c *gin.Context) {
+	var req *dto.DeleteAccountRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.AccountService.ScheduleDeletion(c.Request.Context(), c.GetString("userId"), c.GetString("sessionId"), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Account scheduled for deletion",
+		Data:    resp,
+	})
+}
+
+func (s *Server) CancelAccountDeletionHandler(c *gin.Context) {
+	if err := s.AccountService.CancelDeletion(c.Request.Context(), c.GetString("userId")); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Account deletion cancelled",
+	})
+}
+
+func (s *Server) CreatePersonalAccessTokenHandler(c *gin.Context) {
+	var req dto.CreatePersonalAccessTokenRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	token, err := s.PersonalAccessTokenService.CreateToken(c.Request.Context(), c.GetString("userId"), c.GetBool("mfa"), &req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Personal access token created, copy it now as it will not be shown again",
+		Data:    token,
+	})
+}
+
+func (s *Server) GetPersonalAccessTokensHandler(c *gin.Context) {
+	tokens, err := s.PersonalAccessTokenService.ListTokens(c.Request.Context(), c.GetString("userId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Personal access tokens retrieved successfully",
+		Data:    gin.H{"tokens": tokens},
+	})
+}
+
+func (s *Server) RevokePersonalAccessTokenHandler(c *gin.Context) {
+	err := s.PersonalAccessTokenService.RevokeToken(c.Request.Context(), c.GetString("userId"), c.Param("tokenId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Personal access token revoked successfully",
+	})
+}
+
+func (s *Server) EnrollTotpHandler(c *gin.Context) {
+	resp, err := s.MfaService.EnrollTotp(c.Request.Context(), c.GetString("userId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Scan the provisioning URI with your authenticator app and confirm a code",
+		Data:    resp,
+	})
+}
+
+func (s *Server) ConfirmTotpHandler(c *gin.Context) {
+	var req *dto.ConfirmTotpRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.MfaService.ConfirmTotp(c.Request.Context(), c.GetString("userId"), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Two-factor authentication enabled",
+		Data:    resp,
+	})
+}
+
+func (s *Server) DisableTotpHandler(c *gin.Context) {
+	var req *dto.MfaCodeRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	if err := s.MfaService.DisableTotp(c.Request.Context(), c.GetString("userId"), req); err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+func (s *Server) RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var req *dto.MfaCodeRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	resp, err := s.MfaService.RegenerateRecoveryCodes(c.Request.Context(), c.GetString("userId"), req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Recovery codes regenerated",
+		Data:    resp,
+	})
+}
+
 func (s *Server) GetOrganizationsHandler(c *gin.Context) {
 	// Get the user ID from the context
 	userID := c.GetString("userId")
-	orgs, err := s.OrganizationService.GetUserOrganizations(userID)
+	var query dto.ListOrganizationsQuery
+	perr := helpers.ParseQuery(c, &query)
+	if perr != nil {
+		return
+	}
+	orgs, meta, err := s.OrganizationService.GetUserOrganizations(c.Request.Context(), userID, &query)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 	if orgs == nil {
@@ -82,6 +466,7 @@ func (s *Server) GetOrganizationsHandler(c *gin.Context) {
 		Data: gin.H{
 			"organisations": orgs,
 		},
+		Meta: meta,
 	})
 }
 
@@ -92,19 +477,19 @@ func (s *Server) GetOrganizationHandler(c *gin.Context) {
 	orgId := c.Param("orgId")
 
 	// Check if the user is a member of the organization
-	isMember, err := s.OrganizationService.IsUserInOrganization(userID, orgId)
+	isMember, err := s.OrganizationService.IsUserInOrganization(c.Request.Context(), userID, orgId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 	if !isMember {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this organization"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this organization", "requestId": logging.RequestID(c.Request.Context())})
 		return
 	}
 
-	org, err := s.OrganizationService.GetOrganizationById(userID, orgId)
+	org, err := s.OrganizationService.GetOrganizationById(c.Request.Context(), userID, orgId)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
@@ -119,13 +504,13 @@ func (s *Server) CreateOrganizationHandler(c *gin.Context) {
 	var req dto.CreateOrganizationRequest
 	perr := helpers.ParseRequestBody(c, &req)
 	if perr != nil {
-		log.Println(perr)
+		s.logger().DebugContext(c.Request.Context(), "Invalid request body", "error", perr)
 		return
 	}
 
-	org, err := s.OrganizationService.CreateOrganization(userID, &req)
+	org, err := s.OrganizationService.CreateOrganization(c.Request.Context(), userID, &req)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 
@@ -138,21 +523,243 @@ func (s *Server) CreateOrganizationHandler(c *gin.Context) {
 }
 
 func (s *Server) AddUserToOrganizationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
 	orgID := c.Param("orgId")
 	var req dto.AddUserToOrganizationRequest
 	perr := helpers.ParseRequestBody(c, &req)
 	if perr != nil {
-		log.Println(perr)
+		s.logger().DebugContext(c.Request.Context(), "Invalid request body", "error", perr)
 		return
 	}
-	err := s.OrganizationService.AddUserToOrganization(req.UserId, orgID)
+	member, err := s.OrganizationService.AddUserToOrganization(c.Request.Context(), userID, orgID, req.UserId, req.Role)
 	if err != nil {
-		c.JSON(err.StatusCode, err)
+		helpers.AbortWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
 		Status:  "success",
 		Message: "User added to organization successfully",
+		Data:    member,
+	})
+}
+
+func (s *Server) UpdateMemberRoleHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var req dto.UpdateMemberRoleRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	member, err := s.OrganizationService.UpdateMemberRole(c.Request.Context(), userID, orgID, c.Param("userId"), req.Role)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Member role updated successfully",
+		Data:    member,
+	})
+}
+
+func (s *Server) UpdateMfaPolicyHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var req dto.UpdateMfaPolicyRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	org, err := s.OrganizationService.SetMfaPolicy(c.Request.Context(), userID, orgID, *req.RequireMfa)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "MFA policy updated successfully",
+		Data:    org,
+	})
+}
+
+func (s *Server) CreateInvitationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var req dto.CreateInvitationRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	invitation, err := s.InvitationService.CreateInvitation(c.Request.Context(), userID, orgID, &req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Invitation sent successfully",
+		Data:    invitation,
+	})
+}
+
+func (s *Server) GetInvitationsHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	invitations, err := s.InvitationService.GetPendingInvitations(c.Request.Context(), userID, orgID)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Invitations retrieved successfully",
+		Data:    gin.H{"invitations": invitations},
+	})
+}
+
+func (s *Server) RevokeInvitationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	err := s.InvitationService.RevokeInvitation(c.Request.Context(), userID, orgID, c.Param("invitationId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Invitation revoked successfully",
+	})
+}
+
+func (s *Server) RemoveMemberHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	err := s.OrganizationService.RemoveMember(c.Request.Context(), userID, orgID, c.Param("userId"))
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Member removed successfully",
+	})
+}
+
+func (s *Server) LeaveOrganizationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	err := s.OrganizationService.LeaveOrganization(c.Request.Context(), userID, orgID)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "You have left the organization",
+	})
+}
+
+func (s *Server) TransferOwnershipHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var req dto.TransferOwnershipRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	org, err := s.OrganizationService.TransferOwnership(c.Request.Context(), userID, orgID, req.UserId)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Ownership transferred successfully",
+		Data:    org,
+	})
+}
+
+func (s *Server) UpdateOrganizationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var req dto.UpdateOrganizationRequest
+	perr := helpers.ParseRequestBody(c, &req)
+	if perr != nil {
+		return
+	}
+	org, err := s.OrganizationService.UpdateOrganization(c.Request.Context(), userID, orgID, &req)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Organization updated successfully",
+		Data:    org,
+	})
+}
+
+func (s *Server) DeleteOrganizationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	deletion, err := s.OrganizationService.DeleteOrganization(c.Request.Context(), userID, orgID)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Organization deleted successfully",
+		Data:    deletion,
+	})
+}
+
+func (s *Server) RestoreOrganizationHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	org, err := s.OrganizationService.RestoreOrganization(c.Request.Context(), userID, orgID)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Organization restored successfully",
+		Data:    org,
+	})
+}
+
+func (s *Server) GetOrganizationMembersHandler(c *gin.Context) {
+	userID := c.GetString("userId")
+	orgID := c.Param("orgId")
+	var query dto.ListMembersQuery
+	perr := helpers.ParseQuery(c, &query)
+	if perr != nil {
+		return
+	}
+	members, meta, err := s.OrganizationService.ListMembers(c.Request.Context(), userID, orgID, &query)
+	if err != nil {
+		helpers.AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ApiSuccessResponse{
+		Status:  "success",
+		Message: "Members retrieved successfully",
+		Data:    gin.H{"members": members},
+		Meta:    meta,
 	})
 }
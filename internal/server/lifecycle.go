@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ListenAndServe listens on the configured port and serves until ctx is
+// cancelled, then shuts down gracefully.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Port))
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve runs the background jobs and serves HTTP on ln until ctx is
+// cancelled. Shutdown then happens in order: readiness starts failing, the
+// drain delay gives load balancers time to notice, in-flight requests get up
+// to the shutdown timeout to finish, background jobs stop and the database
+// pool is closed.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:      s.RegisterRoutes(),
+		IdleTimeout:  time.Duration(s.Config.Server.IdleTimeout),
+		ReadTimeout:  time.Duration(s.Config.Server.ReadTimeout),
+		WriteTimeout: time.Duration(s.Config.Server.WriteTimeout),
+	}
+
+	stop := make(chan struct{})
+	var jobs sync.WaitGroup
+	for _, job := range s.BackgroundJobs {
+		jobs.Add(1)
+		go func(job func(stop <-chan struct{})) {
+			defer jobs.Done()
+			job(stop)
+		}(job)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// The listener failed before any shutdown was requested
+	case <-ctx.Done():
+		s.logger().InfoContext(ctx, "Shutting down", "drainDelay", s.Config.Server.DrainDelay.String())
+		s.BeginDrain()
+		time.Sleep(time.Duration(s.Config.Server.DrainDelay))
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.Server.ShutdownTimeout))
+		defer cancel()
+		if err = srv.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("in-flight requests did not finish: %w", err)
+		} else if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+			err = serveErr
+		}
+	}
+
+	close(stop)
+	jobs.Wait()
+	if s.Db != nil {
+		if closeErr := s.Db.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing database: %w", closeErr)
+		}
+	}
+	return err
+}
+
+// BeginDrain makes the readiness probe fail so no new traffic is routed here.
+func (s *Server) BeginDrain() {
+	s.draining.Store(true)
+}
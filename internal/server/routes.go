@@ -1,26 +1,95 @@
 package server
 
 import (
+	"context"
+	"h-two/internal/metrics"
 	"h-two/internal/middleware"
+	"h-two/internal/models"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
-	r := gin.Default()
+	r := gin.New()
+	var requestTimeout time.Duration
+	var trustedProxies []string
+	if s.Config != nil {
+		requestTimeout = time.Duration(s.Config.Database.RequestTimeout)
+		trustedProxies = s.Config.Server.TrustedProxies
+	}
+	// Gin trusts X-Forwarded-For from anyone by default, which would let a
+	// client pick the address its login attempts are counted against
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		s.logger().Error("Ignoring invalid trusted proxies", "error", err)
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(middleware.RequestID(), middleware.Tracing(), middleware.AccessLog(s.logger()), middleware.Metrics(), middleware.Recovery(s.logger()))
+	r.Use(middleware.RequestContext(requestTimeout))
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, s.PersonalAccessTokenService)
+	// tokenAuth also accepts personal access tokens granted scope
+	tokenAuth := func(scope string) gin.HandlerFunc {
+		return middleware.AuthMiddleware(s.Keys, s.TokenRevocations, s.PersonalAccessTokenService, scope)
+	}
+	userRead := tokenAuth(models.ScopeUserRead)
+	userWrite := tokenAuth(models.ScopeUserWrite)
+	orgsRead := tokenAuth(models.ScopeOrganizationsRead)
+	orgsWrite := tokenAuth(models.ScopeOrganizationsWrite)
+	verifiedEmail := middleware.RequireVerifiedEmail(s.UserService)
+	orgMfa := middleware.RequireOrganizationMfa(s.OrganizationService)
+	loginLimit := middleware.LoginRateLimit(s.LoginLimiter)
 
 	r.GET("/", s.HelloWorldHandler)
+	r.GET("/healthz", s.HealthzHandler)
+	r.GET("/readyz", s.ReadyzHandler)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/.well-known/jwks.json", s.JWKSHandler)
 	authGroup := r.Group("/auth")
 	apiGroup := r.Group("/api")
 	{
 		authGroup.POST("/register", s.RegisterHandler)
-		authGroup.POST("/login", s.LoginHandler)
-		apiGroup.GET("/users/:id", middleware.AuthMiddleware, s.GetUserDetailsHandler)
-		apiGroup.GET("/organisations", middleware.AuthMiddleware, s.GetOrganizationsHandler)
-		apiGroup.GET("/organisations/:orgId", middleware.AuthMiddleware, s.GetOrganizationHandler)
-		apiGroup.POST("/organisations", middleware.AuthMiddleware, s.CreateOrganizationHandler)
-		apiGroup.POST("/organisations/:orgId/users", middleware.AuthMiddleware, s.AddUserToOrganizationHandler)
+		authGroup.POST("/login", loginLimit, s.LoginHandler)
+		authGroup.POST("/login/mfa", loginLimit, s.LoginMfaHandler)
+		authGroup.POST("/refresh", s.RefreshTokenHandler)
+		authGroup.POST("/logout", authMiddleware, s.LogoutHandler)
+		authGroup.POST("/logout-all", authMiddleware, s.LogoutAllHandler)
+		authGroup.POST("/forgot-password", s.ForgotPasswordHandler)
+		authGroup.POST("/reset-password", s.ResetPasswordHandler)
+		authGroup.POST("/verify-email", s.VerifyEmailHandler)
+		authGroup.POST("/resend-verification", s.ResendVerificationHandler)
+		authGroup.POST("/email-change/confirm", s.ConfirmEmailChangeHandler)
+		authGroup.POST("/email-change/cancel", s.CancelEmailChangeHandler)
+		apiGroup.GET("/users/:id", userRead, s.GetUserDetailsHandler)
+		apiGroup.PATCH("/users/me", userWrite, s.UpdateProfileHandler)
+		apiGroup.DELETE("/users/me", authMiddleware, s.DeleteAccountHandler)
+		apiGroup.POST("/users/me/restore", authMiddleware, s.CancelAccountDeletionHandler)
+		apiGroup.GET("/users/me/export", authMiddleware, s.ExportAccountHandler)
+		apiGroup.POST("/users/me/password", authMiddleware, s.ChangePasswordHandler)
+		apiGroup.POST("/users/me/email", authMiddleware, s.ChangeEmailHandler)
+		apiGroup.POST("/users/me/mfa/totp", authMiddleware, s.EnrollTotpHandler)
+		apiGroup.POST("/users/me/mfa/totp/confirm", authMiddleware, s.ConfirmTotpHandler)
+		apiGroup.POST("/users/me/mfa/totp/disable", authMiddleware, s.DisableTotpHandler)
+		apiGroup.POST("/users/me/mfa/recovery-codes", authMiddleware, s.RegenerateRecoveryCodesHandler)
+		apiGroup.GET("/users/me/tokens", authMiddleware, s.GetPersonalAccessTokensHandler)
+		apiGroup.POST("/users/me/tokens", authMiddleware, s.CreatePersonalAccessTokenHandler)
+		apiGroup.DELETE("/users/me/tokens/:tokenId", authMiddleware, s.RevokePersonalAccessTokenHandler)
+		apiGroup.GET("/organisations", orgsRead, s.GetOrganizationsHandler)
+		apiGroup.GET("/organisations/:orgId", orgsRead, orgMfa, s.GetOrganizationHandler)
+		apiGroup.POST("/organisations", orgsWrite, verifiedEmail, s.CreateOrganizationHandler)
+		apiGroup.PATCH("/organisations/:orgId", orgsWrite, orgMfa, s.UpdateOrganizationHandler)
+		apiGroup.DELETE("/organisations/:orgId", orgsWrite, orgMfa, s.DeleteOrganizationHandler)
+		apiGroup.POST("/organisations/:orgId/restore", orgsWrite, s.RestoreOrganizationHandler)
+		apiGroup.GET("/organisations/:orgId/users", orgsRead, orgMfa, s.GetOrganizationMembersHandler)
+		apiGroup.POST("/organisations/:orgId/users", orgsWrite, orgMfa, verifiedEmail, s.AddUserToOrganizationHandler)
+		apiGroup.PUT("/organisations/:orgId/users/:userId/role", orgsWrite, orgMfa, s.UpdateMemberRoleHandler)
+		apiGroup.DELETE("/organisations/:orgId/users/:userId", orgsWrite, orgMfa, s.RemoveMemberHandler)
+		apiGroup.POST("/organisations/:orgId/leave", orgsWrite, s.LeaveOrganizationHandler)
+		apiGroup.POST("/organisations/:orgId/transfer-ownership", authMiddleware, orgMfa, s.TransferOwnershipHandler)
+		apiGroup.POST("/organisations/:orgId/invitations", orgsWrite, orgMfa, verifiedEmail, s.CreateInvitationHandler)
+		apiGroup.GET("/organisations/:orgId/invitations", orgsRead, orgMfa, s.GetInvitationsHandler)
+		apiGroup.DELETE("/organisations/:orgId/invitations/:invitationId", orgsWrite, orgMfa, s.RevokeInvitationHandler)
+		apiGroup.PUT("/organisations/:orgId/mfa-policy", authMiddleware, orgMfa, s.UpdateMfaPolicyHandler)
 	}
 
 	return r
@@ -32,3 +101,32 @@ func (s *Server) HelloWorldHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// HealthzHandler is the liveness probe: it succeeds while the process can
+// serve requests at all.
+func (s *Server) HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler is the readiness probe: it fails while the server is
+// draining or the database cannot be reached.
+func (s *Server) ReadyzHandler(c *gin.Context) {
+	if s.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.Db.Ping(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "database unreachable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// JWKSHandler publishes the public signing keys so other services can verify
+// access tokens on their own.
+func (s *Server) JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.Keys.JWKS())
+}
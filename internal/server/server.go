@@ -1,53 +1,151 @@
 package server
 
 import (
-	"fmt"
+	"h-two/internal/config"
+	"h-two/internal/keyring"
+	"h-two/internal/logging"
+	"h-two/internal/mail"
+	"h-two/internal/migrations"
 	"h-two/internal/repository"
 	"h-two/internal/services"
-	"net/http"
+	"log/slog"
 	"os"
-	"strconv"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/joho/godotenv/autoload"
-
 	"h-two/internal/database"
 )
 
 type Server struct {
-	Port                int
-	AuthService         services.AuthService
-	UserService         services.UserService
-	OrganizationService services.OrganizationService
-	Db                  *database.DbService
+	Config                     *config.Config
+	Port                       int
+	AuthService                services.AuthService
+	UserService                services.UserService
+	OrganizationService        services.OrganizationService
+	MfaService                 services.MfaService
+	InvitationService          services.InvitationService
+	EmailChangeService         services.EmailChangeService
+	AccountService             services.AccountService
+	PersonalAccessTokenService services.PersonalAccessTokenService
+	TokenRevocations           repository.TokenRevocationRepository
+	LoginLimiter               services.LoginLimiter
+	Keys                       *keyring.Keyring
+	Db                         *database.DbService
+	Logger                     *slog.Logger
+	// BackgroundJobs run while the server is up and return once stop closes
+	BackgroundJobs []func(stop <-chan struct{})
+
+	draining atomic.Bool
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	dbInstance := database.New()
+// newTokenRevocationRepository picks the configured revocation backend.
+// Postgres is the default; "memory" keeps revocations in process and is only
+// suitable for a single instance.
+func newTokenRevocationRepository(store string, dbInstance *database.DbService) repository.TokenRevocationRepository {
+	if store == "memory" {
+		return repository.NewInMemoryTokenRevocationRepository()
+	}
+	return repository.NewTokenRevocationRepository(dbInstance.Db)
+}
+
+// newLoginThrottleRepository picks the configured login limit store, like
+// newTokenRevocationRepository.
+func newLoginThrottleRepository(store string, dbInstance *database.DbService) repository.LoginThrottleRepository {
+	if store == "memory" {
+		return repository.NewInMemoryLoginThrottleRepository()
+	}
+	return repository.NewLoginThrottleRepository(dbInstance.Db)
+}
+
+// NewLoginLimits converts the login limit configuration for the services.
+func NewLoginLimits(cfg config.LoginLimitConfig) services.LoginLimits {
+	return services.LoginLimits{
+		IpAttempts:    cfg.IpAttempts,
+		IpWindow:      time.Duration(cfg.IpWindow),
+		MaxFailures:   cfg.MaxFailures,
+		FailureWindow: time.Duration(cfg.FailureWindow),
+		Lockout:       time.Duration(cfg.Lockout),
+		MaxLockout:    time.Duration(cfg.MaxLockout),
+	}
+}
+
+// logger returns the server's logger, or the default one for servers built
+// without NewServer.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// NewServer wires the services from cfg, which must already be validated.
+func NewServer(cfg *config.Config, logger *slog.Logger) *Server {
+	fatal := func(msg string, args ...any) {
+		logger.Error(msg, args...)
+		os.Exit(1)
+	}
+	dbInstance := database.New(cfg.Database, logging.NewGormLogger(logger, time.Duration(cfg.Log.SlowQueryThreshold)))
+	embedded, err := migrations.Embedded()
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+	if err := migrations.New(dbInstance.Db, embedded).Check(); err != nil {
+		fatal("Refusing to start, run `h-two migrate up`", "error", err)
+	}
+	keys, err := keyring.Load(cfg.Auth.KeysDir)
+	if err != nil {
+		fatal("Failed to load signing keys, run `h-two keys rotate` to create one", "dir", cfg.Auth.KeysDir, "error", err)
+	}
+	mailer, err := mail.NewSender(cfg.Mail)
+	if err != nil {
+		fatal("Failed to set up mail sender", "error", err)
+	}
 
 	organizationRep := repository.NewOrganizationRepository(dbInstance.Db)
 	organizationService := services.NewOrganizationService(organizationRep)
-	userRepo := repository.NewUserRepository(dbInstance.Db)               // Pass the dbInstance to the UserRepository
-	authService := services.NewAuthService(userRepo, organizationService) // Pass the UserRepository to the AuthService
-	userService := services.NewUserService(userRepo)                      // Pass the UserRepository to the UserService
+	userRepo := repository.NewUserRepository(dbInstance.Db) // Pass the dbInstance to the UserRepository
+	refreshRepo := repository.NewRefreshTokenRepository(dbInstance.Db)
+	tokenRevocations := newTokenRevocationRepository(cfg.Auth.TokenRevocationStore, dbInstance)
+	userTokenRepo := repository.NewUserTokenRepository(dbInstance.Db)
+	personalAccessTokenRepo := repository.NewPersonalAccessTokenRepository(dbInstance.Db)
+	mfaService := services.NewMfaService(userRepo, repository.NewMfaRepository(dbInstance.Db), organizationService, cfg.Auth.MfaIssuer)
+	invitationService := services.NewInvitationService(repository.NewInvitationRepository(dbInstance.Db), organizationRep, userRepo, mailer, cfg.AppURL(), logger)
+	loginEvents := repository.NewLoginEventRepository(dbInstance.Db)
+	loginLimiter := services.NewLoginLimiter(newLoginThrottleRepository(cfg.LoginLimits.Store, dbInstance), NewLoginLimits(cfg.LoginLimits), logger)
+	txManager := repository.NewTxManager(dbInstance.Db)
+	authService := services.NewAuthService(userRepo, organizationService, refreshRepo, tokenRevocations, userTokenRepo, personalAccessTokenRepo, mailer, mfaService, invitationService, loginEvents, loginLimiter, txManager, keys, cfg.AppURL(), logger) // Pass the UserRepository to the AuthService
+	userService := services.NewUserService(userRepo, services.EmailVerificationPolicy(cfg.Auth.EmailVerificationPolicy), logger)                                                                                                                          // Pass the UserRepository to the UserService
+	emailChangeService := services.NewEmailChangeService(repository.NewEmailChangeRepository(dbInstance.Db), userRepo, refreshRepo, tokenRevocations, personalAccessTokenRepo, mailer, cfg.AppURL(), logger)
+	accountService := services.NewAccountService(userRepo, organizationRep, loginEvents, refreshRepo, tokenRevocations, mailer, logger)
+	personalAccessTokenService := services.NewPersonalAccessTokenService(personalAccessTokenRepo, userRepo, logger)
 
 	NewServer := &Server{
-		Port:                port,
-		AuthService:         authService,
-		UserService:         userService,
-		OrganizationService: organizationService,
-		Db:                  database.New(),
-	}
-
-	// Declare Server config
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.Port),
-		Handler:      NewServer.RegisterRoutes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Config:                     cfg,
+		Port:                       cfg.Server.Port,
+		AuthService:                authService,
+		UserService:                userService,
+		OrganizationService:        organizationService,
+		MfaService:                 mfaService,
+		InvitationService:          invitationService,
+		EmailChangeService:         emailChangeService,
+		AccountService:             accountService,
+		PersonalAccessTokenService: personalAccessTokenService,
+		TokenRevocations:           tokenRevocations,
+		LoginLimiter:               loginLimiter,
+		Keys:                       keys,
+		Db:                         dbInstance,
+		Logger:                     logger,
+		// Deleted organizations are purged once their restore period ends,
+		// deleted accounts once their cooling-off period ends, and login
+		// throttles once they go quiet
+		BackgroundJobs: []func(stop <-chan struct{}){
+			func(stop <-chan struct{}) {
+				services.RunOrganizationPurge(organizationService, time.Hour, logger, stop)
+			},
+			func(stop <-chan struct{}) { services.RunAccountPurge(accountService, time.Hour, logger, stop) },
+			func(stop <-chan struct{}) { services.RunLoginThrottlePurge(loginLimiter, time.Hour, logger, stop) },
+		},
 	}
 
-	return server
+	return NewServer
 }
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/mail"
+	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AccountDeletionCoolingOff is how long a deletion request can be cancelled
+// before the account is purged.
+const AccountDeletionCoolingOff = 14 * 24 * time.Hour
+
+type AccountService interface {
+	ExportAccount(ctx context.Context, userId string) (*dto.AccountExport, *errors.ApiError)
+	ScheduleDeletion(ctx context.Context, userId string, sessionId string, req *dto.DeleteAccountRequest) (*dto.DeleteAccountResponse, *errors.ApiError)
+	CancelDeletion(ctx context.Context, userId string) *errors.ApiError
+	PurgeDeletedAccounts(ctx context.Context) (int64, error)
+}
+
+type DefaultAccountService struct {
+	userRepo    repository.UserRepository
+	orgRepo     repository.OrganizationRepository
+	loginEvents repository.LoginEventRepository
+	refreshRepo repository.RefreshTokenRepository
+	revocations repository.TokenRevocationRepository
+	mailer      mail.Sender
+	logger      *slog.Logger
+}
+
+// ExportAccount collects the user's profile, memberships and login history.
+func (s *DefaultAccountService) ExportAccount(ctx context.Context, userId string) (*dto.AccountExport, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AccountService.ExportAccount")
+	defer span.End()
+	internal := &errors.ApiError{
+		Message:    "Export unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	u, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	orgs, err := s.orgRepo.GetMemberships(ctx, userId)
+	if err != nil {
+		return nil, internal
+	}
+	events, err := s.loginEvents.GetLoginEvents(ctx, userId)
+	if err != nil {
+		return nil, internal
+	}
+	export := &dto.AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: dto.AccountProfile{
+			UserId:              u.UserId,
+			FirstName:           u.FirstName,
+			LastName:            u.LastName,
+			Email:               u.Email,
+			Phone:               u.Phone,
+			EmailVerifiedAt:     u.EmailVerifiedAt,
+			MfaEnabled:          u.MfaEnabledAt != nil,
+			CreatedAt:           u.CreatedAt,
+			DeletionScheduledAt: u.DeletionScheduledAt,
+		},
+		Memberships:  []*dto.GetOrganizationResponse{},
+		LoginHistory: []*dto.LoginEventResponse{},
+	}
+	for _, org := range orgs {
+		export.Memberships = append(export.Memberships, toOrganizationResponse(org))
+	}
+	for _, event := range events {
+		export.LoginHistory = append(export.LoginHistory, &dto.LoginEventResponse{
+			Method:    event.Method,
+			IpAddress: event.IpAddress,
+			UserAgent: event.UserAgent,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return export, nil
+}
+
+// ScheduleDeletion marks the account for deletion after the cooling-off
+// period and signs out every other session. Owners must hand over or delete
+// their organizations first, since every organization needs an owner.
+func (s *DefaultAccountService) ScheduleDeletion(ctx context.Context, userId string, sessionId string, req *dto.DeleteAccountRequest) (*dto.DeleteAccountResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AccountService.ScheduleDeletion")
+	defer span.End()
+	internal := &errors.ApiError{
+		Message:    "Account deletion unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	u, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	if !verifyPassword(ctx, req.Password, u.Password) {
+		return nil, &errors.ApiError{
+			Message:    "Password is incorrect",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	owned, err := s.orgRepo.GetOwnedOrganizations(ctx, userId)
+	if err != nil {
+		return nil, internal
+	}
+	if len(owned) > 0 {
+		names := make([]string, len(owned))
+		for i, org := range owned {
+			names[i] = org.Name
+		}
+		return nil, &errors.ApiError{
+			Message:    fmt.Sprintf("You are the owner of %s; transfer ownership or delete them first", strings.Join(names, ", ")),
+			StatusCode: http.StatusConflict,
+			Status:     errors.ValidationError,
+		}
+	}
+	if u.DeletionScheduledAt != nil {
+		return &dto.DeleteAccountResponse{DeletionScheduledAt: *u.DeletionScheduledAt}, nil
+	}
+	at := time.Now().Add(AccountDeletionCoolingOff)
+	if err := s.userRepo.ScheduleDeletion(ctx, userId, &at); err != nil {
+		return nil, internal
+	}
+	if err := revokeUserSessions(ctx, s.revocations, s.refreshRepo, userId, sessionId); err != nil {
+		return nil, internal
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account and all of its data will be permanently deleted on %s. If you change your mind, log in before then and cancel the deletion from your account settings.",
+			u.FirstName, at.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send account deletion notice", "userId", userId, "error", err)
+	}
+	return &dto.DeleteAccountResponse{DeletionScheduledAt: at}, nil
+}
+
+func (s *DefaultAccountService) CancelDeletion(ctx context.Context, userId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AccountService.CancelDeletion")
+	defer span.End()
+	u, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	if u.DeletionScheduledAt == nil {
+		return &errors.ApiError{
+			Message:    "Account deletion is not scheduled",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	if err := s.userRepo.ScheduleDeletion(ctx, userId, nil); err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return nil
+}
+
+// PurgeDeletedAccounts permanently deletes accounts whose cooling-off period
+// has ended.
+func (s *DefaultAccountService) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.PurgeDeletedAccounts")
+	defer span.End()
+	return s.userRepo.PurgeScheduledDeletions(ctx, time.Now())
+}
+
+func NewAccountService(userRepo repository.UserRepository, orgRepo repository.OrganizationRepository, loginEvents repository.LoginEventRepository, refreshRepo repository.RefreshTokenRepository, revocations repository.TokenRevocationRepository, mailer mail.Sender, logger *slog.Logger) *DefaultAccountService {
+	return &DefaultAccountService{
+		userRepo:    userRepo,
+		orgRepo:     orgRepo,
+		loginEvents: loginEvents,
+		refreshRepo: refreshRepo,
+		revocations: revocations,
+		mailer:      mailer,
+		logger:      logger,
+	}
+}
@@ -1,64 +1,248 @@
 package services
 
 import (
+	"context"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"h-two/internal/dto"
 	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/keyring"
+	"h-two/internal/mail"
+	"h-two/internal/metrics"
 	"h-two/internal/models"
 	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 )
 
 const TokenDuration = 1 * time.Hour
 
-var SecretKey = os.Getenv("JWT_SECRET")
+// RefreshTokenDuration is how long a refresh token stays usable. Every use
+// rotates it, so an active client never hits this limit.
+const RefreshTokenDuration = 30 * 24 * time.Hour
+
+const PasswordResetTokenDuration = 1 * time.Hour
+
+const EmailVerificationTokenDuration = 48 * time.Hour
+
+// MfaChallengeDuration is how long a user has to enter their second factor
+// after giving the right password.
+const MfaChallengeDuration = 5 * time.Minute
+
+// Values of the "typ" claim, so one kind of token cannot be used as another.
+const (
+	AccessTokenType       = "access"
+	MfaChallengeTokenType = "mfa_challenge"
+)
 
 type AuthService interface {
-	CreateUser(c *gin.Context, user *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError)
-	Login(c *gin.Context, user *dto.LoginRequest) (*dto.LoginResponse, *errors.ApiError)
-	CreateUserAndOrganization(c *gin.Context, req *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError)
+	CreateUser(ctx context.Context, user *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError)
+	Login(ctx context.Context, user *dto.LoginRequest) (*dto.LoginResponse, *errors.ApiError)
+	LoginMfa(ctx context.Context, req *dto.LoginMfaRequest) (*dto.LoginResponse, *errors.ApiError)
+	CreateUserAndOrganization(ctx context.Context, req *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError)
+	Refresh(ctx context.Context, req *dto.RefreshTokenRequest) (*dto.RefreshTokenResponse, *errors.ApiError)
+	Logout(ctx context.Context, userId string, sessionId string, tokenId string, expiresAt time.Time) *errors.ApiError
+	LogoutAll(ctx context.Context, userId string) *errors.ApiError
+	ForgotPassword(ctx context.Context, req *dto.ForgotPasswordRequest) *errors.ApiError
+	ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) *errors.ApiError
+	ChangePassword(ctx context.Context, userId string, sessionId string, req *dto.ChangePasswordRequest) *errors.ApiError
+	VerifyEmail(ctx context.Context, req *dto.VerifyEmailRequest) *errors.ApiError
+	ResendVerification(ctx context.Context, req *dto.ResendVerificationRequest) *errors.ApiError
 }
 
 type DefaultAuthService struct {
-	repo       repository.UserRepository
-	orgService OrganizationService
+	repo           repository.UserRepository
+	orgService     OrganizationService
+	refreshRepo    repository.RefreshTokenRepository
+	revocations    repository.TokenRevocationRepository
+	userTokens     repository.UserTokenRepository
+	personalTokens repository.PersonalAccessTokenRepository
+	mailer         mail.Sender
+	mfaService     MfaService
+	invitations    InvitationService
+	loginEvents    repository.LoginEventRepository
+	limiter        LoginLimiter
+	tx             repository.TxManager
+	keys           *keyring.Keyring
+	appURL         string
+	logger         *slog.Logger
 }
 
 func HashPassword(password string) (string, error) {
+	start := time.Now()
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	metrics.BcryptDuration.WithLabelValues("hash").Observe(time.Since(start).Seconds())
 	if err != nil {
 		return "", err
 	}
 	return string(hash), nil
 }
 
-func verifyPassword(password, hash string) bool {
+// normalizeEmail is the form addresses are stored and looked up in. They are
+// unique regardless of case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// hashPassword is HashPassword traced as a child of ctx.
+func hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracing.Start(ctx, "bcrypt.hash")
+	defer span.End()
+	return HashPassword(password)
+}
+
+func verifyPassword(ctx context.Context, password, hash string) bool {
+	_, span := tracing.Start(ctx, "bcrypt.verify")
+	defer span.End()
+	start := time.Now()
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	metrics.BcryptDuration.WithLabelValues("verify").Observe(time.Since(start).Seconds())
 	return err == nil
 }
 
-func GenerateJWT(userId string) (string, error) {
-	secretKey := os.Getenv("JWT_SECRET")
-	expirationTime := time.Now().Add(1 * time.Hour).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func GenerateJWT(keys *keyring.Keyring, userId string) (string, error) {
+	return GenerateSessionJWT(keys, userId, "", false)
+}
+
+// GenerateSessionJWT mints an access token bound to a session, which is the
+// refresh token family it was issued with. Every token carries a unique jti
+// so it can be revoked on its own, and records whether the session passed MFA.
+func GenerateSessionJWT(keys *keyring.Keyring, userId string, sessionId string, mfa bool) (string, error) {
+	jti, err := helpers.NewUUID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"typ":    AccessTokenType,
 		"userId": userId,
-		"exp":    expirationTime,
-	})
-	tokenString, err := token.SignedString([]byte(secretKey))
+		"jti":    jti,
+		"mfa":    mfa,
+		"iat":    now.Unix(),
+		"iatMs":  now.UnixMilli(),
+		"exp":    now.Add(TokenDuration).Unix(),
+	}
+	if sessionId != "" {
+		claims["sid"] = sessionId
+	}
+	return keys.Sign(claims)
+}
+
+// GenerateMfaChallengeJWT mints the token returned by Login when a second
+// factor is still needed. The middleware does not accept it as an access
+// token.
+func GenerateMfaChallengeJWT(keys *keyring.Keyring, userId string) (string, error) {
+	jti, err := helpers.NewUUID()
 	if err != nil {
 		return "", err
 	}
-	return tokenString, nil
+	now := time.Now()
+	return keys.Sign(jwt.MapClaims{
+		"typ":    MfaChallengeTokenType,
+		"userId": userId,
+		"jti":    jti,
+		"iat":    now.Unix(),
+		"iatMs":  now.UnixMilli(),
+		"exp":    now.Add(MfaChallengeDuration).Unix(),
+	})
 }
 
-func (s *DefaultAuthService) CreateUser(c *gin.Context, user *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError) {
-	// Check if the user already exists
+// TokenIssuedAt returns when a token was issued. iat only has second
+// resolution, so the millisecond iatMs claim is preferred; without it a token
+// issued in the same second as a revocation counts as revoked.
+func TokenIssuedAt(claims jwt.MapClaims) time.Time {
+	if ms, ok := claims["iatMs"].(float64); ok {
+		return time.UnixMilli(int64(ms))
+	}
+	iat, _ := claims["iat"].(float64)
+	return time.Unix(int64(iat), 0)
+}
+
+type mfaChallenge struct {
+	UserId    string
+	Jti       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func parseMfaChallengeJWT(keys *keyring.Keyring, tokenString string) (*mfaChallenge, error) {
+	token, err := keys.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["typ"] != MfaChallengeTokenType {
+		return nil, fmt.Errorf("not an MFA challenge token")
+	}
+	userId, _ := claims["userId"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if userId == "" || jti == "" || exp == 0 {
+		return nil, fmt.Errorf("incomplete MFA challenge token")
+	}
+	return &mfaChallenge{
+		UserId:    userId,
+		Jti:       jti,
+		IssuedAt:  TokenIssuedAt(claims),
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+func newRefreshToken(userId string, familyId string, mfa bool) (*models.RefreshToken, string, error) {
+	id, err := helpers.NewUUID()
+	if err != nil {
+		return nil, "", err
+	}
+	if familyId == "" {
+		familyId = id
+	}
+	raw, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	return &models.RefreshToken{
+		Id:        id,
+		UserId:    userId,
+		FamilyId:  familyId,
+		TokenHash: helpers.HashToken(raw),
+		ExpiresAt: time.Now().Add(RefreshTokenDuration),
+		Mfa:       mfa,
+	}, raw, nil
+}
 
-	if u, _ := s.repo.GetUserByEmail(user.Email); u != nil {
+// issueRefreshToken starts a new refresh token family for the user and returns
+// the raw token along with the family id. Only the token hash is stored.
+func (s *DefaultAuthService) issueRefreshToken(ctx context.Context, userId string, mfa bool) (string, string, error) {
+	token, raw, err := newRefreshToken(userId, "", mfa)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.refreshRepo.CreateRefreshToken(ctx, token); err != nil {
+		return "", "", err
+	}
+	return raw, token.FamilyId, nil
+}
+
+func (s *DefaultAuthService) CreateUser(ctx context.Context, user *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AuthService.CreateUser")
+	defer span.End()
+	userResponse, apiErr := s.insertUser(ctx, user)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return s.startRegistrationSession(ctx, userResponse)
+}
+
+// insertUser stores a new user with a hashed password.
+func (s *DefaultAuthService) insertUser(ctx context.Context, user *dto.CreateUserRequest) (*dto.UserResponse, *errors.ApiError) {
+	user.Email = normalizeEmail(user.Email)
+	// Check if the user already exists
+	if u, _ := s.repo.GetUserByEmail(ctx, user.Email); u != nil {
 		return nil, &errors.ApiError{
 			Status:     errors.ValidationError,
 			Message:    "Registration unsuccessful",
@@ -67,7 +251,7 @@ func (s *DefaultAuthService) CreateUser(c *gin.Context, user *dto.CreateUserRequ
 
 	}
 	// Hash the user's password
-	hash, err := HashPassword(user.Password)
+	hash, err := hashPassword(ctx, user.Password)
 	if err != nil {
 		return nil, &errors.ApiError{
 			Status:     errors.InternalServerError,
@@ -78,7 +262,7 @@ func (s *DefaultAuthService) CreateUser(c *gin.Context, user *dto.CreateUserRequ
 	user.Password = hash
 
 	// Save the user to the database
-	userResponse, dbErr := s.repo.CreateUser(&models.User{FirstName: user.FirstName,
+	userResponse, dbErr := s.repo.CreateUser(ctx, &models.User{FirstName: user.FirstName,
 		Email:    user.Email,
 		Password: user.Password,
 		LastName: user.LastName,
@@ -91,8 +275,26 @@ func (s *DefaultAuthService) CreateUser(c *gin.Context, user *dto.CreateUserRequ
 			StatusCode: http.StatusUnauthorized,
 		}
 	}
+	return userResponse, nil
+}
+
+// startRegistrationSession signs a newly stored user in and sends the
+// verification email. It runs once the account is committed, so no email goes
+// out for an account that was rolled back.
+func (s *DefaultAuthService) startRegistrationSession(ctx context.Context, userResponse *dto.UserResponse) (*dto.CreateUserResponse, *errors.ApiError) {
+	if err := s.sendVerificationEmail(ctx, userResponse.UserId, userResponse.Email, userResponse.FirstName); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send verification email", "userId", userResponse.UserId, "error", err)
+	}
+	refreshToken, sessionId, err := s.issueRefreshToken(ctx, userResponse.UserId, false)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Registration unsuccessful",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
 	// Generate a JWT token
-	token, err := GenerateJWT(userResponse.UserId)
+	token, err := GenerateSessionJWT(s.keys, userResponse.UserId, sessionId, false)
 	if err != nil {
 		return nil, &errors.ApiError{
 			Status:     errors.ValidationError,
@@ -100,18 +302,43 @@ func (s *DefaultAuthService) CreateUser(c *gin.Context, user *dto.CreateUserRequ
 			StatusCode: http.StatusUnauthorized,
 		}
 	}
+	s.recordLogin(ctx, userResponse.UserId, models.LoginMethodRegistration)
 
 	return &dto.CreateUserResponse{
-		AccessToken: token,
-		User:        *userResponse,
+		AccessToken:  token,
+		RefreshToken: refreshToken,
+		User:         *userResponse,
 	}, nil
 }
 
-func (s *DefaultAuthService) Login(c *gin.Context, user *dto.LoginRequest) (*dto.LoginResponse, *errors.ApiError) {
+func (s *DefaultAuthService) Login(ctx context.Context, user *dto.LoginRequest) (*dto.LoginResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AuthService.Login")
+	defer span.End()
+	resp, apiErr := s.login(ctx, user)
+	recordLoginResult("password", resp, apiErr)
+	return resp, apiErr
+}
+
+// recordLoginResult counts the outcome of a login step.
+func recordLoginResult(step string, resp *dto.LoginResponse, apiErr *errors.ApiError) {
+	result := metrics.ResultSuccess
+	if apiErr != nil {
+		result = metrics.Result(apiErr.StatusCode)
+	} else if resp.MfaRequired {
+		result = metrics.ResultMfaRequired
+	}
+	metrics.Logins.WithLabelValues(step, result).Inc()
+}
 
+func (s *DefaultAuthService) login(ctx context.Context, user *dto.LoginRequest) (*dto.LoginResponse, *errors.ApiError) {
+	user.Email = normalizeEmail(user.Email)
+	if apiErr := s.limiter.CheckAccount(ctx, user.Email); apiErr != nil {
+		return nil, apiErr
+	}
 	// Get the user from the database
-	u, err := s.repo.GetUserByEmail(user.Email)
+	u, err := s.repo.GetUserByEmail(ctx, user.Email)
 	if err != nil {
+		s.limiter.RecordFailure(ctx, user.Email)
 		return nil, &errors.ApiError{
 			Status:     errors.ValidationError,
 			Message:    "Authentication Failed",
@@ -119,15 +346,151 @@ func (s *DefaultAuthService) Login(c *gin.Context, user *dto.LoginRequest) (*dto
 		}
 	}
 	// Verify the user's password
-	if !verifyPassword(user.Password, u.Password) {
+	if !verifyPassword(ctx, user.Password, u.Password) {
+		s.limiter.RecordFailure(ctx, user.Email)
 		return nil, &errors.ApiError{
 			Status:     errors.ValidationError,
 			Message:    "Authentication Failed",
 			StatusCode: http.StatusUnauthorized,
 		}
 	}
+	// Users with MFA get a short-lived challenge instead of a session
+	if u.MfaEnabledAt != nil {
+		// The invitation is accepted once the second factor is checked, but
+		// a bad token should fail before the user reaches for their app
+		if user.InviteToken != "" {
+			if _, apiErr := s.invitations.CheckInvitation(ctx, u.Email, user.InviteToken); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+		challenge, err := GenerateMfaChallengeJWT(s.keys, u.UserId)
+		if err != nil {
+			return nil, &errors.ApiError{
+				Status:     errors.InternalServerError,
+				Message:    "Authentication Failed",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		return &dto.LoginResponse{
+			MfaRequired: true,
+			MfaToken:    challenge,
+		}, nil
+	}
+	// Failures are only cleared once the user is fully signed in, so a
+	// known password does not reset the count for MFA codes
+	s.limiter.RecordSuccess(ctx, u.Email)
+	joined, apiErr := s.acceptInvitation(ctx, u, user.InviteToken)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	resp, apiErr := s.startSession(ctx, u, false)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	resp.JoinedOrganization = joined
+	if required, apiErr := s.orgService.IsMfaRequiredForUser(ctx, u.UserId); apiErr == nil {
+		resp.MfaEnrollmentRequired = required
+	}
+	return resp, nil
+}
+
+// LoginMfa completes a login started by Login for a user with MFA enabled.
+// A challenge token can only be presented once, whether or not the code is
+// correct, so codes cannot be brute forced without the password.
+func (s *DefaultAuthService) LoginMfa(ctx context.Context, req *dto.LoginMfaRequest) (*dto.LoginResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AuthService.LoginMfa")
+	defer span.End()
+	resp, apiErr := s.loginMfa(ctx, req)
+	recordLoginResult("mfa", resp, apiErr)
+	return resp, apiErr
+}
+
+func (s *DefaultAuthService) loginMfa(ctx context.Context, req *dto.LoginMfaRequest) (*dto.LoginResponse, *errors.ApiError) {
+	invalid := &errors.ApiError{
+		Status:     errors.UnAuthorized,
+		Message:    "Invalid or expired MFA token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	internal := &errors.ApiError{
+		Status:     errors.InternalServerError,
+		Message:    "Authentication Failed",
+		StatusCode: http.StatusInternalServerError,
+	}
+	challenge, err := parseMfaChallengeJWT(s.keys, req.MfaToken)
+	if err != nil {
+		return nil, invalid
+	}
+	revoked, err := s.revocations.IsTokenRevoked(ctx, challenge.Jti, challenge.UserId, "", challenge.IssuedAt)
+	if err != nil {
+		return nil, internal
+	}
+	if revoked {
+		return nil, invalid
+	}
+	if err := s.revocations.RevokeToken(ctx, challenge.Jti, challenge.UserId, challenge.ExpiresAt); err != nil {
+		return nil, internal
+	}
+	u, err := s.repo.GetUserById(ctx, challenge.UserId)
+	if err != nil {
+		return nil, invalid
+	}
+	if apiErr := s.limiter.CheckAccount(ctx, u.Email); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.mfaService.VerifySecondFactor(ctx, u, req.Code, req.RecoveryCode); apiErr != nil {
+		if apiErr.StatusCode < http.StatusInternalServerError {
+			s.limiter.RecordFailure(ctx, u.Email)
+		}
+		return nil, apiErr
+	}
+	s.limiter.RecordSuccess(ctx, u.Email)
+	joined, apiErr := s.acceptInvitation(ctx, u, req.InviteToken)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	resp, apiErr := s.startSession(ctx, u, true)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	resp.JoinedOrganization = joined
+	return resp, nil
+}
+
+// acceptInvitation accepts an optional invitation token presented at login
+// or registration.
+func (s *DefaultAuthService) acceptInvitation(ctx context.Context, u *models.User, token string) (*dto.OrganizationMemberResponse, *errors.ApiError) {
+	if token == "" {
+		return nil, nil
+	}
+	return s.invitations.AcceptInvitation(ctx, u, token)
+}
+
+// recordLogin adds a sign-in to the user's login history. Failing to record
+// it does not fail the login.
+func (s *DefaultAuthService) recordLogin(ctx context.Context, userId string, method string) {
+	client := clientInfoFrom(ctx)
+	event := &models.LoginEvent{UserId: userId, Method: method, IpAddress: client.IpAddress, UserAgent: client.UserAgent}
+	if len(event.UserAgent) > 255 {
+		event.UserAgent = event.UserAgent[:255]
+	}
+	if err := s.loginEvents.RecordLogin(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record login", "userId", userId, "error", err)
+	}
+}
+
+// startSession issues a refresh token family and an access token for a user
+// who has fully authenticated.
+func (s *DefaultAuthService) startSession(ctx context.Context, u *models.User, mfa bool) (*dto.LoginResponse, *errors.ApiError) {
+	refreshToken, sessionId, err := s.issueRefreshToken(ctx, u.UserId, mfa)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Authentication Failed",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
 	// Generate a JWT token
-	token, err := GenerateJWT(u.UserId)
+	token, err := GenerateSessionJWT(s.keys, u.UserId, sessionId, mfa)
 	if err != nil {
 		return nil, &errors.ApiError{
 			Status:     errors.ValidationError,
@@ -135,9 +498,15 @@ func (s *DefaultAuthService) Login(c *gin.Context, user *dto.LoginRequest) (*dto
 			StatusCode: http.StatusUnauthorized,
 		}
 	}
+	method := models.LoginMethodPassword
+	if mfa {
+		method = models.LoginMethodMfa
+	}
+	s.recordLogin(ctx, u.UserId, method)
 	return &dto.LoginResponse{
-		AccessToken: token,
-		User: struct {
+		AccessToken:  token,
+		RefreshToken: refreshToken,
+		User: &struct {
 			UserId    string `json:"userId"`
 			FirstName string `json:"firstName"`
 			LastName  string `json:"lastName"`
@@ -153,39 +522,357 @@ func (s *DefaultAuthService) Login(c *gin.Context, user *dto.LoginRequest) (*dto
 	}, nil
 }
 
-func (s *DefaultAuthService) CreateUserAndOrganization(c *gin.Context, req *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError) {
-	// Start a new transaction
-	tx := s.repo.Begin()
+func (s *DefaultAuthService) CreateUserAndOrganization(ctx context.Context, req *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AuthService.CreateUserAndOrganization")
+	defer span.End()
+	resp, apiErr := s.createUserAndOrganization(ctx, req)
+	result := metrics.ResultSuccess
+	if apiErr != nil {
+		result = metrics.Result(apiErr.StatusCode)
+	}
+	metrics.Registrations.WithLabelValues(result).Inc()
+	return resp, apiErr
+}
 
-	// Check for errors starting the transaction
-	if tx.Error != nil {
-		return nil, &errors.ApiError{
-			Status:     "error",
-			Message:    errors.InternalServerError,
+func (s *DefaultAuthService) createUserAndOrganization(ctx context.Context, req *dto.CreateUserRequest) (*dto.CreateUserResponse, *errors.ApiError) {
+	// Reject a bad invitation before the account is created
+	if req.InviteToken != "" {
+		if _, apiErr := s.invitations.CheckInvitation(ctx, req.Email, req.InviteToken); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	// The user and their organization are created together or not at all
+	var user *dto.UserResponse
+	apiErr := inTransaction(ctx, s.tx, s.logger, func(ctx context.Context) *errors.ApiError {
+		var err *errors.ApiError
+		if user, err = s.insertUser(ctx, req); err != nil {
+			return err
+		}
+		if err = s.orgService.CreateOrganizationByFirstName(ctx, req.FirstName, user.UserId); err != nil {
+			return &errors.ApiError{
+				Status:     "error",
+				Message:    errors.InternalServerError,
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		return nil
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	resp, apiErr := s.startRegistrationSession(ctx, user)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	joined, apiErr := s.acceptInvitation(ctx, &models.User{UserId: resp.User.UserId, Email: resp.User.Email}, req.InviteToken)
+	if apiErr != nil {
+		// The account exists at this point; the invitation can still be
+		// accepted at the next login
+		s.logger.WarnContext(ctx, "Failed to accept invitation at registration", "userId", resp.User.UserId, "error", apiErr.Message)
+	}
+	resp.JoinedOrganization = joined
+	return resp, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The presented token is revoked on success; presenting it again is
+// treated as theft and revokes every token in its family.
+func (s *DefaultAuthService) Refresh(ctx context.Context, req *dto.RefreshTokenRequest) (*dto.RefreshTokenResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "AuthService.Refresh")
+	defer span.End()
+	invalid := &errors.ApiError{
+		Status:     errors.UnAuthorized,
+		Message:    "Invalid refresh token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	internal := &errors.ApiError{
+		Status:     errors.InternalServerError,
+		Message:    "Could not refresh token",
+		StatusCode: http.StatusInternalServerError,
+	}
+
+	current, err := s.refreshRepo.GetRefreshTokenByHash(ctx, helpers.HashToken(req.RefreshToken))
+	if err != nil {
+		return nil, invalid
+	}
+	if current.RevokedAt != nil {
+		// A rotated token was replayed: either the client or an attacker
+		// holds a stale copy, so nobody in this family can be trusted.
+		if err := s.refreshRepo.RevokeRefreshTokenFamily(ctx, current.FamilyId); err != nil {
+			return nil, internal
+		}
+		return nil, invalid
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, invalid
+	}
+
+	next, raw, err := newRefreshToken(current.UserId, current.FamilyId, current.Mfa)
+	if err != nil {
+		return nil, internal
+	}
+	rotated, err := s.refreshRepo.RotateRefreshToken(ctx, current.Id, next)
+	if err != nil {
+		return nil, internal
+	}
+	if !rotated {
+		// Lost a race with another request using the same token.
+		if err := s.refreshRepo.RevokeRefreshTokenFamily(ctx, current.FamilyId); err != nil {
+			return nil, internal
+		}
+		return nil, invalid
+	}
+
+	token, err := GenerateSessionJWT(s.keys, current.UserId, current.FamilyId, current.Mfa)
+	if err != nil {
+		return nil, internal
+	}
+	return &dto.RefreshTokenResponse{
+		AccessToken:  token,
+		RefreshToken: raw,
+	}, nil
+}
+
+// Logout ends the session the access token tokenId belongs to: the token
+// itself is revoked and so is the refresh token family it was issued with.
+func (s *DefaultAuthService) Logout(ctx context.Context, userId string, sessionId string, tokenId string, expiresAt time.Time) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer span.End()
+	internal := &errors.ApiError{
+		Status:     errors.InternalServerError,
+		Message:    "Logout unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+	}
+	err := s.revocations.RevokeToken(ctx, tokenId, userId, expiresAt)
+	if err != nil {
+		return internal
+	}
+	if sessionId != "" {
+		if err := s.refreshRepo.RevokeRefreshTokenFamily(ctx, sessionId); err != nil {
+			return internal
+		}
+	}
+	return nil
+}
+
+// LogoutAll ends every session of the user, including the one making the
+// request. Personal access tokens are not sessions and keep working.
+func (s *DefaultAuthService) LogoutAll(ctx context.Context, userId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.LogoutAll")
+	defer span.End()
+	if err := s.revokeSessions(ctx, userId, ""); err != nil {
+		return &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Logout unsuccessful",
 			StatusCode: http.StatusInternalServerError,
 		}
 	}
+	return nil
+}
 
-	resp, err := s.CreateUser(c, req)
+func (s *DefaultAuthService) revokeSessions(ctx context.Context, userId string, exceptSessionId string) error {
+	return revokeUserSessions(ctx, s.revocations, s.refreshRepo, userId, exceptSessionId)
+}
+
+// revokeUserSessions ends every session of the user except exceptSessionId,
+// which may be empty to end them all.
+func revokeUserSessions(ctx context.Context, revocations repository.TokenRevocationRepository, refreshRepo repository.RefreshTokenRepository, userId string, exceptSessionId string) error {
+	// Tokens record when they were issued to the millisecond
+	if err := revocations.RevokeUserTokens(ctx, userId, time.Now().Truncate(time.Millisecond), exceptSessionId); err != nil {
+		return err
+	}
+	return refreshRepo.RevokeUserRefreshTokens(ctx, userId, exceptSessionId)
+}
+
+// ForgotPassword emails a password reset link if the address belongs to a
+// user. It reports success either way so the endpoint cannot be used to find
+// out which addresses are registered.
+func (s *DefaultAuthService) ForgotPassword(ctx context.Context, req *dto.ForgotPasswordRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.ForgotPassword")
+	defer span.End()
+	u, err := s.repo.GetUserByEmail(ctx, normalizeEmail(req.Email))
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return nil
 	}
+	// Only the newest link should work
+	if err := s.userTokens.InvalidateUserTokens(ctx, u.UserId, models.TokenPurposePasswordReset); err != nil {
+		return &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Could not start password reset",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	token, err := issueUserToken(ctx, s.userTokens, u.UserId, models.TokenPurposePasswordReset, PasswordResetTokenDuration)
+	if err != nil {
+		return &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Could not start password reset",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s/reset-password?token=%s\n\nIf you did not ask for this, you can ignore this email.",
+			u.FirstName, PasswordResetTokenDuration, s.appURL, token),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send password reset email", "error", err)
+	}
+	return nil
+}
 
-	err = s.orgService.CreateOrganizationByFirstName(req.FirstName, resp.User.UserId)
+// ResetPassword sets a new password using a token from ForgotPassword, signs
+// the user out everywhere and revokes their personal access tokens, since
+// whoever had the old password could have created them.
+func (s *DefaultAuthService) ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.ResetPassword")
+	defer span.End()
+	internal := &errors.ApiError{
+		Status:     errors.InternalServerError,
+		Message:    "Password reset unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+	}
+	token, apiErr := consumeUserToken(ctx, s.userTokens, req.Token, models.TokenPurposePasswordReset)
+	if apiErr != nil {
+		return apiErr
+	}
+	hash, err := hashPassword(ctx, req.Password)
 	if err != nil {
-		tx.Rollback()
-		return nil, &errors.ApiError{
-			Status:     "error",
-			Message:    errors.InternalServerError,
+		return internal
+	}
+	if err := s.repo.UpdatePassword(ctx, token.UserId, hash); err != nil {
+		return internal
+	}
+	if err := s.revokeSessions(ctx, token.UserId, ""); err != nil {
+		return internal
+	}
+	if err := s.personalTokens.DeleteUserPersonalAccessTokens(ctx, token.UserId); err != nil {
+		return internal
+	}
+	// Proving control of the mailbox also lifts a lockout
+	if u, err := s.repo.GetUserById(ctx, token.UserId); err == nil {
+		if apiErr := s.limiter.Unlock(ctx, u.Email); apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// ChangePassword replaces the user's password after checking the old one.
+// Every session other than sessionId is ended, so the one making the request
+// stays signed in.
+func (s *DefaultAuthService) ChangePassword(ctx context.Context, userId string, sessionId string, req *dto.ChangePasswordRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
+	internal := &errors.ApiError{
+		Status:     errors.InternalServerError,
+		Message:    "Password change unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+	}
+	u, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return &errors.ApiError{
+			Status:     errors.UnAuthorized,
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+		}
+	}
+	if !verifyPassword(ctx, req.CurrentPassword, u.Password) {
+		return &errors.ApiError{
+			Status:     errors.ValidationError,
+			Message:    "Current password is incorrect",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	hash, err := hashPassword(ctx, req.NewPassword)
+	if err != nil {
+		return internal
+	}
+	if err := s.repo.UpdatePassword(ctx, userId, hash); err != nil {
+		return internal
+	}
+	if err := s.revokeSessions(ctx, userId, sessionId); err != nil {
+		return internal
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Your password was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nThe password of your account was just changed and your other sessions were signed out. If this was not you, reset your password at %s/forgot-password.", u.FirstName, s.appURL),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send password change notice", "userId", userId, "error", err)
+	}
+	return nil
+}
+
+func (s *DefaultAuthService) sendVerificationEmail(ctx context.Context, userId string, email string, firstName string) error {
+	if err := s.userTokens.InvalidateUserTokens(ctx, userId, models.TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	token, err := issueUserToken(ctx, s.userTokens, userId, models.TokenPurposeEmailVerification, EmailVerificationTokenDuration)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address using the link below. It expires in %s.\n\n%s/verify-email?token=%s",
+			firstName, EmailVerificationTokenDuration, s.appURL, token),
+	})
+}
+
+// VerifyEmail marks the user's address as verified using the token emailed
+// on registration.
+func (s *DefaultAuthService) VerifyEmail(ctx context.Context, req *dto.VerifyEmailRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.VerifyEmail")
+	defer span.End()
+	token, apiErr := consumeUserToken(ctx, s.userTokens, req.Token, models.TokenPurposeEmailVerification)
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := s.repo.MarkEmailVerified(ctx, token.UserId); err != nil {
+		return &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Email verification unsuccessful",
 			StatusCode: http.StatusInternalServerError,
 		}
 	}
+	return nil
+}
 
-	tx.Commit()
-	return resp, nil
+// ResendVerification sends a fresh verification link, invalidating older
+// ones. Like ForgotPassword it does not reveal whether the address exists.
+func (s *DefaultAuthService) ResendVerification(ctx context.Context, req *dto.ResendVerificationRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "AuthService.ResendVerification")
+	defer span.End()
+	u, err := s.repo.GetUserByEmail(ctx, normalizeEmail(req.Email))
+	if err != nil || u.EmailVerifiedAt != nil {
+		return nil
+	}
+	if err := s.sendVerificationEmail(ctx, u.UserId, u.Email, u.FirstName); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send verification email", "error", err)
+	}
+	return nil
 }
 
-func NewAuthService(repo repository.UserRepository, orgService OrganizationService) AuthService {
-	return &DefaultAuthService{repo: repo, orgService: orgService}
+func NewAuthService(repo repository.UserRepository, orgService OrganizationService, refreshRepo repository.RefreshTokenRepository, revocations repository.TokenRevocationRepository, userTokens repository.UserTokenRepository, personalTokens repository.PersonalAccessTokenRepository, mailer mail.Sender, mfaService MfaService, invitations InvitationService, loginEvents repository.LoginEventRepository, limiter LoginLimiter, tx repository.TxManager, keys *keyring.Keyring, appURL string, logger *slog.Logger) AuthService {
+	return &DefaultAuthService{
+		repo:           repo,
+		orgService:     orgService,
+		refreshRepo:    refreshRepo,
+		revocations:    revocations,
+		userTokens:     userTokens,
+		personalTokens: personalTokens,
+		mailer:         mailer,
+		mfaService:     mfaService,
+		invitations:    invitations,
+		loginEvents:    loginEvents,
+		limiter:        limiter,
+		tx:             tx,
+		keys:           keys,
+		appURL:         appURL,
+		logger:         logger,
+	}
 }
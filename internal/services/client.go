@@ -0,0 +1,22 @@
+package services
+
+import "context"
+
+// ClientInfo describes where a request came from. It is recorded in the
+// login history.
+type ClientInfo struct {
+	IpAddress string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a copy of ctx carrying info.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+func clientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/mail"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EmailChangeTokenDuration = 24 * time.Hour
+	// EmailChangeCancelPeriod is how long the old address can undo a change,
+	// including one that has already been confirmed
+	EmailChangeCancelPeriod = 7 * 24 * time.Hour
+)
+
+type EmailChangeService interface {
+	RequestEmailChange(ctx context.Context, userId string, req *dto.ChangeEmailRequest) (*dto.EmailChangeResponse, *errors.ApiError)
+	ConfirmEmailChange(ctx context.Context, req *dto.EmailChangeTokenRequest) *errors.ApiError
+	CancelEmailChange(ctx context.Context, req *dto.EmailChangeTokenRequest) *errors.ApiError
+}
+
+type DefaultEmailChangeService struct {
+	repo           repository.EmailChangeRepository
+	userRepo       repository.UserRepository
+	refreshRepo    repository.RefreshTokenRepository
+	revocations    repository.TokenRevocationRepository
+	personalTokens repository.PersonalAccessTokenRepository
+	mailer         mail.Sender
+	appURL         string
+	logger         *slog.Logger
+}
+
+func emailInUse() *errors.ApiError {
+	return &errors.ApiError{
+		Message:    "Email already in use",
+		StatusCode: http.StatusConflict,
+		Status:     errors.ValidationError,
+	}
+}
+
+// RequestEmailChange sends a confirmation link to the new address and a
+// notice with a cancel link to the current one. Nothing changes until the
+// link is confirmed.
+func (s *DefaultEmailChangeService) RequestEmailChange(ctx context.Context, userId string, req *dto.ChangeEmailRequest) (*dto.EmailChangeResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "EmailChangeService.RequestEmailChange")
+	defer span.End()
+	internal := &errors.ApiError{
+		Message:    "Email change unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	u, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	if !verifyPassword(ctx, req.Password, u.Password) {
+		return nil, &errors.ApiError{
+			Message:    "Password is incorrect",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	// Addresses are unique regardless of case, so store them lower-cased
+	newEmail := normalizeEmail(req.NewEmail)
+	if strings.EqualFold(newEmail, u.Email) {
+		return nil, &errors.ApiError{
+			Message:    "New email must differ from the current one",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	if existing, _ := s.userRepo.GetUserByEmail(ctx, newEmail); existing != nil {
+		return nil, emailInUse()
+	}
+	raw, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, internal
+	}
+	cancelRaw, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, internal
+	}
+	change := &models.EmailChange{
+		UserId:          u.UserId,
+		OldEmail:        u.Email,
+		NewEmail:        newEmail,
+		TokenHash:       helpers.HashToken(raw),
+		CancelTokenHash: helpers.HashToken(cancelRaw),
+		ExpiresAt:       time.Now().Add(EmailChangeTokenDuration),
+	}
+	if err := s.repo.CreateEmailChange(ctx, change); err != nil {
+		return nil, internal
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that you want to use this address for your account. The link expires in %s.\n\n%s/confirm-email-change?token=%s",
+			u.FirstName, EmailChangeTokenDuration, s.appURL, raw),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send email change confirmation", "userId", userId, "error", err)
+		return nil, internal
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nA request was made to change the email address of your account to %s. If this was not you, cancel it within %s using the link below; this also signs out every session.\n\n%s/cancel-email-change?token=%s",
+			u.FirstName, newEmail, EmailChangeCancelPeriod, s.appURL, cancelRaw),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send email change notice", "userId", userId, "error", err)
+		return nil, internal
+	}
+	return &dto.EmailChangeResponse{NewEmail: newEmail, ExpiresAt: change.ExpiresAt}, nil
+}
+
+// ConfirmEmailChange applies the change. If another account claimed the
+// address in the meantime the request fails and the old address is kept.
+func (s *DefaultEmailChangeService) ConfirmEmailChange(ctx context.Context, req *dto.EmailChangeTokenRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "EmailChangeService.ConfirmEmailChange")
+	defer span.End()
+	invalid := &errors.ApiError{
+		Message:    "Invalid or expired token",
+		StatusCode: http.StatusBadRequest,
+		Status:     errors.ValidationError,
+	}
+	change, err := s.repo.GetEmailChangeByHash(ctx, helpers.HashToken(req.Token))
+	if err != nil {
+		return invalid
+	}
+	if change.ConfirmedAt != nil || change.CancelledAt != nil || time.Now().After(change.ExpiresAt) {
+		return invalid
+	}
+	applied, err := s.repo.ApplyEmailChange(ctx, change)
+	if goerrors.Is(err, gorm.ErrDuplicatedKey) {
+		return emailInUse()
+	}
+	if err != nil {
+		return &errors.ApiError{
+			Message:    "Email change unsuccessful",
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !applied {
+		return invalid
+	}
+	return nil
+}
+
+// CancelEmailChange is used from the old address. A pending change is
+// dropped; an applied one is reverted, every session is signed out and the
+// personal access tokens are revoked, since whoever made it may control the
+// account.
+func (s *DefaultEmailChangeService) CancelEmailChange(ctx context.Context, req *dto.EmailChangeTokenRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "EmailChangeService.CancelEmailChange")
+	defer span.End()
+	invalid := &errors.ApiError{
+		Message:    "Invalid or expired token",
+		StatusCode: http.StatusBadRequest,
+		Status:     errors.ValidationError,
+	}
+	internal := &errors.ApiError{
+		Message:    "Email change cancellation unsuccessful",
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	change, err := s.repo.GetEmailChangeByCancelHash(ctx, helpers.HashToken(req.Token))
+	if err != nil {
+		return invalid
+	}
+	if change.CancelledAt != nil || time.Now().After(change.CreatedAt.Add(EmailChangeCancelPeriod)) {
+		return invalid
+	}
+	cancelled, reverted, err := s.repo.CancelEmailChange(ctx, change)
+	if goerrors.Is(err, gorm.ErrDuplicatedKey) {
+		return emailInUse()
+	}
+	if err != nil {
+		return internal
+	}
+	if !cancelled {
+		return invalid
+	}
+	if reverted {
+		if err := revokeUserSessions(ctx, s.revocations, s.refreshRepo, change.UserId, ""); err != nil {
+			return internal
+		}
+		if err := s.personalTokens.DeleteUserPersonalAccessTokens(ctx, change.UserId); err != nil {
+			return internal
+		}
+	}
+	return nil
+}
+
+func NewEmailChangeService(repo repository.EmailChangeRepository, userRepo repository.UserRepository, refreshRepo repository.RefreshTokenRepository, revocations repository.TokenRevocationRepository, personalTokens repository.PersonalAccessTokenRepository, mailer mail.Sender, appURL string, logger *slog.Logger) *DefaultEmailChangeService {
+	return &DefaultEmailChangeService{repo: repo, userRepo: userRepo, refreshRepo: refreshRepo, revocations: revocations, personalTokens: personalTokens, mailer: mailer, appURL: appURL, logger: logger}
+}
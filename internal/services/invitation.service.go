@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/mail"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const InvitationDuration = 7 * 24 * time.Hour
+
+type InvitationService interface {
+	CreateInvitation(ctx context.Context, actorId string, orgId string, req *dto.CreateInvitationRequest) (*dto.InvitationResponse, *errors.ApiError)
+	GetPendingInvitations(ctx context.Context, actorId string, orgId string) ([]*dto.InvitationResponse, *errors.ApiError)
+	RevokeInvitation(ctx context.Context, actorId string, orgId string, invitationId string) *errors.ApiError
+	CheckInvitation(ctx context.Context, email string, token string) (*models.Invitation, *errors.ApiError)
+	AcceptInvitation(ctx context.Context, user *models.User, token string) (*dto.OrganizationMemberResponse, *errors.ApiError)
+}
+
+type DefaultInvitationService struct {
+	repo     repository.InvitationRepository
+	orgRepo  repository.OrganizationRepository
+	userRepo repository.UserRepository
+	mailer   mail.Sender
+	appURL   string
+	logger   *slog.Logger
+}
+
+func toInvitationResponse(invitation *models.Invitation) *dto.InvitationResponse {
+	return &dto.InvitationResponse{
+		Id:        invitation.Id,
+		OrgId:     invitation.OrgId,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		InvitedBy: invitation.InvitedBy,
+		ExpiresAt: invitation.ExpiresAt,
+		CreatedAt: invitation.CreatedAt,
+	}
+}
+
+// CreateInvitation emails an invitation link to the address. Inviting the
+// same address again replaces the pending invitation.
+func (s *DefaultInvitationService) CreateInvitation(ctx context.Context, actorId string, orgId string, req *dto.CreateInvitationRequest) (*dto.InvitationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "InvitationService.CreateInvitation")
+	defer span.End()
+	req.Email = normalizeEmail(req.Email)
+	org, apiErr := requireOrgRole(ctx, s.orgRepo, actorId, orgId, models.RoleOwner, models.RoleAdmin)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	internal := &errors.ApiError{
+		Message:    errors.InternalServerError,
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	if u, _ := s.userRepo.GetUserByEmail(ctx, req.Email); u != nil {
+		member, err := s.orgRepo.IsUserInOrganization(ctx, u.UserId, orgId)
+		if err != nil {
+			return nil, internal
+		}
+		if member {
+			return nil, &errors.ApiError{
+				Message:    "User already belongs to this organization",
+				StatusCode: http.StatusConflict,
+				Status:     errors.ValidationError,
+			}
+		}
+	}
+	role := req.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+	raw, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, internal
+	}
+	if err := s.repo.DeletePendingInvitations(ctx, orgId, req.Email); err != nil {
+		return nil, internal
+	}
+	invitation := &models.Invitation{
+		OrgId:     orgId,
+		Email:     req.Email,
+		Role:      role,
+		InvitedBy: actorId,
+		TokenHash: helpers.HashToken(raw),
+		ExpiresAt: time.Now().Add(InvitationDuration),
+	}
+	if err := s.repo.CreateInvitation(ctx, invitation); err != nil {
+		return nil, internal
+	}
+	err = s.mailer.Send(mail.Message{
+		To:      req.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", org.Name),
+		Body: fmt.Sprintf("Hi,\n\nYou have been invited to join %s as %s. Use the link below to sign up or log in and accept. It expires in %s.\n\n%s/invitations/accept?token=%s&email=%s\n\nIf you were not expecting this, you can ignore this email.",
+			org.Name, role, InvitationDuration, s.appURL, raw, url.QueryEscape(req.Email)),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to send invitation email", "orgId", orgId, "error", err)
+	}
+	return toInvitationResponse(invitation), nil
+}
+
+func (s *DefaultInvitationService) GetPendingInvitations(ctx context.Context, actorId string, orgId string) ([]*dto.InvitationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "InvitationService.GetPendingInvitations")
+	defer span.End()
+	if _, apiErr := requireOrgRole(ctx, s.orgRepo, actorId, orgId, models.RoleOwner, models.RoleAdmin); apiErr != nil {
+		return nil, apiErr
+	}
+	invitations, err := s.repo.GetPendingInvitations(ctx, orgId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	response := []*dto.InvitationResponse{}
+	for _, invitation := range invitations {
+		response = append(response, toInvitationResponse(invitation))
+	}
+	return response, nil
+}
+
+func (s *DefaultInvitationService) RevokeInvitation(ctx context.Context, actorId string, orgId string, invitationId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "InvitationService.RevokeInvitation")
+	defer span.End()
+	if _, apiErr := requireOrgRole(ctx, s.orgRepo, actorId, orgId, models.RoleOwner, models.RoleAdmin); apiErr != nil {
+		return apiErr
+	}
+	deleted, err := s.repo.DeleteInvitation(ctx, orgId, invitationId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !deleted {
+		return &errors.ApiError{
+			Message:    "Invitation not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	return nil
+}
+
+// CheckInvitation validates a token without accepting it. Invitations are
+// bound to the address they were sent to.
+func (s *DefaultInvitationService) CheckInvitation(ctx context.Context, email string, token string) (*models.Invitation, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "InvitationService.CheckInvitation")
+	defer span.End()
+	invitation, err := s.repo.GetInvitationByHash(ctx, helpers.HashToken(token))
+	if err != nil || invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) ||
+		!strings.EqualFold(invitation.Email, email) {
+		return nil, &errors.ApiError{
+			Message:    "Invalid or expired invitation",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation adds the user to the inviting organization with the
+// invited role.
+func (s *DefaultInvitationService) AcceptInvitation(ctx context.Context, user *models.User, token string) (*dto.OrganizationMemberResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "InvitationService.AcceptInvitation")
+	defer span.End()
+	invitation, apiErr := s.CheckInvitation(ctx, user.Email, token)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	accepted, err := s.repo.AcceptInvitation(ctx, invitation, user.UserId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !accepted {
+		return nil, &errors.ApiError{
+			Message:    "Invalid or expired invitation",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	return &dto.OrganizationMemberResponse{OrgId: invitation.OrgId, UserId: user.UserId, Role: invitation.Role}, nil
+}
+
+func NewInvitationService(repo repository.InvitationRepository, orgRepo repository.OrganizationRepository, userRepo repository.UserRepository, mailer mail.Sender, appURL string, logger *slog.Logger) *DefaultInvitationService {
+	return &DefaultInvitationService{repo: repo, orgRepo: orgRepo, userRepo: userRepo, mailer: mailer, appURL: appURL, logger: logger}
+}
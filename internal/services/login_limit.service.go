@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"h-two/internal/errors"
+	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+// staleThrottleAge is how long a quiet client or account keeps its
+// counters. Lockouts only lengthen for failures within this period.
+const staleThrottleAge = 24 * time.Hour
+
+// LoginLimits configures the LoginLimiter.
+type LoginLimits struct {
+	// IpAttempts login attempts are allowed from one address per IpWindow
+	IpAttempts int
+	IpWindow   time.Duration
+	// MaxFailures failed logins within FailureWindow lock the account for
+	// Lockout, which doubles with every further lockout up to MaxLockout
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+}
+
+// LoginLimiter slows down password guessing by limiting login attempts per
+// client address and locking accounts out after repeated failures. Accounts
+// are tracked by email whether or not they exist, so the limits do not
+// reveal which addresses are registered. Errors from the store are logged
+// and let the login through rather than locking everyone out.
+type LoginLimiter interface {
+	// CheckIp counts a login attempt from ip and rejects it once the address
+	// is over its limit.
+	CheckIp(ctx context.Context, ip string) *errors.ApiError
+	// CheckAccount rejects a login while the account is locked out.
+	CheckAccount(ctx context.Context, email string) *errors.ApiError
+	// RecordFailure counts a failed login, locking the account out once it
+	// reaches the limit.
+	RecordFailure(ctx context.Context, email string)
+	// RecordSuccess clears the failures of an account that signed in.
+	RecordSuccess(ctx context.Context, email string)
+	// Unlock ends a lockout and clears the account's failures.
+	Unlock(ctx context.Context, email string) *errors.ApiError
+	// LockedUntil returns when the account's lockout ends, or nil when it is
+	// not locked out.
+	LockedUntil(ctx context.Context, email string) (*time.Time, *errors.ApiError)
+	PurgeStaleThrottles(ctx context.Context) (int64, error)
+}
+
+type DefaultLoginLimiter struct {
+	repo   repository.LoginThrottleRepository
+	limits LoginLimits
+	logger *slog.Logger
+}
+
+func NewLoginLimiter(repo repository.LoginThrottleRepository, limits LoginLimits, logger *slog.Logger) *DefaultLoginLimiter {
+	return &DefaultLoginLimiter{repo: repo, limits: limits, logger: logger}
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+// tooManyRequests is the rejection for a limit that resets at resetAt.
+func tooManyRequests(message string, resetAt time.Time) *errors.ApiError {
+	return &errors.ApiError{
+		Status:     errors.TooManyRequests,
+		Message:    message,
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: int(math.Ceil(time.Until(resetAt).Seconds())),
+	}
+}
+
+func (s *DefaultLoginLimiter) CheckIp(ctx context.Context, ip string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.CheckIp")
+	defer span.End()
+	entry, err := s.repo.Hit(ctx, ipKey(ip), s.limits.IpWindow)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to count login attempt", "error", err)
+		return nil
+	}
+	if entry.Attempts > s.limits.IpAttempts {
+		return tooManyRequests("Too many login attempts, try again later", entry.WindowEnds)
+	}
+	return nil
+}
+
+func (s *DefaultLoginLimiter) CheckAccount(ctx context.Context, email string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.CheckAccount")
+	defer span.End()
+	entry, err := s.repo.GetThrottle(ctx, accountKey(email))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to check account lockout", "error", err)
+		return nil
+	}
+	if entry != nil && entry.LockedUntil != nil && entry.LockedUntil.After(time.Now()) {
+		return tooManyRequests("Too many failed logins, the account is temporarily locked", *entry.LockedUntil)
+	}
+	return nil
+}
+
+func (s *DefaultLoginLimiter) RecordFailure(ctx context.Context, email string) {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.RecordFailure")
+	defer span.End()
+	key := accountKey(email)
+	entry, err := s.repo.Hit(ctx, key, s.limits.FailureWindow)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to count login failure", "error", err)
+		return
+	}
+	if entry.Attempts < s.limits.MaxFailures {
+		return
+	}
+	lockouts := entry.Lockouts + 1
+	until := time.Now().Add(s.lockoutDuration(lockouts))
+	if err := s.repo.Lock(ctx, key, until, lockouts); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to lock account out", "error", err)
+		return
+	}
+	s.logger.WarnContext(ctx, "Account locked out after repeated login failures", "email", email, "lockouts", lockouts, "lockedUntil", until)
+}
+
+// lockoutDuration doubles the lockout for every previous one, up to the
+// maximum.
+func (s *DefaultLoginLimiter) lockoutDuration(lockouts int) time.Duration {
+	duration := s.limits.Lockout
+	for i := 1; i < lockouts && duration < s.limits.MaxLockout; i++ {
+		duration *= 2
+	}
+	if duration > s.limits.MaxLockout {
+		return s.limits.MaxLockout
+	}
+	return duration
+}
+
+func (s *DefaultLoginLimiter) RecordSuccess(ctx context.Context, email string) {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.RecordSuccess")
+	defer span.End()
+	if err := s.repo.ResetThrottle(ctx, accountKey(email)); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to clear login failures", "error", err)
+	}
+}
+
+func (s *DefaultLoginLimiter) Unlock(ctx context.Context, email string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.Unlock")
+	defer span.End()
+	if err := s.repo.ResetThrottle(ctx, accountKey(email)); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to unlock account", "error", err)
+		return &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Failed to unlock account",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	return nil
+}
+
+func (s *DefaultLoginLimiter) LockedUntil(ctx context.Context, email string) (*time.Time, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "LoginLimiter.LockedUntil")
+	defer span.End()
+	entry, err := s.repo.GetThrottle(ctx, accountKey(email))
+	if err != nil {
+		return nil, &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    "Failed to check account lockout",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	if entry == nil || entry.LockedUntil == nil || !entry.LockedUntil.After(time.Now()) {
+		return nil, nil
+	}
+	return entry.LockedUntil, nil
+}
+
+// PurgeStaleThrottles forgets clients and accounts that have been quiet for
+// a day, which also resets their lockout progression.
+func (s *DefaultLoginLimiter) PurgeStaleThrottles(ctx context.Context) (int64, error) {
+	return s.repo.DeleteStaleThrottles(ctx, time.Now().Add(-staleThrottleAge))
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/totp"
+	"h-two/internal/tracing"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// recoveryCodeAlphabet leaves out characters that are easy to misread.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+type MfaService interface {
+	EnrollTotp(ctx context.Context, userId string) (*dto.EnrollTotpResponse, *errors.ApiError)
+	ConfirmTotp(ctx context.Context, userId string, req *dto.ConfirmTotpRequest) (*dto.RecoveryCodesResponse, *errors.ApiError)
+	DisableTotp(ctx context.Context, userId string, req *dto.MfaCodeRequest) *errors.ApiError
+	RegenerateRecoveryCodes(ctx context.Context, userId string, req *dto.MfaCodeRequest) (*dto.RecoveryCodesResponse, *errors.ApiError)
+	VerifySecondFactor(ctx context.Context, user *models.User, code string, recoveryCode string) *errors.ApiError
+}
+
+type DefaultMfaService struct {
+	userRepo   repository.UserRepository
+	mfaRepo    repository.MfaRepository
+	orgService OrganizationService
+	// issuer names the account in authenticator apps
+	issuer string
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	// rand.Int draws each character uniformly; reducing a random byte modulo
+	// the alphabet size would favour its first characters
+	size := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := range codes {
+		var sb strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, size)
+			if err != nil {
+				return nil, nil, err
+			}
+			sb.WriteByte(recoveryCodeAlphabet[n.Int64()])
+		}
+		codes[i] = sb.String()
+		hashes[i] = helpers.HashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func (s *DefaultMfaService) getUser(ctx context.Context, userId string) (*models.User, *errors.ApiError) {
+	user, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	return user, nil
+}
+
+func (s *DefaultMfaService) issueRecoveryCodes(ctx context.Context, userId string) (*dto.RecoveryCodesResponse, *errors.ApiError) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = s.mfaRepo.ReplaceRecoveryCodes(ctx, userId, hashes)
+	}
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// EnrollTotp starts TOTP enrollment by generating a secret. MFA is not
+// enforced until the user confirms a code from their authenticator app.
+func (s *DefaultMfaService) EnrollTotp(ctx context.Context, userId string) (*dto.EnrollTotpResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "MfaService.EnrollTotp")
+	defer span.End()
+	user, apiErr := s.getUser(ctx, userId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if user.MfaEnabledAt != nil {
+		return nil, &errors.ApiError{
+			Message:    "Two-factor authentication is already enabled",
+			StatusCode: http.StatusConflict,
+			Status:     errors.ValidationError,
+		}
+	}
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		err = s.mfaRepo.SetTotpSecret(ctx, user.UserId, secret)
+	}
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return &dto.EnrollTotpResponse{
+		Secret:          secret,
+		ProvisioningUri: totp.ProvisioningURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTotp enables MFA once the user submits a valid code for the pending
+// secret, and returns a fresh set of recovery codes. They are only shown here.
+func (s *DefaultMfaService) ConfirmTotp(ctx context.Context, userId string, req *dto.ConfirmTotpRequest) (*dto.RecoveryCodesResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "MfaService.ConfirmTotp")
+	defer span.End()
+	user, apiErr := s.getUser(ctx, userId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if user.TotpSecret == "" || user.MfaEnabledAt != nil {
+		return nil, &errors.ApiError{
+			Message:    "No pending two-factor enrollment",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	if apiErr := s.verifyTotp(ctx, user, req.Code); apiErr != nil {
+		return nil, apiErr
+	}
+	if err := s.mfaRepo.EnableTotp(ctx, user.UserId); err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return s.issueRecoveryCodes(ctx, user.UserId)
+}
+
+// DisableTotp turns MFA off after checking a second factor. Members of an
+// organization that requires MFA cannot turn it off.
+func (s *DefaultMfaService) DisableTotp(ctx context.Context, userId string, req *dto.MfaCodeRequest) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "MfaService.DisableTotp")
+	defer span.End()
+	user, apiErr := s.getUser(ctx, userId)
+	if apiErr != nil {
+		return apiErr
+	}
+	if apiErr := s.VerifySecondFactor(ctx, user, req.Code, req.RecoveryCode); apiErr != nil {
+		return apiErr
+	}
+	required, apiErr := s.orgService.IsMfaRequiredForUser(ctx, user.UserId)
+	if apiErr != nil {
+		return apiErr
+	}
+	if required {
+		return &errors.ApiError{
+			Message:    "An organization you belong to requires two-factor authentication",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	if err := s.mfaRepo.DisableTotp(ctx, user.UserId); err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a second
+// factor.
+func (s *DefaultMfaService) RegenerateRecoveryCodes(ctx context.Context, userId string, req *dto.MfaCodeRequest) (*dto.RecoveryCodesResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "MfaService.RegenerateRecoveryCodes")
+	defer span.End()
+	user, apiErr := s.getUser(ctx, userId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.VerifySecondFactor(ctx, user, req.Code, req.RecoveryCode); apiErr != nil {
+		return nil, apiErr
+	}
+	return s.issueRecoveryCodes(ctx, user.UserId)
+}
+
+func (s *DefaultMfaService) verifyTotp(ctx context.Context, user *models.User, code string) *errors.ApiError {
+	invalid := &errors.ApiError{
+		Message:    "Invalid two-factor code",
+		StatusCode: http.StatusUnauthorized,
+		Status:     errors.UnAuthorized,
+	}
+	step, ok := totp.Validate(user.TotpSecret, code, time.Now())
+	if !ok {
+		return invalid
+	}
+	// Each code may only be used once
+	advanced, err := s.mfaRepo.AdvanceTotpStep(ctx, user.UserId, step)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !advanced {
+		return invalid
+	}
+	return nil
+}
+
+// VerifySecondFactor checks either a TOTP code or an unused recovery code for
+// a user that has MFA enabled.
+func (s *DefaultMfaService) VerifySecondFactor(ctx context.Context, user *models.User, code string, recoveryCode string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "MfaService.VerifySecondFactor")
+	defer span.End()
+	if user.MfaEnabledAt == nil {
+		return &errors.ApiError{
+			Message:    "Two-factor authentication is not enabled",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	if code != "" {
+		return s.verifyTotp(ctx, user, code)
+	}
+	if recoveryCode != "" {
+		consumed, err := s.mfaRepo.ConsumeRecoveryCode(ctx, user.UserId, helpers.HashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return &errors.ApiError{
+				Message:    errors.InternalServerError,
+				StatusCode: http.StatusInternalServerError,
+				Status:     errors.InternalServerError,
+			}
+		}
+		if consumed {
+			return nil
+		}
+	}
+	return &errors.ApiError{
+		Message:    "Invalid two-factor code",
+		StatusCode: http.StatusUnauthorized,
+		Status:     errors.UnAuthorized,
+	}
+}
+
+func NewMfaService(userRepo repository.UserRepository, mfaRepo repository.MfaRepository, orgService OrganizationService, issuer string) *DefaultMfaService {
+	return &DefaultMfaService{userRepo: userRepo, mfaRepo: mfaRepo, orgService: orgService, issuer: issuer}
+}
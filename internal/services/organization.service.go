@@ -1,29 +1,84 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"h-two/internal/dto"
 	"h-two/internal/errors"
 	"h-two/internal/models"
 	"h-two/internal/repository"
+	"h-two/internal/tracing"
 	"net/http"
+	"time"
 )
 
+// OrganizationRestorePeriod is how long an owner can restore a deleted
+// organization before it is purged.
+const OrganizationRestorePeriod = 30 * 24 * time.Hour
+
 type OrganizationService interface {
-	CreateOrganizationByFirstName(name string, userId string) *errors.ApiError
-	GetUserOrganizations(userId string) ([]*dto.GetOrganizationResponse, *errors.ApiError)
-	GetOrganizationById(userId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError)
-	CreateOrganization(userId string, req *dto.CreateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError)
-	AddUserToOrganization(orgId string, userId string) *errors.ApiError
-	IsUserInOrganization(userId string, orgId string) (bool, *errors.ApiError)
+	CreateOrganizationByFirstName(ctx context.Context, name string, userId string) *errors.ApiError
+	GetUserOrganizations(ctx context.Context, userId string, query *dto.ListOrganizationsQuery) ([]*dto.GetOrganizationResponse, *dto.PageMeta, *errors.ApiError)
+	GetOrganizationById(ctx context.Context, userId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError)
+	CreateOrganization(ctx context.Context, userId string, req *dto.CreateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError)
+	AddUserToOrganization(ctx context.Context, actorId string, orgId string, userId string, role string) (*dto.OrganizationMemberResponse, *errors.ApiError)
+	UpdateMemberRole(ctx context.Context, actorId string, orgId string, userId string, role string) (*dto.OrganizationMemberResponse, *errors.ApiError)
+	RemoveMember(ctx context.Context, actorId string, orgId string, userId string) *errors.ApiError
+	LeaveOrganization(ctx context.Context, userId string, orgId string) *errors.ApiError
+	TransferOwnership(ctx context.Context, actorId string, orgId string, userId string) (*dto.GetOrganizationResponse, *errors.ApiError)
+	UpdateOrganization(ctx context.Context, actorId string, orgId string, req *dto.UpdateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError)
+	DeleteOrganization(ctx context.Context, actorId string, orgId string) (*dto.DeleteOrganizationResponse, *errors.ApiError)
+	RestoreOrganization(ctx context.Context, actorId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError)
+	PurgeDeletedOrganizations(ctx context.Context) (int64, error)
+	ListMembers(ctx context.Context, actorId string, orgId string, query *dto.ListMembersQuery) ([]*dto.MemberResponse, *dto.PageMeta, *errors.ApiError)
+	IsUserInOrganization(ctx context.Context, userId string, orgId string) (bool, *errors.ApiError)
+	IsMfaRequiredForUser(ctx context.Context, userId string) (bool, *errors.ApiError)
+	CheckMfaPolicy(ctx context.Context, userId string, orgId string, mfaAuthenticated bool) *errors.ApiError
+	SetMfaPolicy(ctx context.Context, userId string, orgId string, requireMfa bool) (*dto.GetOrganizationResponse, *errors.ApiError)
 }
 
 type DefaultOrganizationService struct {
 	repo repository.OrganizationRepository
 }
 
-func (s *DefaultOrganizationService) IsUserInOrganization(userId string, orgId string) (bool, *errors.ApiError) {
-	inOrg, err := s.repo.IsUserInOrganization(userId, orgId)
+func toOrganizationResponse(org *models.Organization) *dto.GetOrganizationResponse {
+	return &dto.GetOrganizationResponse{
+		OrgId:       org.OrgId,
+		Name:        org.Name,
+		Description: org.Description,
+		RequireMfa:  org.RequireMfa,
+		Role:        org.Role,
+		JoinedAt:    org.JoinedAt,
+	}
+}
+
+// requireOrgRole loads the organization as seen by userId and checks that
+// their membership has one of the given roles.
+func requireOrgRole(ctx context.Context, repo repository.OrganizationRepository, userId string, orgId string, roles ...string) (*models.Organization, *errors.ApiError) {
+	org, err := repo.GetOrganizationById(ctx, userId, orgId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Organization not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	for _, role := range roles {
+		if org.Role == role {
+			return org, nil
+		}
+	}
+	return nil, &errors.ApiError{
+		Message:    "You do not have permission to manage this organization",
+		StatusCode: http.StatusForbidden,
+		Status:     "Forbidden",
+	}
+}
+
+func (s *DefaultOrganizationService) IsUserInOrganization(ctx context.Context, userId string, orgId string) (bool, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.IsUserInOrganization")
+	defer span.End()
+	inOrg, err := s.repo.IsUserInOrganization(ctx, userId, orgId)
 	if err != nil {
 		return false, &errors.ApiError{
 			Message:    "Client Error",
@@ -34,14 +89,16 @@ func (s *DefaultOrganizationService) IsUserInOrganization(userId string, orgId s
 	return inOrg, nil
 
 }
-func (s *DefaultOrganizationService) CreateOrganizationByFirstName(name string, userId string) *errors.ApiError {
+func (s *DefaultOrganizationService) CreateOrganizationByFirstName(ctx context.Context, name string, userId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "OrganizationService.CreateOrganizationByFirstName")
+	defer span.End()
 
 	org := &models.Organization{
 		Name:  fmt.Sprintf("%s's Organization", name),
 		Owner: userId,
 	}
 
-	err := s.repo.CreateOrganization(org)
+	err := s.repo.CreateOrganization(ctx, org)
 	if err != nil {
 		return &errors.ApiError{
 			Message:    "Client Error",
@@ -52,27 +109,34 @@ func (s *DefaultOrganizationService) CreateOrganizationByFirstName(name string,
 	return nil
 }
 
-func (s *DefaultOrganizationService) GetUserOrganizations(userId string) ([]*dto.GetOrganizationResponse, *errors.ApiError) {
-	orgs, err := s.repo.GetOrganizationsByUser(userId)
+// GetUserOrganizations returns one page of the organizations the user
+// belongs to.
+func (s *DefaultOrganizationService) GetUserOrganizations(ctx context.Context, userId string, query *dto.ListOrganizationsQuery) ([]*dto.GetOrganizationResponse, *dto.PageMeta, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.GetUserOrganizations")
+	defer span.End()
+	page, apiErr := newPage(query.Limit, query.Cursor, query.Sort, query.Order, repository.SortName)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	orgs, err := s.repo.GetOrganizationsByUser(ctx, userId, page)
 	if err != nil {
-		return nil, &errors.ApiError{
+		return nil, nil, &errors.ApiError{
 			Message:    "Failed to get organizations",
 			StatusCode: 400,
 			Status:     errors.InternalServerError,
 		}
 	}
+	orgs, meta := trimPage(orgs, page, repository.OrganizationCursor)
 	var response []*dto.GetOrganizationResponse
 	for _, org := range orgs {
-		response = append(response, &dto.GetOrganizationResponse{
-			OrgId:       org.OrgId,
-			Name:        org.Name,
-			Description: org.Description,
-		})
+		response = append(response, toOrganizationResponse(org))
 	}
-	return response, nil
+	return response, meta, nil
 }
-func (s *DefaultOrganizationService) GetOrganizationById(userId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError) {
-	org, err := s.repo.GetOrganizationById(userId, orgId)
+func (s *DefaultOrganizationService) GetOrganizationById(ctx context.Context, userId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.GetOrganizationById")
+	defer span.End()
+	org, err := s.repo.GetOrganizationById(ctx, userId, orgId)
 	if err != nil {
 		return nil, &errors.ApiError{
 			Message:    "Organization not found",
@@ -80,20 +144,18 @@ func (s *DefaultOrganizationService) GetOrganizationById(userId string, orgId st
 			Status:     "Not Found",
 		}
 	}
-	return &dto.GetOrganizationResponse{
-		OrgId:       org.OrgId,
-		Name:        org.Name,
-		Description: org.Description,
-	}, nil
+	return toOrganizationResponse(org), nil
 }
 
-func (s *DefaultOrganizationService) CreateOrganization(userId string, req *dto.CreateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError) {
+func (s *DefaultOrganizationService) CreateOrganization(ctx context.Context, userId string, req *dto.CreateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.CreateOrganization")
+	defer span.End()
 	org := &models.Organization{
 		Name:        req.Name,
 		Description: req.Description,
 		Owner:       userId,
 	}
-	err := s.repo.CreateOrganization(org)
+	err := s.repo.CreateOrganization(ctx, org)
 	if err != nil {
 		return nil, &errors.ApiError{
 			Message:    "Client error",
@@ -101,24 +163,372 @@ func (s *DefaultOrganizationService) CreateOrganization(userId string, req *dto.
 			Status:     errors.ValidationError,
 		}
 	}
-	return &dto.GetOrganizationResponse{
-		OrgId:       org.OrgId,
-		Name:        org.Name,
-		Description: org.Description,
-	}, nil
+	org.Role = models.RoleOwner
+	return toOrganizationResponse(org), nil
 }
-func (s *DefaultOrganizationService) AddUserToOrganization(userId string, orgId string) *errors.ApiError {
-	err := s.repo.AddUserToOrganization(orgId, userId)
+
+// AddUserToOrganization adds userId to the organization with the given role,
+// which defaults to member. Only owners and admins may add members.
+func (s *DefaultOrganizationService) AddUserToOrganization(ctx context.Context, actorId string, orgId string, userId string, role string) (*dto.OrganizationMemberResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.AddUserToOrganization")
+	defer span.End()
+	if _, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner, models.RoleAdmin); apiErr != nil {
+		return nil, apiErr
+	}
+	if role == "" {
+		role = models.RoleMember
+	}
+	err := s.repo.AddUserToOrganization(ctx, orgId, userId, role)
 	if err != nil {
-		return &errors.ApiError{
+		return nil, &errors.ApiError{
 			Message:    "Client error",
 			StatusCode: http.StatusBadRequest,
 			Status:     errors.ValidationError,
 		}
 	}
+	return &dto.OrganizationMemberResponse{OrgId: orgId, UserId: userId, Role: role}, nil
+}
+
+// UpdateMemberRole switches a member between admin and member. The owner's
+// role is fixed; ownership only moves through a transfer. Like RemoveMember,
+// admins cannot change another admin's role, so they cannot demote one to
+// remove them.
+func (s *DefaultOrganizationService) UpdateMemberRole(ctx context.Context, actorId string, orgId string, userId string, role string) (*dto.OrganizationMemberResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.UpdateMemberRole")
+	defer span.End()
+	actor, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner, models.RoleAdmin)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	target, err := s.repo.GetOrganizationById(ctx, userId, orgId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Member not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	if target.Role == models.RoleOwner {
+		return nil, &errors.ApiError{
+			Message:    "The owner's role cannot be changed",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	if actor.Role == models.RoleAdmin && target.Role == models.RoleAdmin {
+		return nil, &errors.ApiError{
+			Message:    "Only the owner can change an admin's role",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	if err := s.repo.UpdateMemberRole(ctx, orgId, userId, role); err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return &dto.OrganizationMemberResponse{OrgId: orgId, UserId: userId, Role: role}, nil
+}
+
+// RemoveMember removes someone else from the organization. Admins can only
+// remove plain members; the owner can only be removed by transferring
+// ownership first.
+func (s *DefaultOrganizationService) RemoveMember(ctx context.Context, actorId string, orgId string, userId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "OrganizationService.RemoveMember")
+	defer span.End()
+	actor, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner, models.RoleAdmin)
+	if apiErr != nil {
+		return apiErr
+	}
+	if actorId == userId {
+		return &errors.ApiError{
+			Message:    "Use leave to remove yourself from an organization",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	target, err := s.repo.GetOrganizationById(ctx, userId, orgId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    "Member not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	if target.Role == models.RoleOwner || (actor.Role == models.RoleAdmin && target.Role == models.RoleAdmin) {
+		return &errors.ApiError{
+			Message:    "You cannot remove this member",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	return s.removeMembership(ctx, orgId, userId)
+}
+
+// LeaveOrganization removes the user's own membership. The owner has to
+// transfer ownership before leaving.
+func (s *DefaultOrganizationService) LeaveOrganization(ctx context.Context, userId string, orgId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "OrganizationService.LeaveOrganization")
+	defer span.End()
+	org, err := s.repo.GetOrganizationById(ctx, userId, orgId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    "Organization not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	if org.Role == models.RoleOwner {
+		return &errors.ApiError{
+			Message:    "Transfer ownership before leaving the organization",
+			StatusCode: http.StatusConflict,
+			Status:     errors.ValidationError,
+		}
+	}
+	return s.removeMembership(ctx, orgId, userId)
+}
+
+func (s *DefaultOrganizationService) removeMembership(ctx context.Context, orgId string, userId string) *errors.ApiError {
+	removed, err := s.repo.RemoveUserFromOrganization(ctx, orgId, userId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !removed {
+		return &errors.ApiError{
+			Message:    "Member not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	return nil
+}
+
+// TransferOwnership hands the organization to another member. The previous
+// owner stays on as an admin.
+func (s *DefaultOrganizationService) TransferOwnership(ctx context.Context, actorId string, orgId string, userId string) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.TransferOwnership")
+	defer span.End()
+	org, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if actorId == userId {
+		return nil, &errors.ApiError{
+			Message:    "You already own this organization",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	transferred, err := s.repo.TransferOwnership(ctx, orgId, actorId, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !transferred {
+		return nil, &errors.ApiError{
+			Message:    "The new owner must be a member of the organization",
+			StatusCode: http.StatusBadRequest,
+			Status:     errors.ValidationError,
+		}
+	}
+	org.Owner = userId
+	org.Role = models.RoleAdmin
+	return toOrganizationResponse(org), nil
+}
+
+func (s *DefaultOrganizationService) UpdateOrganization(ctx context.Context, actorId string, orgId string, req *dto.UpdateOrganizationRequest) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.UpdateOrganization")
+	defer span.End()
+	org, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner, models.RoleAdmin)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+		org.Name = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+		org.Description = *req.Description
+	}
+	if len(updates) > 0 {
+		if err := s.repo.UpdateOrganization(ctx, orgId, updates); err != nil {
+			return nil, &errors.ApiError{
+				Message:    errors.InternalServerError,
+				StatusCode: http.StatusInternalServerError,
+				Status:     errors.InternalServerError,
+			}
+		}
+	}
+	return toOrganizationResponse(org), nil
+}
+
+// DeleteOrganization soft-deletes the organization. Only the owner may delete
+// it, and they can restore it within OrganizationRestorePeriod.
+func (s *DefaultOrganizationService) DeleteOrganization(ctx context.Context, actorId string, orgId string) (*dto.DeleteOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.DeleteOrganization")
+	defer span.End()
+	if _, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner); apiErr != nil {
+		return nil, apiErr
+	}
+	if err := s.repo.SoftDeleteOrganization(ctx, orgId); err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return &dto.DeleteOrganizationResponse{
+		OrgId:           orgId,
+		RestorableUntil: time.Now().Add(OrganizationRestorePeriod),
+	}, nil
+}
+
+func (s *DefaultOrganizationService) RestoreOrganization(ctx context.Context, actorId string, orgId string) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.RestoreOrganization")
+	defer span.End()
+	org, err := s.repo.GetDeletedOrganization(ctx, actorId, orgId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Deleted organization not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	if org.Role != models.RoleOwner {
+		return nil, &errors.ApiError{
+			Message:    "You do not have permission to manage this organization",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	restored, err := s.repo.RestoreOrganization(ctx, orgId, time.Now().Add(-OrganizationRestorePeriod))
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !restored {
+		return nil, &errors.ApiError{
+			Message:    "The restore period for this organization has ended",
+			StatusCode: http.StatusGone,
+			Status:     "Gone",
+		}
+	}
+	return toOrganizationResponse(org), nil
+}
+
+// PurgeDeletedOrganizations permanently removes organizations whose restore
+// period has ended.
+func (s *DefaultOrganizationService) PurgeDeletedOrganizations(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.PurgeDeletedOrganizations")
+	defer span.End()
+	return s.repo.PurgeDeletedOrganizations(ctx, time.Now().Add(-OrganizationRestorePeriod))
+}
+
+// ListMembers returns one page of the organization's members to any member.
+func (s *DefaultOrganizationService) ListMembers(ctx context.Context, actorId string, orgId string, query *dto.ListMembersQuery) ([]*dto.MemberResponse, *dto.PageMeta, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.ListMembers")
+	defer span.End()
+	if _, apiErr := requireOrgRole(ctx, s.repo, actorId, orgId, models.RoleOwner, models.RoleAdmin, models.RoleMember); apiErr != nil {
+		return nil, nil, apiErr
+	}
+	page, apiErr := newPage(query.Limit, query.Cursor, query.Sort, query.Order, repository.SortName)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	members, err := s.repo.ListOrganizationMembers(ctx, orgId, repository.MemberQuery{Page: page, Role: query.Role, Prefix: query.Q})
+	if err != nil {
+		return nil, nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	members, meta := trimPage(members, page, repository.MemberCursor)
+	response := []*dto.MemberResponse{}
+	for _, member := range members {
+		response = append(response, &dto.MemberResponse{
+			UserId:    member.UserId,
+			FirstName: member.FirstName,
+			LastName:  member.LastName,
+			Email:     member.Email,
+			Role:      member.Role,
+			JoinedAt:  member.JoinedAt,
+		})
+	}
+	return response, meta, nil
+}
+
+func (s *DefaultOrganizationService) IsMfaRequiredForUser(ctx context.Context, userId string) (bool, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.IsMfaRequiredForUser")
+	defer span.End()
+	required, err := s.repo.IsMfaRequiredForUser(ctx, userId)
+	if err != nil {
+		return false, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	return required, nil
+}
+
+// CheckMfaPolicy rejects access to an organization that requires MFA when
+// the current session was not started with a second factor.
+func (s *DefaultOrganizationService) CheckMfaPolicy(ctx context.Context, userId string, orgId string, mfaAuthenticated bool) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "OrganizationService.CheckMfaPolicy")
+	defer span.End()
+	if mfaAuthenticated {
+		return nil
+	}
+	org, err := s.repo.GetOrganizationById(ctx, userId, orgId)
+	if err != nil {
+		// Membership is checked by the handlers, which report it properly
+		return nil
+	}
+	if org.RequireMfa {
+		return &errors.ApiError{
+			Message:    "This organization requires two-factor authentication",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
 	return nil
 }
 
+// SetMfaPolicy turns the MFA requirement for all members on or off. Only
+// owners and admins may change it.
+func (s *DefaultOrganizationService) SetMfaPolicy(ctx context.Context, userId string, orgId string, requireMfa bool) (*dto.GetOrganizationResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "OrganizationService.SetMfaPolicy")
+	defer span.End()
+	org, apiErr := requireOrgRole(ctx, s.repo, userId, orgId, models.RoleOwner, models.RoleAdmin)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if err := s.repo.SetRequireMfa(ctx, orgId, requireMfa); err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	org.RequireMfa = requireMfa
+	return toOrganizationResponse(org), nil
+}
+
 func NewOrganizationService(repo repository.OrganizationRepository) *DefaultOrganizationService {
 	return &DefaultOrganizationService{repo: repo}
 }
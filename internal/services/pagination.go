@@ -0,0 +1,53 @@
+package services
+
+import (
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/repository"
+	"net/http"
+)
+
+// DefaultPageSize is used by paginated listings when no limit is given.
+const DefaultPageSize = 20
+
+// newPage validates the paging parameters shared by listings. The returned
+// page asks for one extra row so trimPage can tell whether another follows.
+func newPage(limit int, cursor string, sort string, order string, defaultSort string) (repository.Page, *errors.ApiError) {
+	page := repository.Page{Sort: sort, Desc: order == "desc", Limit: limit}
+	if page.Sort == "" {
+		page.Sort = defaultSort
+	}
+	if page.Limit == 0 {
+		page.Limit = DefaultPageSize
+	}
+	if cursor != "" {
+		var after repository.Cursor
+		// A cursor only makes sense for the ordering it was issued for
+		if err := helpers.DecodeCursor(cursor, &after); err != nil || after.Sort != page.Sort || after.Desc != page.Desc {
+			return page, &errors.ApiError{
+				Message:    "Invalid cursor",
+				StatusCode: http.StatusBadRequest,
+				Status:     errors.ValidationError,
+			}
+		}
+		page.After = &after
+	}
+	page.Limit++
+	return page, nil
+}
+
+// trimPage drops the extra row fetched by newPage and builds the page
+// metadata, with a cursor pointing after the last row kept.
+func trimPage[T any](rows []T, page repository.Page, cursorFor func(string, T) *repository.Cursor) ([]T, *dto.PageMeta) {
+	size := page.Limit - 1
+	meta := &dto.PageMeta{Limit: size}
+	if len(rows) > size {
+		rows = rows[:size]
+		cursor := cursorFor(page.Sort, rows[size-1])
+		cursor.Desc = page.Desc
+		next := helpers.EncodeCursor(cursor)
+		meta.NextCursor = &next
+	}
+	return rows, meta
+}
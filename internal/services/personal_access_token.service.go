@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/tracing"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxPersonalAccessTokens is how many tokens a user can hold at once,
+// expired ones included.
+const MaxPersonalAccessTokens = 50
+
+// personalAccessTokenTouchInterval limits how often last-used tracking
+// writes to the database for a busy token.
+const personalAccessTokenTouchInterval = time.Minute
+
+// PersonalAccessTokenService manages the long-lived tokens users create for
+// scripts and CI. The raw token is only returned by CreateToken; afterwards
+// only its prefix is shown.
+type PersonalAccessTokenService interface {
+	CreateToken(ctx context.Context, userId string, mfa bool, req *dto.CreatePersonalAccessTokenRequest) (*dto.CreatePersonalAccessTokenResponse, *errors.ApiError)
+	ListTokens(ctx context.Context, userId string) ([]*dto.PersonalAccessTokenResponse, *errors.ApiError)
+	RevokeToken(ctx context.Context, userId string, tokenId string) *errors.ApiError
+	// AuthenticatePersonalAccessToken returns the token for a raw value and
+	// records its use. Revoked and expired tokens, and tokens of accounts
+	// scheduled for deletion, are rejected.
+	AuthenticatePersonalAccessToken(ctx context.Context, raw string) (*models.PersonalAccessToken, *errors.ApiError)
+}
+
+type DefaultPersonalAccessTokenService struct {
+	repo     repository.PersonalAccessTokenRepository
+	userRepo repository.UserRepository
+	logger   *slog.Logger
+}
+
+func toPersonalAccessTokenResponse(token *models.PersonalAccessToken) *dto.PersonalAccessTokenResponse {
+	return &dto.PersonalAccessTokenResponse{
+		Id:         token.Id,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		LastUsedIp: token.LastUsedIp,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// CreateToken issues a token with the requested scopes. Tokens created in a
+// session that passed MFA satisfy organization MFA policies, like the
+// session itself.
+func (s *DefaultPersonalAccessTokenService) CreateToken(ctx context.Context, userId string, mfa bool, req *dto.CreatePersonalAccessTokenRequest) (*dto.CreatePersonalAccessTokenResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "PersonalAccessTokenService.CreateToken")
+	defer span.End()
+	internal := &errors.ApiError{
+		Message:    errors.InternalServerError,
+		StatusCode: http.StatusInternalServerError,
+		Status:     errors.InternalServerError,
+	}
+	existing, err := s.repo.GetPersonalAccessTokens(ctx, userId)
+	if err != nil {
+		return nil, internal
+	}
+	if len(existing) >= MaxPersonalAccessTokens {
+		return nil, &errors.ApiError{
+			Message:    "Too many personal access tokens, revoke one first",
+			StatusCode: http.StatusConflict,
+			Status:     errors.ValidationError,
+		}
+	}
+	for _, token := range existing {
+		if strings.EqualFold(token.Name, req.Name) {
+			return nil, &errors.ApiError{
+				Message:    "A personal access token with this name already exists",
+				StatusCode: http.StatusConflict,
+				Status:     errors.ValidationError,
+			}
+		}
+	}
+	secret, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, internal
+	}
+	raw := models.PersonalAccessTokenPrefix + secret
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	token := &models.PersonalAccessToken{
+		UserId:    userId,
+		Name:      req.Name,
+		Prefix:    raw[:len(models.PersonalAccessTokenPrefix)+4],
+		TokenHash: helpers.HashToken(raw),
+		Scopes:    strings.Join(scopes, " "),
+		Mfa:       mfa,
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := s.repo.CreatePersonalAccessToken(ctx, token); err != nil {
+		return nil, internal
+	}
+	s.logger.InfoContext(ctx, "Personal access token created", "userId", userId, "tokenId", token.Id, "scopes", token.Scopes)
+	return &dto.CreatePersonalAccessTokenResponse{
+		PersonalAccessTokenResponse: *toPersonalAccessTokenResponse(token),
+		Token:                       raw,
+	}, nil
+}
+
+func (s *DefaultPersonalAccessTokenService) ListTokens(ctx context.Context, userId string) ([]*dto.PersonalAccessTokenResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "PersonalAccessTokenService.ListTokens")
+	defer span.End()
+	tokens, err := s.repo.GetPersonalAccessTokens(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	response := []*dto.PersonalAccessTokenResponse{}
+	for _, token := range tokens {
+		response = append(response, toPersonalAccessTokenResponse(token))
+	}
+	return response, nil
+}
+
+func (s *DefaultPersonalAccessTokenService) RevokeToken(ctx context.Context, userId string, tokenId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "PersonalAccessTokenService.RevokeToken")
+	defer span.End()
+	deleted, err := s.repo.DeletePersonalAccessToken(ctx, userId, tokenId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+			Status:     errors.InternalServerError,
+		}
+	}
+	if !deleted {
+		return &errors.ApiError{
+			Message:    "Personal access token not found",
+			StatusCode: http.StatusNotFound,
+			Status:     "Not Found",
+		}
+	}
+	s.logger.InfoContext(ctx, "Personal access token revoked", "userId", userId, "tokenId", tokenId)
+	return nil
+}
+
+func (s *DefaultPersonalAccessTokenService) AuthenticatePersonalAccessToken(ctx context.Context, raw string) (*models.PersonalAccessToken, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "PersonalAccessTokenService.AuthenticatePersonalAccessToken")
+	defer span.End()
+	invalid := &errors.ApiError{
+		Message:    "Invalid Token",
+		StatusCode: http.StatusUnauthorized,
+		Status:     errors.UnAuthorized,
+	}
+	token, err := s.repo.GetPersonalAccessTokenByHash(ctx, helpers.HashToken(raw))
+	if err != nil {
+		return nil, invalid
+	}
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, &errors.ApiError{
+			Message:    "Token has expired",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	// Tokens stop working while the account waits to be deleted and come
+	// back if the deletion is cancelled
+	u, err := s.userRepo.GetUserById(ctx, token.UserId)
+	if err != nil || u.DeletionScheduledAt != nil {
+		return nil, invalid
+	}
+	ip := clientInfoFrom(ctx).IpAddress
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= personalAccessTokenTouchInterval || token.LastUsedIp != ip {
+		if err := s.repo.TouchPersonalAccessToken(ctx, token.Id, now, ip); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to record personal access token use", "tokenId", token.Id, "error", err)
+		} else {
+			token.LastUsedAt = &now
+			token.LastUsedIp = ip
+		}
+	}
+	return token, nil
+}
+
+func NewPersonalAccessTokenService(repo repository.PersonalAccessTokenRepository, userRepo repository.UserRepository, logger *slog.Logger) *DefaultPersonalAccessTokenService {
+	return &DefaultPersonalAccessTokenService{repo: repo, userRepo: userRepo, logger: logger}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RunOrganizationPurge purges organizations past their restore period every
+// interval until stop is closed. A nil stop channel runs for the life of the
+// process.
+func RunOrganizationPurge(service OrganizationService, interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	runPurge("deleted organizations", service.PurgeDeletedOrganizations, interval, logger, stop)
+}
+
+// RunAccountPurge purges accounts past their deletion cooling-off period,
+// like RunOrganizationPurge.
+func RunAccountPurge(service AccountService, interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	runPurge("deleted accounts", service.PurgeDeletedAccounts, interval, logger, stop)
+}
+
+// RunLoginThrottlePurge forgets quiet clients and accounts, like
+// RunOrganizationPurge.
+func RunLoginThrottlePurge(limiter LoginLimiter, interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	runPurge("stale login throttles", limiter.PurgeStaleThrottles, interval, logger, stop)
+}
+
+// runPurge calls purge every interval. Closing stop also cancels a purge in
+// progress.
+func runPurge(what string, purge func(ctx context.Context) (int64, error), interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := purge(ctx)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to purge "+what, "error", err)
+		} else if purged > 0 {
+			logger.InfoContext(ctx, "Purged "+what, "count", purged)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
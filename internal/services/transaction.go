@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	goerrors "errors"
+	"h-two/internal/errors"
+	"h-two/internal/repository"
+	"log/slog"
+	"net/http"
+)
+
+// errRollback aborts a unit of work whose failure is already described by an
+// ApiError.
+var errRollback = goerrors.New("unit of work rolled back")
+
+// inTransaction runs fn as one unit of work, rolling back everything it wrote
+// when it returns an error.
+func inTransaction(ctx context.Context, txm repository.TxManager, logger *slog.Logger, fn func(ctx context.Context) *errors.ApiError) *errors.ApiError {
+	var apiErr *errors.ApiError
+	err := txm.WithinTransaction(ctx, func(ctx context.Context) error {
+		if apiErr = fn(ctx); apiErr != nil {
+			return errRollback
+		}
+		return nil
+	})
+	if apiErr != nil {
+		return apiErr
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to commit transaction", "error", err)
+		return &errors.ApiError{
+			Status:     "error",
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	return nil
+}
@@ -1,53 +1,72 @@
 package services
 
 import (
-	"github.com/gin-gonic/gin"
+	"context"
 	"h-two/internal/dto"
 	"h-two/internal/errors"
 	"h-two/internal/repository"
-	"log"
+	"h-two/internal/tracing"
+	"log/slog"
 	"net/http"
+	"strings"
+)
+
+// EmailVerificationPolicy decides what unverified users are allowed to do.
+type EmailVerificationPolicy string
+
+const (
+	// EmailVerificationOff lets unverified users do everything.
+	EmailVerificationOff EmailVerificationPolicy = "off"
+	// EmailVerificationRestricted lets unverified users log in but not create
+	// organizations or invite members.
+	EmailVerificationRestricted EmailVerificationPolicy = "restricted"
 )
 
 type UserService interface {
-	GetUserDetails(c *gin.Context, userId string) (*dto.UserResponse, *errors.ApiError)
+	GetUserDetails(ctx context.Context, requestingUserId string, userId string) (*dto.UserResponse, *errors.ApiError)
+	RequireVerifiedEmail(ctx context.Context, userId string) *errors.ApiError
+	UpdateProfile(ctx context.Context, userId string, req *dto.UpdateProfileRequest) (*dto.UserResponse, *errors.ApiError)
 }
 
 type DefaultUserService struct {
-	repo repository.UserRepository
+	repo               repository.UserRepository
+	verificationPolicy EmailVerificationPolicy
+	logger             *slog.Logger
 }
 
-//func (s *DefaultUserService) GetUserDetails(c *gin.Context, userId string) (*dto.UserResponse, *errors.ApiError) {
-//	if c.GetString("userId") != userId {
-//		return nil, &errors.ApiError{
-//			Message:    "Unauthorized",
-//			StatusCode: http.StatusUnauthorized,
-//			Status:     errors.UnAuthorized,
-//		}
-//	}
-//	user, err := s.repo.GetUserById(userId)
-//	if err != nil {
-//		return nil, &errors.ApiError{
-//			Message:    "User not found",
-//			StatusCode: http.StatusNotFound,
-//			Status:     errors.UserNotFound,
-//		}
-//	}
-//	return &dto.UserResponse{
-//		UserId:    user.UserId,
-//		FirstName: user.FirstName,
-//		LastName:  user.LastName,
-//		Email:     user.Email,
-//		Phone:     user.Phone,
-//	}, nil
-//}
+// RequireVerifiedEmail returns an error if the verification policy forbids
+// the user from performing restricted actions.
+func (s *DefaultUserService) RequireVerifiedEmail(ctx context.Context, userId string) *errors.ApiError {
+	ctx, span := tracing.Start(ctx, "UserService.RequireVerifiedEmail")
+	defer span.End()
+	if s.verificationPolicy == EmailVerificationOff {
+		return nil
+	}
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	if user.EmailVerifiedAt == nil {
+		return &errors.ApiError{
+			Message:    "Please verify your email address first",
+			StatusCode: http.StatusForbidden,
+			Status:     "Forbidden",
+		}
+	}
+	return nil
+}
 
-func (s *DefaultUserService) GetUserDetails(c *gin.Context, userId string) (*dto.UserResponse, *errors.ApiError) {
-	requestingUserId := c.GetString("userId")
-	log.Println("Requesting user ID: ", requestingUserId)
+func (s *DefaultUserService) GetUserDetails(ctx context.Context, requestingUserId string, userId string) (*dto.UserResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserDetails")
+	defer span.End()
+	s.logger.DebugContext(ctx, "Fetching user details", "requestingUserId", requestingUserId, "userId", userId)
 	if requestingUserId == userId {
 		// The user is requesting their own details
-		user, err := s.repo.GetUserById(userId)
+		user, err := s.repo.GetUserById(ctx, userId)
 		if err != nil {
 			return nil, &errors.ApiError{
 				Message:    "Unauthorized",
@@ -66,10 +85,9 @@ func (s *DefaultUserService) GetUserDetails(c *gin.Context, userId string) (*dto
 		// The user is requesting details of another user\
 		// Check if the requesting user is in the same organization as the user
 
-		if same, _ := s.repo.AreUsersInSameOrganization(requestingUserId, userId); same {
-			log.Println(same)
+		if same, _ := s.repo.AreUsersInSameOrganization(ctx, requestingUserId, userId); same {
 			// get requesting user details
-			requestingUser, err := s.repo.GetUserById(userId)
+			requestingUser, err := s.repo.GetUserById(ctx, userId)
 			if err != nil {
 				return nil, &errors.ApiError{
 					Message:    "Unauthorized",
@@ -96,6 +114,63 @@ func (s *DefaultUserService) GetUserDetails(c *gin.Context, userId string) (*dto
 	}
 }
 
-func NewUserService(repo repository.UserRepository) *DefaultUserService {
-	return &DefaultUserService{repo: repo}
+// UpdateProfile changes the name and phone of the user. Names are trimmed and
+// must not be blank.
+func (s *DefaultUserService) UpdateProfile(ctx context.Context, userId string, req *dto.UpdateProfileRequest) (*dto.UserResponse, *errors.ApiError) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateProfile")
+	defer span.End()
+	updates := map[string]interface{}{}
+	names := []struct {
+		value  *string
+		column string
+		label  string
+	}{
+		{req.FirstName, "first_name", "First name"},
+		{req.LastName, "last_name", "Last name"},
+	}
+	for _, name := range names {
+		if name.value == nil {
+			continue
+		}
+		trimmed := strings.TrimSpace(*name.value)
+		if trimmed == "" {
+			return nil, &errors.ApiError{
+				Message:    name.label + " cannot be blank",
+				StatusCode: http.StatusUnprocessableEntity,
+				Status:     errors.ValidationError,
+			}
+		}
+		updates[name.column] = trimmed
+	}
+	if req.Phone != nil {
+		updates["phone"] = strings.TrimSpace(*req.Phone)
+	}
+	if len(updates) > 0 {
+		if err := s.repo.UpdateProfile(ctx, userId, updates); err != nil {
+			return nil, &errors.ApiError{
+				Message:    "Profile update unsuccessful",
+				StatusCode: http.StatusInternalServerError,
+				Status:     errors.InternalServerError,
+			}
+		}
+	}
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Message:    "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Status:     errors.UnAuthorized,
+		}
+	}
+	return &dto.UserResponse{
+		UserId:    user.UserId,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Phone:     user.Phone,
+	}, nil
+}
+
+func NewUserService(repo repository.UserRepository, verificationPolicy EmailVerificationPolicy, logger *slog.Logger) *DefaultUserService {
+	return &DefaultUserService{repo: repo, verificationPolicy: verificationPolicy, logger: logger}
 }
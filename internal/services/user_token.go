@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"net/http"
+	"time"
+)
+
+// issueUserToken stores a new single-use token for the user and returns the
+// raw value to send out. Only its hash is persisted.
+func issueUserToken(ctx context.Context, repo repository.UserTokenRepository, userId string, purpose string, ttl time.Duration) (string, error) {
+	raw, err := helpers.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = repo.CreateUserToken(ctx, &models.UserToken{
+		UserId:    userId,
+		Purpose:   purpose,
+		TokenHash: helpers.HashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeUserToken validates a raw token for the given purpose and marks it
+// as used, so the same token can never be redeemed twice.
+func consumeUserToken(ctx context.Context, repo repository.UserTokenRepository, raw string, purpose string) (*models.UserToken, *errors.ApiError) {
+	invalid := &errors.ApiError{
+		Status:     errors.ValidationError,
+		Message:    "Invalid or expired token",
+		StatusCode: http.StatusBadRequest,
+	}
+	token, err := repo.GetUserTokenByHash(ctx, helpers.HashToken(raw), purpose)
+	if err != nil {
+		return nil, invalid
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, invalid
+	}
+	consumed, err := repo.ConsumeUserToken(ctx, token.Id)
+	if err != nil {
+		return nil, &errors.ApiError{
+			Status:     errors.InternalServerError,
+			Message:    errors.InternalServerError,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	if !consumed {
+		return nil, invalid
+	}
+	return token, nil
+}
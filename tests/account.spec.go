@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FakeLoginEventRepository keeps login history in memory.
+type FakeLoginEventRepository struct {
+	Events []*models.LoginEvent
+}
+
+func NewFakeLoginEventRepository() *FakeLoginEventRepository {
+	return &FakeLoginEventRepository{}
+}
+
+func (r *FakeLoginEventRepository) RecordLogin(ctx context.Context, event *models.LoginEvent) error {
+	event.CreatedAt = time.Now()
+	r.Events = append(r.Events, event)
+	return nil
+}
+
+func (r *FakeLoginEventRepository) GetLoginEvents(ctx context.Context, userId string) ([]*models.LoginEvent, error) {
+	var events []*models.LoginEvent
+	for i := len(r.Events) - 1; i >= 0; i-- {
+		if r.Events[i].UserId == userId {
+			events = append(events, r.Events[i])
+		}
+	}
+	return events, nil
+}
+
+func TestAccountExportAndDeletion(t *testing.T) {
+	s := setupServer()
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "some-user-id", FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserById", "some-user-id").Return(user, nil)
+	userRepo.On("ScheduleDeletion", "some-user-id", mock.Anything).Run(func(args mock.Arguments) {
+		user.DeletionScheduledAt = args.Get(1).(*time.Time)
+	}).Return(nil)
+	joinedAt := time.Now()
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("GetMemberships", "some-user-id").Return([]*models.Organization{{OrgId: "org-1", Name: "Acme", Role: models.RoleOwner, JoinedAt: &joinedAt}}, nil)
+	orgRepo.On("GetOwnedOrganizations", "some-user-id").Return([]*models.Organization{{OrgId: "org-1", Name: "Acme"}}, nil).Once()
+	orgRepo.On("GetOwnedOrganizations", "some-user-id").Return([]*models.Organization{}, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("RevokeUserRefreshTokens", "some-user-id", mock.AnythingOfType("string")).Return(nil)
+	events := NewFakeLoginEventRepository()
+	events.RecordLogin(context.Background(), &models.LoginEvent{UserId: "some-user-id", Method: models.LoginMethodPassword, IpAddress: "192.0.2.1"})
+	s.AccountService = services.NewAccountService(userRepo, orgRepo, events, refreshRepo, s.TokenRevocations, &RecordingMailSender{}, logging.Discard())
+
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.GET("/api/users/me/export", authMiddleware, s.ExportAccountHandler)
+	r.DELETE("/api/users/me", authMiddleware, s.DeleteAccountHandler)
+	r.POST("/api/users/me/restore", authMiddleware, s.CancelAccountDeletionHandler)
+	r.GET("/api/organisations", authMiddleware, s.GetOrganizationsHandler)
+	current := loginForToken(t, r)
+	other := loginForToken(t, r)
+
+	req, _ := http.NewRequest("GET", "/api/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+current)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected export to return %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("Content-Disposition") == "" {
+		t.Fatal("Expected the export to be served as an attachment")
+	}
+	var export dto.AccountExport
+	if err := json.Unmarshal(rr.Body.Bytes(), &export); err != nil {
+		t.Fatalf("Error decoding export: %v", err)
+	}
+	if export.Profile.Email != "john.doe@example.com" || len(export.Memberships) != 1 || export.Memberships[0].Role != models.RoleOwner {
+		t.Fatalf("Expected the profile and memberships in the export, got %+v", export)
+	}
+	if len(export.LoginHistory) != 1 || export.LoginHistory[0].IpAddress != "192.0.2.1" {
+		t.Fatalf("Expected the login history in the export, got %+v", export.LoginHistory)
+	}
+	if strings.Contains(rr.Body.String(), h) {
+		t.Fatal("Expected the export to leave out the password hash")
+	}
+
+	rr = authorizedJSONRequest(r, "DELETE", "/api/users/me", current, &dto.DeleteAccountRequest{Password: "wrong"})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a wrong password to be rejected with %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "DELETE", "/api/users/me", current, &dto.DeleteAccountRequest{Password: "password123"})
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected an organization owner to be blocked with %d, got %d", http.StatusConflict, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "DELETE", "/api/users/me", current, &dto.DeleteAccountRequest{Password: "password123"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected deletion to be scheduled with %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if user.DeletionScheduledAt == nil || user.DeletionScheduledAt.Before(time.Now().Add(services.AccountDeletionCoolingOff-time.Minute)) {
+		t.Fatalf("Expected deletion to wait for the cooling-off period, got %v", user.DeletionScheduledAt)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", current); code != http.StatusOK {
+		t.Fatalf("Expected the current session to survive, got %d", code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", other); code != http.StatusUnauthorized {
+		t.Fatalf("Expected other sessions to be rejected with %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	if code := authorizedRequest(r, "POST", "/api/users/me/restore", current); code != http.StatusOK {
+		t.Fatalf("Expected cancelling the deletion to return %d, got %d", http.StatusOK, code)
+	}
+	if user.DeletionScheduledAt != nil {
+		t.Fatal("Expected the deletion to be cancelled")
+	}
+	if code := authorizedRequest(r, "POST", "/api/users/me/restore", current); code != http.StatusBadRequest {
+		t.Fatalf("Expected cancelling again to return %d, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -2,17 +2,21 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/stretchr/testify/mock"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"h-two/internal/config"
 	"h-two/internal/dto"
+	"h-two/internal/keyring"
+	"h-two/internal/logging"
+	"h-two/internal/mail"
 	"h-two/internal/models"
+	"h-two/internal/repository"
 	"h-two/internal/server"
 	"h-two/internal/services"
 	"log"
@@ -28,83 +32,249 @@ type MockOrganizationRepository struct {
 	mock.Mock
 }
 
-func (m *MockOrganizationRepository) CreateOrganization(org *models.Organization) error {
+func (m *MockOrganizationRepository) CreateOrganization(ctx context.Context, org *models.Organization) error {
 	args := m.Called(org)
 	return args.Error(0)
 }
 
-func (m *MockOrganizationRepository) GetOrganizationsByUser(userId string) ([]*models.Organization, error) {
+func (m *MockOrganizationRepository) GetOrganizationsByUser(ctx context.Context, userId string, page repository.Page) ([]*models.Organization, error) {
+	args := m.Called(userId, page)
+	return args.Get(0).([]*models.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) GetMemberships(ctx context.Context, userId string) ([]*models.Organization, error) {
+	args := m.Called(userId)
+	return args.Get(0).([]*models.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) GetOwnedOrganizations(ctx context.Context, userId string) ([]*models.Organization, error) {
 	args := m.Called(userId)
 	return args.Get(0).([]*models.Organization), args.Error(1)
 }
 
-func (m *MockOrganizationRepository) GetOrganizationById(userId string, orgId string) (*models.Organization, error) {
+func (m *MockOrganizationRepository) GetOrganizationById(ctx context.Context, userId string, orgId string) (*models.Organization, error) {
 	args := m.Called(userId, orgId)
 	return args.Get(0).(*models.Organization), args.Error(1)
 }
 
-func (m *MockOrganizationRepository) AddUserToOrganization(orgId string, userId string) error {
+func (m *MockOrganizationRepository) AddUserToOrganization(ctx context.Context, orgId string, userId string, role string) error {
+	args := m.Called(orgId, userId, role)
+	return args.Error(0)
+}
+
+func (m *MockOrganizationRepository) RemoveUserFromOrganization(ctx context.Context, orgId string, userId string) (bool, error) {
 	args := m.Called(orgId, userId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) TransferOwnership(ctx context.Context, orgId string, fromUserId string, toUserId string) (bool, error) {
+	args := m.Called(orgId, fromUserId, toUserId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) UpdateOrganization(ctx context.Context, orgId string, updates map[string]interface{}) error {
+	args := m.Called(orgId, updates)
 	return args.Error(0)
 }
 
-func (m *MockOrganizationRepository) Begin() *gorm.DB {
-	args := m.Called()
-	return args.Get(0).(*gorm.DB)
+func (m *MockOrganizationRepository) SoftDeleteOrganization(ctx context.Context, orgId string) error {
+	args := m.Called(orgId)
+	return args.Error(0)
+}
+
+func (m *MockOrganizationRepository) GetDeletedOrganization(ctx context.Context, userId string, orgId string) (*models.Organization, error) {
+	args := m.Called(userId, orgId)
+	return args.Get(0).(*models.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) RestoreOrganization(ctx context.Context, orgId string, deletedAfter time.Time) (bool, error) {
+	args := m.Called(orgId, deletedAfter)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) PurgeDeletedOrganizations(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	args := m.Called(deletedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) ListOrganizationMembers(ctx context.Context, orgId string, query repository.MemberQuery) ([]*models.OrganizationMember, error) {
+	args := m.Called(orgId, query)
+	return args.Get(0).([]*models.OrganizationMember), args.Error(1)
+}
 
+func (m *MockOrganizationRepository) UpdateMemberRole(ctx context.Context, orgId string, userId string, role string) error {
+	args := m.Called(orgId, userId, role)
+	return args.Error(0)
+}
+
+func (m *MockOrganizationRepository) IsMfaRequiredForUser(ctx context.Context, userId string) (bool, error) {
+	args := m.Called(userId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) SetRequireMfa(ctx context.Context, orgId string, requireMfa bool) error {
+	args := m.Called(orgId, requireMfa)
+	return args.Error(0)
 }
 
 type MockUserRepository struct {
 	mock.Mock
 }
 
-func (m *MockUserRepository) CreateUser(user *models.User) (*dto.UserResponse, error) {
+func (m *MockUserRepository) CreateUser(ctx context.Context, user *models.User) (*dto.UserResponse, error) {
 	args := m.Called(user)
 	return args.Get(0).(*dto.UserResponse), args.Error(1)
 }
 
-func (m *MockUserRepository) GetUserByEmail(email string) (*models.User, error) {
+func (m *MockUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	args := m.Called(email)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserRepository) GetUserById(userId string) (*models.User, error) {
+func (m *MockUserRepository) GetUserById(ctx context.Context, userId string) (*models.User, error) {
 	args := m.Called(userId)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserRepository) Begin() *gorm.DB {
-	args := m.Called()
-	return args.Get(0).(*gorm.DB)
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userId string, hash string) error {
+	args := m.Called(userId, hash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, userId string) error {
+	args := m.Called(userId)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, userId string, updates map[string]interface{}) error {
+	args := m.Called(userId, updates)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) ScheduleDeletion(ctx context.Context, userId string, at *time.Time) error {
+	args := m.Called(userId, at)
+	return args.Error(0)
 }
-func (m *MockOrganizationRepository) IsUserInOrganization(userId string, orgId string) (bool, error) {
+
+func (m *MockUserRepository) PurgeScheduledDeletions(ctx context.Context, before time.Time) (int64, error) {
+	args := m.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// PassthroughTxManager runs units of work directly, for services whose
+// repositories are mocks.
+type PassthroughTxManager struct{}
+
+func (PassthroughTxManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// NewTestLoginLimiter returns a limiter with an in-memory store and the
+// default limits, which no test trips by accident.
+func NewTestLoginLimiter() services.LoginLimiter {
+	return services.NewLoginLimiter(repository.NewInMemoryLoginThrottleRepository(), server.NewLoginLimits(config.Default().LoginLimits), logging.Discard())
+}
+
+func (m *MockUserRepository) GetUserOrganization(ctx context.Context, id string) (*models.User, error) {
+	args := m.Called(id)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error) {
+	args := m.Called(userId1, userId2)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOrganizationRepository) IsUserInOrganization(ctx context.Context, userId string, orgId string) (bool, error) {
 	args := m.Called(userId, orgId)
 	return args.Bool(0), args.Error(1)
 }
 
+func (m *MockOrganizationRepository) AreUsersInSameOrganization(ctx context.Context, userId1 string, userId2 string) (bool, error) {
+	args := m.Called(userId1, userId2)
+	return args.Bool(0), args.Error(1)
+}
+
+type MockRefreshTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	args := m.Called(hash)
+	token, _ := args.Get(0).(*models.RefreshToken)
+	return token, args.Error(1)
+}
+
+func (m *MockRefreshTokenRepository) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) (bool, error) {
+	args := m.Called(oldId, next)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	args := m.Called(familyId)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userId string, exceptFamilyId string) error {
+	args := m.Called(userId, exceptFamilyId)
+	return args.Error(0)
+}
+
+type MockUserTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockUserTokenRepository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockUserTokenRepository) GetUserTokenByHash(ctx context.Context, hash string, purpose string) (*models.UserToken, error) {
+	args := m.Called(hash, purpose)
+	if lookup, ok := args.Get(0).(func(string, string) (*models.UserToken, error)); ok {
+		return lookup(hash, purpose)
+	}
+	token, _ := args.Get(0).(*models.UserToken)
+	return token, args.Error(1)
+}
+
+func (m *MockUserTokenRepository) ConsumeUserToken(ctx context.Context, id string) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserTokenRepository) InvalidateUserTokens(ctx context.Context, userId string, purpose string) error {
+	args := m.Called(userId, purpose)
+	return args.Error(0)
+}
+
+// RecordingMailSender keeps sent messages in memory for assertions.
+type RecordingMailSender struct {
+	Messages []mail.Message
+}
+
+func (m *RecordingMailSender) Send(msg mail.Message) error {
+	m.Messages = append(m.Messages, msg)
+	return nil
+}
+
 func setupServer() *server.Server {
 
 	if err := godotenv.Load("../.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Printf("Error loading .env file: %v", err)
 
 	}
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 	log.Println("PORT: ", port)
-	db, _, err := sqlmock.New()
-	if err != nil {
-		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-
-	// Create a gorm instance from the sqlmock instance
-	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	//dbInstance := database.New()
 	mockRepo := new(MockOrganizationRepository)
-	mockRepo.On("Begin").Return(gdb)
-	mockRepo.On("GetOrganizationsByUser", mock.AnythingOfType("string")).Return([]*models.Organization{}, nil)
+	mockRepo.On("GetOrganizationsByUser", mock.AnythingOfType("string"), mock.AnythingOfType("repository.Page")).Return([]*models.Organization{}, nil)
 	mockRepo.On("CreateOrganization", mock.AnythingOfType("*models.Organization")).Return(nil)
+	mockRepo.On("IsMfaRequiredForUser", mock.AnythingOfType("string")).Return(false, nil)
 	organizationService := services.NewOrganizationService(mockRepo)
 
 	userRepo := new(MockUserRepository) // Pass the dbInstance to the UserRepository
@@ -131,14 +301,30 @@ func setupServer() *server.Server {
 
 	// Set up the GetUserByEmail method to return the User
 	userRepo.On("GetUserByEmail", "john.doe@example.com").Return(user, nil)
-	userRepo.On("Begin").Return(gdb)
-	authService := services.NewAuthService(userRepo, organizationService) // Pass the UserRepository to the AuthService
-	userService := services.NewUserService(userRepo)                      // Assuming you have a function to create a new AuthService
+	userRepo.On("GetUserByEmail", "new.user@example.com").Return((*models.User)(nil), gorm.ErrRecordNotFound)
+	userRepo.On("GetUserById", "some-user-id").Return(user, nil)
+	userRepo.On("UpdatePassword", "some-user-id", mock.AnythingOfType("string")).Return(nil)
+	userRepo.On("UpdateProfile", "some-user-id", mock.Anything).Return(nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	refreshRepo.On("RevokeRefreshTokenFamily", mock.AnythingOfType("string")).Return(nil)
+	refreshRepo.On("RevokeUserRefreshTokens", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+	tokenRevocations := repository.NewInMemoryTokenRevocationRepository()
+	userTokens := new(MockUserTokenRepository)
+	userTokens.On("InvalidateUserTokens", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+	userTokens.On("CreateUserToken", mock.AnythingOfType("*models.UserToken")).Return(nil)
+	mfaService := services.NewMfaService(userRepo, NewFakeMfaRepository(), organizationService, "h-two")
+	authService := services.NewAuthService(userRepo, organizationService, refreshRepo, tokenRevocations, userTokens, NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, mfaService, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard()) // Pass the UserRepository to the AuthService
+	userService := services.NewUserService(userRepo, services.EmailVerificationOff, logging.Discard())                                                                                                                                                                                                                       // Assuming you have a function to create a new AuthService
 	return &server.Server{
 		Port:                port,
 		AuthService:         authService,
 		UserService:         userService,
 		OrganizationService: organizationService,
+		MfaService:          mfaService,
+		TokenRevocations:    tokenRevocations,
+		LoginLimiter:        NewTestLoginLimiter(),
+		Keys:                testKeys,
 	}
 }
 
@@ -166,16 +352,27 @@ func setupServer() *server.Server {
 //	}
 //}
 
+// testAppURL is the base of every link emailed in the tests.
+const testAppURL = "http://localhost:8080"
+
+// testKeys signs every token issued in the tests. Generating an RSA key is
+// slow, so one keyring is shared.
+var testKeys = func() *keyring.Keyring {
+	key, err := keyring.GenerateKey(keyring.AlgRS256)
+	if err != nil {
+		log.Fatalf("Error generating signing key: %v", err)
+	}
+	return keyring.New(key)
+}()
+
 func TestTokenGeneration(t *testing.T) {
 	userID := "1"
-	token, err := services.GenerateJWT(userID)
+	token, err := services.GenerateJWT(testKeys, userID)
 	if err != nil {
 		t.Fatalf("Error generating JWT: %v", err)
 	}
 
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(services.SecretKey), nil
-	})
+	parsedToken, err := testKeys.Parse(token)
 	if err != nil {
 		t.Fatalf("Error parsing JWT: %v", err)
 	}
@@ -183,6 +380,9 @@ func TestTokenGeneration(t *testing.T) {
 	if !parsedToken.Valid {
 		t.Fatal("Token is not valid")
 	}
+	if parsedToken.Header["kid"] != testKeys.Keys()[0].Kid || parsedToken.Method.Alg() != keyring.AlgRS256 {
+		t.Fatalf("Expected token to be signed with the active key, got %v", parsedToken.Header)
+	}
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	if !ok {
@@ -216,7 +416,7 @@ func TestOrganizationAccessControl(t *testing.T) {
 		t.Fatalf("Expected status code to be %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	expected := "{\"status\":\"success\",\"message\":\"Organizations retrieved successfully\",\"data\":{\"organisations\":[]}}"
+	expected := "{\"status\":\"success\",\"message\":\"Organizations retrieved successfully\",\"data\":{\"organisations\":[]},\"meta\":{\"nextCursor\":null,\"limit\":20}}"
 	if rr.Body.String() != expected {
 		t.Fatalf("Expected body to be %s, got %s", expected, rr.Body.String())
 	}
@@ -263,6 +463,7 @@ func TestCreateOrganizationHandler(t *testing.T) {
 			OrgId:       "",
 			Name:        reqBody.Name,
 			Description: reqBody.Description,
+			Role:        models.RoleOwner,
 		},
 	}
 
@@ -288,7 +489,7 @@ func TestRegisterUserWithDefaultOrganization(t *testing.T) {
 	reqBody := &dto.CreateUserRequest{
 		FirstName: "John",
 		LastName:  "Doe",
-		Email:     "john.doe@example.com",
+		Email:     "new.user@example.com",
 		Password:  h,
 		Phone:     "1234567890",
 	}
@@ -326,17 +527,23 @@ func TestRegisterUserWithDefaultOrganization(t *testing.T) {
 		t.Fatal("Expected accessToken to be present and not empty")
 	}
 
+	refreshToken, ok := responseBody["data"].(map[string]interface{})["refreshToken"].(string)
+	if !ok || refreshToken == "" {
+		t.Fatal("Expected refreshToken to be present and not empty")
+	}
+
 	// Define the expected response
 	expected := dto.ApiSuccessResponse{
 		Status:  "success",
 		Message: "Registration successful",
 		Data: map[string]interface{}{
-			"accessToken": accessToken, // You can't predict the actual access token value, so leave it as an empty string
+			"accessToken":  accessToken, // You can't predict the actual access token value, so leave it as an empty string
+			"refreshToken": refreshToken,
 			"user": &dto.UserResponse{
 				UserId:    "some-user-id",
 				FirstName: reqBody.FirstName,
 				LastName:  reqBody.LastName,
-				Email:     reqBody.Email,
+				Email:     "john.doe@example.com", // returned by the mocked CreateUser
 				Phone:     reqBody.Phone,
 			},
 		},
@@ -398,4 +605,51 @@ func TestLoginUserSuccess(t *testing.T) {
 	if !ok || accessToken == "" {
 		t.Fatal("Expected accessToken to be present and not empty")
 	}
+
+	refreshToken, ok := responseBody["data"].(map[string]interface{})["refreshToken"].(string)
+	if !ok || refreshToken == "" {
+		t.Fatal("Expected refreshToken to be present and not empty")
+	}
+}
+
+// TestEmailCaseInsensitive checks that an address is stored lower-cased and
+// matched whatever case it is typed in later.
+func TestEmailCaseInsensitive(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "jane-id", FirstName: "Jane", Email: "jane@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "jane@example.com").Return((*models.User)(nil), gorm.ErrRecordNotFound).Once()
+	userRepo.On("CreateUser", mock.MatchedBy(func(u *models.User) bool { return u.Email == "jane@example.com" })).
+		Return(&dto.UserResponse{UserId: "jane-id", FirstName: "Jane", Email: "jane@example.com"}, nil)
+	userRepo.On("GetUserByEmail", "jane@example.com").Return(user, nil)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "jane-id").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	userTokens := new(MockUserTokenRepository)
+	userTokens.On("InvalidateUserTokens", "jane-id", mock.AnythingOfType("string")).Return(nil)
+	userTokens.On("CreateUserToken", mock.AnythingOfType("*models.UserToken")).Return(nil)
+	mailer := &RecordingMailSender{}
+	authService := services.NewAuthService(userRepo, services.NewOrganizationService(orgRepo), refreshRepo, repository.NewInMemoryTokenRevocationRepository(), userTokens, NewFakePersonalAccessTokenRepository(), mailer, nil, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+
+	created, err := authService.CreateUser(context.Background(), &dto.CreateUserRequest{FirstName: "Jane", LastName: "Doe", Email: " Jane@Example.com", Password: "password123", Phone: "555"})
+	if err != nil {
+		t.Fatalf("Expected registration to succeed, got %v", err)
+	}
+	if created.User.Email != "jane@example.com" {
+		t.Errorf("Expected the address to be stored lower-cased, got %s", created.User.Email)
+	}
+	if _, err := authService.Login(context.Background(), &dto.LoginRequest{Email: "JANE@example.com", Password: "password123"}); err != nil {
+		t.Fatalf("Expected login in another case to succeed, got %v", err)
+	}
+	if _, err := authService.CreateUser(context.Background(), &dto.CreateUserRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@EXAMPLE.com", Password: "password123", Phone: "555"}); err == nil || err.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected the address in another case to be taken, got %v", err)
+	}
+	if err := authService.ForgotPassword(context.Background(), &dto.ForgotPasswordRequest{Email: "Jane@Example.COM"}); err != nil {
+		t.Fatalf("Expected forgot password to succeed, got %v", err)
+	}
+	if len(mailer.Messages) != 2 || mailer.Messages[1].To != "jane@example.com" {
+		t.Errorf("Expected a reset email for the address in another case, got %d messages", len(mailer.Messages))
+	}
+	userRepo.AssertNumberOfCalls(t, "CreateUser", 1)
 }
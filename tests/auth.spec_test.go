@@ -10,5 +10,52 @@ func TestAll(t *testing.T) {
 	TestCreateOrganizationHandler(t)
 	TestRegisterUserWithDefaultOrganization(t)
 	TestLoginUserSuccess(t)
+	TestEmailCaseInsensitive(t)
+	TestRefreshTokenRotation(t)
+	TestRefreshTokenReuseRevokesFamily(t)
+	TestLogoutRevokesAccessToken(t)
+	TestLogoutAllRevokesEverySession(t)
+	TestLoginRightAfterLogoutAll(t)
+	TestPasswordResetFlow(t)
+	TestResetPasswordValidation(t)
+	TestUnverifiedUserCannotCreateOrganization(t)
+	TestTotpEnrollmentAndMfaLogin(t)
+	TestKeyRotationAndJwks(t)
+	TestKeyRotationWithoutRestart(t)
+	TestOrganizationRoles(t)
+	TestMemberRemovalAndOwnershipTransfer(t)
+	TestOrganizationUpdateDeleteAndRestore(t)
+	TestListOrganizationMembersPagination(t)
+	TestListOrganizationMembersQuery(t)
+	TestListOrganizationsPagination(t)
+	TestInvitationAcceptedAtLogin(t)
+	TestUpdateProfile(t)
+	TestChangePasswordRevokesOtherSessions(t)
+	TestEmailChangeFlow(t)
+	TestAccountExportAndDeletion(t)
+	TestEmbeddedMigrationsCoverModels(t)
+	TestMigrationFilesAndCreate(t)
+	TestMigratorUpAndCheck(t)
+	TestInitialMigrationAdoptsLegacySchema(t)
+	TestConfigPrecedence(t)
+	TestConfigValidation(t)
+	TestHealthAndReadiness(t)
+	TestGracefulShutdown(t)
+	TestRequestContextRecordsClient(t)
+	TestRequestContextTimeout(t)
+	TestRegistrationIsAtomic(t)
+	TestCreateOrganizationIsAtomic(t)
+	TestLogRedaction(t)
+	TestRequestIDMiddleware(t)
+	TestHttpAndAuthMetrics(t)
+	TestDatabaseMetrics(t)
+	TestTracingSpans(t)
+	TestTracingStdoutExporter(t)
+	TestLoginLockout(t)
+	TestLoginIpRateLimit(t)
+	TestLoginIpRateLimitBehindProxies(t)
+	TestLoginThrottleRepository(t)
+	TestPersonalAccessTokens(t)
+	TestPersonalAccessTokenRepository(t)
 
 }
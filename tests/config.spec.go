@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"h-two/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv unsets the variables the config tests set, so values from
+// the developer's shell do not leak in.
+func clearConfigEnv(t *testing.T) {
+	for _, name := range []string{"CONFIG_FILE", "PORT", "APP_URL", "DB_HOST", "DB_PORT", "DB_DATABASE", "DB_USERNAME",
+		"DB_PASSWORD", "DB_SSLMODE", "DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_CONN_MAX_LIFETIME", "JWT_KEYS_DIR",
+		"TOKEN_REVOCATION_STORE", "MAIL_DRIVER", "SMTP_HOST", "SMTP_PORT", "MAIL_FROM",
+		"TRACING_EXPORTER", "TRACING_ENDPOINT", "TRACING_SAMPLE_RATIO", "SERVER_TRUSTED_PROXIES"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  port: 9000
+  appUrl: https://app.example.com
+database:
+  host: db.internal
+  name: htwo
+  user: api
+  sslMode: verify-full
+  maxOpenConns: 20
+  maxIdleConns: 5
+  connMaxLifetime: 30m
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_HOST", "db.env")
+	t.Setenv("DB_MAX_IDLE_CONNS", "10")
+	t.Setenv("SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7")
+
+	cfg, rest, err := config.Load([]string{"-config", file, "-db-host", "db.flag", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Expected config to load, got %v", err)
+	}
+	if cfg.Server.Port != 9000 || cfg.Database.Name != "htwo" || cfg.Database.SSLMode != "verify-full" {
+		t.Errorf("Expected file values to override defaults, got %+v", cfg)
+	}
+	if cfg.Database.MaxIdleConns != 10 || cfg.Database.MaxOpenConns != 20 {
+		t.Errorf("Expected env to override the file, got %d idle and %d open", cfg.Database.MaxIdleConns, cfg.Database.MaxOpenConns)
+	}
+	if strings.Join(cfg.Server.TrustedProxies, " ") != "10.0.0.0/8 192.0.2.7" {
+		t.Errorf("Expected a comma separated list of trusted proxies, got %v", cfg.Server.TrustedProxies)
+	}
+	if cfg.Database.Host != "db.flag" {
+		t.Errorf("Expected flags to override env, got host %s", cfg.Database.Host)
+	}
+	if time.Duration(cfg.Database.ConnMaxLifetime) != 30*time.Minute {
+		t.Errorf("Expected a 30m connection lifetime, got %s", cfg.Database.ConnMaxLifetime)
+	}
+	if cfg.Auth.KeysDir != "keys" || cfg.Mail.Driver != "log" {
+		t.Errorf("Expected defaults for unset values, got %+v", cfg.Auth)
+	}
+	if strings.Join(rest, " ") != "migrate up" {
+		t.Errorf("Expected the subcommand to be left over, got %v", rest)
+	}
+	if cfg.AppURL() != "https://app.example.com" {
+		t.Errorf("Expected the configured app URL, got %s", cfg.AppURL())
+	}
+	if dsn := cfg.Database.DSN(); dsn != "postgres://api:@db.flag:5432/htwo?sslmode=verify-full" {
+		t.Errorf("Unexpected DSN %s", dsn)
+	}
+
+	// TOML files are read the same way, selected through CONFIG_FILE
+	tomlFile := filepath.Join(dir, "config.toml")
+	err = os.WriteFile(tomlFile, []byte("[database]\nname = \"fromtoml\"\nuser = \"api\"\npassword = \"p@ss/word\"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", tomlFile)
+	cfg, _, err = config.Load(nil)
+	if err != nil {
+		t.Fatalf("Expected TOML config to load, got %v", err)
+	}
+	if cfg.Database.Name != "fromtoml" || cfg.Database.SSLMode != "require" {
+		t.Errorf("Expected TOML values over defaults, got %+v", cfg.Database)
+	}
+	if dsn := cfg.Database.DSN(); !strings.Contains(dsn, "api:p%40ss%2Fword@") {
+		t.Errorf("Expected the password to be escaped in the DSN, got %s", dsn)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("DB_DATABASE", "htwo")
+	t.Setenv("DB_USERNAME", "api")
+
+	if _, _, err := config.Load(nil); err != nil {
+		t.Fatalf("Expected a minimal config to be valid, got %v", err)
+	}
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("DB_SSLMODE", "sometimes")
+	t.Setenv("DB_MAX_OPEN_CONNS", "2")
+	t.Setenv("DB_MAX_IDLE_CONNS", "5")
+	t.Setenv("MAIL_DRIVER", "smtp")
+	t.Setenv("TRACING_EXPORTER", "otlp")
+	t.Setenv("TRACING_ENDPOINT", "localhost:4318")
+	t.Setenv("TRACING_SAMPLE_RATIO", "2")
+	t.Setenv("SERVER_TRUSTED_PROXIES", "proxy.internal")
+	_, _, err := config.Load([]string{"-keys-dir", ""})
+	if err == nil {
+		t.Fatal("Expected an invalid config to be rejected")
+	}
+	for _, want := range []string{"keys directory is required", "sslmode \"sometimes\"", "cannot exceed max open", "SMTP host is required",
+		"tracing endpoint \"localhost:4318\"", "sample ratio 2", "trusted proxy \"proxy.internal\""} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+
+	t.Setenv("DB_PORT", "five")
+	if _, _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "DB_PORT") {
+		t.Errorf("Expected a malformed number to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// FakeEmailChangeRepository keeps email changes in memory and applies them
+// to Users, rejecting addresses listed in Taken like the unique index would.
+type FakeEmailChangeRepository struct {
+	Changes map[string]*models.EmailChange
+	Users   map[string]*models.User
+	Taken   map[string]bool
+}
+
+func NewFakeEmailChangeRepository(users ...*models.User) *FakeEmailChangeRepository {
+	r := &FakeEmailChangeRepository{
+		Changes: map[string]*models.EmailChange{},
+		Users:   map[string]*models.User{},
+		Taken:   map[string]bool{},
+	}
+	for _, u := range users {
+		r.Users[u.UserId] = u
+	}
+	return r
+}
+
+func (r *FakeEmailChangeRepository) CreateEmailChange(ctx context.Context, change *models.EmailChange) error {
+	now := time.Now()
+	for _, other := range r.Changes {
+		if other.UserId == change.UserId && other.ConfirmedAt == nil && other.CancelledAt == nil {
+			other.CancelledAt = &now
+		}
+	}
+	change.Id = "email-change-" + change.TokenHash[:8]
+	change.CreatedAt = now
+	r.Changes[change.Id] = change
+	return nil
+}
+
+func (r *FakeEmailChangeRepository) find(match func(*models.EmailChange) bool) (*models.EmailChange, error) {
+	for _, change := range r.Changes {
+		if match(change) {
+			copied := *change
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *FakeEmailChangeRepository) GetEmailChangeByHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	return r.find(func(change *models.EmailChange) bool { return change.TokenHash == hash })
+}
+
+func (r *FakeEmailChangeRepository) GetEmailChangeByCancelHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	return r.find(func(change *models.EmailChange) bool { return change.CancelTokenHash == hash })
+}
+
+func (r *FakeEmailChangeRepository) ApplyEmailChange(ctx context.Context, change *models.EmailChange) (bool, error) {
+	stored := r.Changes[change.Id]
+	if stored.ConfirmedAt != nil || stored.CancelledAt != nil {
+		return false, nil
+	}
+	if r.Taken[change.NewEmail] {
+		return false, gorm.ErrDuplicatedKey
+	}
+	u := r.Users[change.UserId]
+	if u.Email != change.OldEmail {
+		return false, nil
+	}
+	now := time.Now()
+	stored.ConfirmedAt = &now
+	u.Email = change.NewEmail
+	u.EmailVerifiedAt = &now
+	return true, nil
+}
+
+func (r *FakeEmailChangeRepository) CancelEmailChange(ctx context.Context, change *models.EmailChange) (bool, bool, error) {
+	stored := r.Changes[change.Id]
+	if stored.CancelledAt != nil {
+		return false, false, nil
+	}
+	now := time.Now()
+	stored.CancelledAt = &now
+	u := r.Users[change.UserId]
+	if stored.ConfirmedAt == nil || u.Email != change.NewEmail {
+		return true, false, nil
+	}
+	u.Email = change.OldEmail
+	return true, true, nil
+}
+
+var emailChangeLink = regexp.MustCompile(`email-change\?token=([A-Za-z0-9_-]+)`)
+
+func TestEmailChangeFlow(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "change-user", FirstName: "John", Email: "john@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserById", "change-user").Return(user, nil)
+	userRepo.On("GetUserByEmail", "taken@example.com").Return(&models.User{UserId: "other-user"}, nil)
+	userRepo.On("GetUserByEmail", mock.AnythingOfType("string")).Return((*models.User)(nil), gorm.ErrRecordNotFound)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("RevokeUserRefreshTokens", "change-user", "").Return(nil)
+	repo := NewFakeEmailChangeRepository(user)
+	mailer := &RecordingMailSender{}
+	personalTokens := NewFakePersonalAccessTokenRepository()
+	personalTokens.CreatePersonalAccessToken(context.Background(), &models.PersonalAccessToken{UserId: "change-user", Name: "ci"})
+	personalTokens.CreatePersonalAccessToken(context.Background(), &models.PersonalAccessToken{UserId: "other-user", Name: "deploy"})
+	service := services.NewEmailChangeService(repo, userRepo, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), personalTokens, mailer, testAppURL, logging.Discard())
+
+	_, err := service.RequestEmailChange(context.Background(), "change-user", &dto.ChangeEmailRequest{NewEmail: "new@example.com", Password: "wrong"})
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a wrong password to be rejected with %d, got %v", http.StatusBadRequest, err)
+	}
+	_, err = service.RequestEmailChange(context.Background(), "change-user", &dto.ChangeEmailRequest{NewEmail: "taken@example.com", Password: "password123"})
+	if err == nil || err.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected an address in use to be rejected with %d, got %v", http.StatusConflict, err)
+	}
+
+	// Another account claims the address between request and confirmation
+	if _, err := service.RequestEmailChange(context.Background(), "change-user", &dto.ChangeEmailRequest{NewEmail: "race@example.com", Password: "password123"}); err != nil {
+		t.Fatalf("Expected email change request to succeed, got %v", err)
+	}
+	if len(mailer.Messages) != 2 || mailer.Messages[0].To != "race@example.com" || mailer.Messages[1].To != "john@example.com" {
+		t.Fatalf("Expected a confirmation to the new address and a notice to the old one, got %+v", mailer.Messages)
+	}
+	raceToken := emailChangeLink.FindStringSubmatch(mailer.Messages[0].Body)[1]
+	repo.Taken["race@example.com"] = true
+	err = service.ConfirmEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: raceToken})
+	if err == nil || err.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected a claimed address to be rejected with %d, got %v", http.StatusConflict, err)
+	}
+	if user.Email != "john@example.com" {
+		t.Fatalf("Expected the email to be unchanged, got %s", user.Email)
+	}
+
+	// A new request replaces the pending one
+	mailer.Messages = nil
+	// Addresses are stored lower-cased, so the unique index on lower(email)
+	// catches accounts differing only in case
+	resp, err := service.RequestEmailChange(context.Background(), "change-user", &dto.ChangeEmailRequest{NewEmail: " New@Example.com", Password: "password123"})
+	if err != nil || resp.NewEmail != "new@example.com" || mailer.Messages[0].To != "new@example.com" {
+		t.Fatalf("Expected email change request to succeed for the lower-cased address, got %v", err)
+	}
+	if user.Email != "john@example.com" {
+		t.Fatal("Expected the email to change only after confirmation")
+	}
+	confirmToken := emailChangeLink.FindStringSubmatch(mailer.Messages[0].Body)[1]
+	cancelToken := emailChangeLink.FindStringSubmatch(mailer.Messages[1].Body)[1]
+	if err := service.ConfirmEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: raceToken}); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a replaced request to be rejected with %d, got %v", http.StatusBadRequest, err)
+	}
+	if err := service.ConfirmEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: confirmToken}); err != nil {
+		t.Fatalf("Expected confirmation to succeed, got %v", err)
+	}
+	if user.Email != "new@example.com" || user.EmailVerifiedAt == nil {
+		t.Fatalf("Expected the verified new address to be applied, got %s", user.Email)
+	}
+	if err := service.ConfirmEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: confirmToken}); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a used confirmation link to be rejected with %d, got %v", http.StatusBadRequest, err)
+	}
+
+	if len(personalTokens.Tokens) != 2 {
+		t.Fatalf("Expected confirming a change to keep the personal access tokens, got %d", len(personalTokens.Tokens))
+	}
+
+	// The old address can still undo the change, which signs out every
+	// session and revokes the personal access tokens
+	if err := service.CancelEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: cancelToken}); err != nil {
+		t.Fatalf("Expected cancellation to succeed, got %v", err)
+	}
+	if user.Email != "john@example.com" {
+		t.Fatalf("Expected the old address to be restored, got %s", user.Email)
+	}
+	refreshRepo.AssertCalled(t, "RevokeUserRefreshTokens", "change-user", "")
+	if len(personalTokens.Tokens) != 1 || personalTokens.Tokens[0].UserId != "other-user" {
+		t.Errorf("Expected only the user's personal access tokens to be revoked, got %+v", personalTokens.Tokens)
+	}
+	if err := service.CancelEmailChange(context.Background(), &dto.EmailChangeTokenRequest{Token: cancelToken}); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a used cancel link to be rejected with %d, got %v", http.StatusBadRequest, err)
+	}
+}
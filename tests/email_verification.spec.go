@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUnverifiedUserCannotCreateOrganization(t *testing.T) {
+	s := setupServer()
+	verifiedAt := time.Now()
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserById", "unverified-user").Return(&models.User{UserId: "unverified-user"}, nil)
+	userRepo.On("GetUserById", "verified-user").Return(&models.User{UserId: "verified-user", EmailVerifiedAt: &verifiedAt}, nil)
+	userService := services.NewUserService(userRepo, services.EmailVerificationRestricted, logging.Discard())
+
+	createOrganization := func(userId string) int {
+		r := gin.New()
+		r.POST("/api/organisations", func(c *gin.Context) {
+			c.Set("userId", userId)
+		}, middleware.RequireVerifiedEmail(userService), s.CreateOrganizationHandler)
+		reqBodyJSON, _ := json.Marshal(&dto.CreateOrganizationRequest{Name: "Test Organization"})
+		req, _ := http.NewRequest("POST", "/api/organisations", bytes.NewBuffer(reqBodyJSON))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := createOrganization("unverified-user"); code != http.StatusForbidden {
+		t.Fatalf("Expected unverified user to get %d, got %d", http.StatusForbidden, code)
+	}
+	if code := createOrganization("verified-user"); code != http.StatusCreated {
+		t.Fatalf("Expected verified user to get %d, got %d", http.StatusCreated, code)
+	}
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// FakeInvitationRepository keeps invitations and the memberships created by
+// accepting them in memory.
+type FakeInvitationRepository struct {
+	Invitations map[string]*models.Invitation
+	Members     []*models.UserOrganization
+}
+
+func NewFakeInvitationRepository() *FakeInvitationRepository {
+	return &FakeInvitationRepository{Invitations: map[string]*models.Invitation{}}
+}
+
+func (r *FakeInvitationRepository) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	invitation.Id = "invitation-" + invitation.TokenHash[:8]
+	invitation.CreatedAt = time.Now()
+	r.Invitations[invitation.Id] = invitation
+	return nil
+}
+
+func (r *FakeInvitationRepository) GetInvitationByHash(ctx context.Context, hash string) (*models.Invitation, error) {
+	for _, invitation := range r.Invitations {
+		if invitation.TokenHash == hash {
+			return invitation, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *FakeInvitationRepository) GetPendingInvitations(ctx context.Context, orgId string) ([]*models.Invitation, error) {
+	var pending []*models.Invitation
+	for _, invitation := range r.Invitations {
+		if invitation.OrgId == orgId && invitation.AcceptedAt == nil {
+			pending = append(pending, invitation)
+		}
+	}
+	return pending, nil
+}
+
+func (r *FakeInvitationRepository) DeletePendingInvitations(ctx context.Context, orgId string, email string) error {
+	for id, invitation := range r.Invitations {
+		if invitation.OrgId == orgId && invitation.Email == email && invitation.AcceptedAt == nil {
+			delete(r.Invitations, id)
+		}
+	}
+	return nil
+}
+
+func (r *FakeInvitationRepository) DeleteInvitation(ctx context.Context, orgId string, id string) (bool, error) {
+	invitation, ok := r.Invitations[id]
+	if !ok || invitation.OrgId != orgId || invitation.AcceptedAt != nil {
+		return false, nil
+	}
+	delete(r.Invitations, id)
+	return true, nil
+}
+
+func (r *FakeInvitationRepository) AcceptInvitation(ctx context.Context, invitation *models.Invitation, userId string) (bool, error) {
+	if invitation.AcceptedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	r.Members = append(r.Members, &models.UserOrganization{OrgId: invitation.OrgId, UserId: userId, Role: invitation.Role})
+	return true, nil
+}
+
+func TestInvitationAcceptedAtLogin(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	invitee := &models.User{UserId: "invitee", Email: "invitee@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "invitee@example.com").Return(invitee, nil)
+	userRepo.On("GetUserByEmail", "other@example.com").Return((*models.User)(nil), gorm.ErrRecordNotFound)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("GetOrganizationById", "admin", "org-1").Return(&models.Organization{OrgId: "org-1", Name: "Acme", Role: models.RoleAdmin}, nil)
+	orgRepo.On("GetOrganizationById", "member", "org-1").Return(&models.Organization{OrgId: "org-1", Name: "Acme", Role: models.RoleMember}, nil)
+	orgRepo.On("IsUserInOrganization", "invitee", "org-1").Return(false, nil)
+	orgRepo.On("IsMfaRequiredForUser", "invitee").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+
+	invitationRepo := NewFakeInvitationRepository()
+	mailer := &RecordingMailSender{}
+	invitationService := services.NewInvitationService(invitationRepo, orgRepo, userRepo, mailer, testAppURL, logging.Discard())
+	authService := services.NewAuthService(userRepo, services.NewOrganizationService(orgRepo), refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), mailer, nil, invitationService, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+
+	if _, err := invitationService.CreateInvitation(context.Background(), "member", "org-1", &dto.CreateInvitationRequest{Email: "invitee@example.com"}); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a member to be forbidden from inviting, got %v", err)
+	}
+	invitation, err := invitationService.CreateInvitation(context.Background(), "admin", "org-1", &dto.CreateInvitationRequest{Email: "invitee@example.com", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatalf("Expected an admin to invite, got %v", err)
+	}
+	if len(mailer.Messages) != 1 || mailer.Messages[0].To != "invitee@example.com" {
+		t.Fatal("Expected an invitation email to be sent")
+	}
+	token := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(mailer.Messages[0].Body)[1]
+
+	// The token only works for the invited address
+	if _, err := invitationService.CheckInvitation(context.Background(), "other@example.com", token); err == nil {
+		t.Fatal("Expected an invitation to be bound to its email address")
+	}
+
+	login, err := authService.Login(context.Background(), &dto.LoginRequest{Email: "invitee@example.com", Password: "password123", InviteToken: token})
+	if err != nil {
+		t.Fatalf("Expected login with an invitation to succeed, got %v", err)
+	}
+	if login.JoinedOrganization == nil || login.JoinedOrganization.OrgId != "org-1" || login.JoinedOrganization.Role != models.RoleAdmin {
+		t.Fatalf("Expected the login to join org-1 as admin, got %+v", login.JoinedOrganization)
+	}
+	if len(invitationRepo.Members) != 1 || invitationRepo.Members[0].UserId != "invitee" {
+		t.Fatal("Expected a membership to be created for the invitee")
+	}
+
+	_, err = authService.Login(context.Background(), &dto.LoginRequest{Email: "invitee@example.com", Password: "password123", InviteToken: token})
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected an accepted invitation to be rejected, got %v", err)
+	}
+	if err := invitationService.RevokeInvitation(context.Background(), "admin", "org-1", invitation.Id); err == nil || err.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected an accepted invitation not to be revocable, got %v", err)
+	}
+
+	pending, _ := invitationService.CreateInvitation(context.Background(), "admin", "org-1", &dto.CreateInvitationRequest{Email: "other@example.com"})
+	if err := invitationService.RevokeInvitation(context.Background(), "admin", "org-1", pending.Id); err != nil {
+		t.Fatalf("Expected a pending invitation to be revoked, got %v", err)
+	}
+	if list, _ := invitationService.GetPendingInvitations(context.Background(), "admin", "org-1"); len(list) != 0 {
+		t.Fatalf("Expected no pending invitations, got %d", len(list))
+	}
+}
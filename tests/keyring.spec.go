@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/keyring"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRotationAndJwks(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := keyring.Rotate(dir, keyring.AlgRS256, 2); err != nil {
+		t.Fatalf("Error creating key: %v", err)
+	}
+	keys, err := keyring.Load(dir)
+	if err != nil {
+		t.Fatalf("Error loading keyring: %v", err)
+	}
+	oldToken, _ := services.GenerateJWT(keys, "1")
+
+	next, err := keyring.Rotate(dir, keyring.AlgEdDSA, 2)
+	if err != nil {
+		t.Fatalf("Error rotating key: %v", err)
+	}
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("Error reloading keyring: %v", err)
+	}
+	newToken, _ := services.GenerateJWT(keys, "1")
+
+	// Tokens signed before the rotation stay valid until they expire
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := keys.Parse(token); err != nil {
+			t.Fatalf("Expected token to verify after rotation, got %v", err)
+		}
+	}
+
+	s := &server.Server{Keys: keys}
+	r := gin.New()
+	r.GET("/.well-known/jwks.json", s.JWKSHandler)
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code to be %d, got %d", http.StatusOK, rr.Code)
+	}
+	var set keyring.JWKS
+	json.Unmarshal(rr.Body.Bytes(), &set)
+	if len(set.Keys) != 2 || set.Keys[0].Kid != next.Kid || set.Keys[0].Kty != "OKP" {
+		t.Fatalf("Expected the active EdDSA key first in the JWKS, got %+v", set.Keys)
+	}
+
+	// A verifier holding only the published key accepts the new token
+	x, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	parts := strings.Split(newToken, ".")
+	if err := keyring.SigningMethodEdDSA.Verify(parts[0]+"."+parts[1], parts[2], ed25519.PublicKey(x)); err != nil {
+		t.Fatalf("Expected the JWKS key to verify the token, got %v", err)
+	}
+
+	// Keys beyond the retention window are dropped
+	keyring.Rotate(dir, keyring.AlgEdDSA, 1)
+	keys.Reload()
+	if _, err := keys.Parse(oldToken); err == nil {
+		t.Fatal("Expected a token signed by a retired key to be rejected")
+	}
+}
+
+// TestKeyRotationWithoutRestart checks that a running keyring signs with a
+// key rotated in on disk without being reloaded by hand.
+func TestKeyRotationWithoutRestart(t *testing.T) {
+	dir := t.TempDir()
+	first, err := keyring.Rotate(dir, keyring.AlgRS256, 1)
+	if err != nil {
+		t.Fatalf("Error creating key: %v", err)
+	}
+	keys, err := keyring.Load(dir)
+	if err != nil {
+		t.Fatalf("Error loading keyring: %v", err)
+	}
+	kid := func(token string) string {
+		parsed, err := keys.Parse(token)
+		if err != nil {
+			t.Fatalf("Expected token to verify, got %v", err)
+		}
+		return parsed.Header["kid"].(string)
+	}
+	token, _ := services.GenerateJWT(keys, "1")
+	if got := kid(token); got != first.Kid {
+		t.Fatalf("Expected the token to be signed with %s, got %s", first.Kid, got)
+	}
+
+	next, err := keyring.Rotate(dir, keyring.AlgEdDSA, 1)
+	if err != nil {
+		t.Fatalf("Error rotating key: %v", err)
+	}
+	// The manifest is only looked at every couple of seconds
+	token, _ = services.GenerateJWT(keys, "1")
+	if got := kid(token); got != first.Kid {
+		t.Fatalf("Expected the old key to sign until the next check, got %s", got)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	token, _ = services.GenerateJWT(keys, "1")
+	if got := kid(token); got != next.Kid {
+		t.Fatalf("Expected the rotated key %s to sign, got %s", next.Kid, got)
+	}
+	if set := keys.JWKS(); len(set.Keys) != 2 || set.Keys[0].Kid != next.Kid {
+		t.Fatalf("Expected the JWKS to publish the rotated key first, got %+v", set.Keys)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/config"
+	"h-two/internal/database"
+	"h-two/internal/server"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newPingableServer returns a server whose database only answers the pings
+// and close the test expects.
+func newPingableServer(t *testing.T) (*server.Server, sqlmock.Sqlmock) {
+	db, sqlMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open stub database: %v", err)
+	}
+	// gorm pings once when it opens the connection
+	sqlMock.ExpectPing()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Server.DrainDelay = 0
+	cfg.Server.ShutdownTimeout = config.Duration(5 * time.Second)
+	return &server.Server{Config: cfg, Keys: testKeys, Db: &database.DbService{Db: gdb}}, sqlMock
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	s, sqlMock := newPingableServer(t)
+	router := s.RegisterRoutes()
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to pass, got %d", code)
+	}
+	sqlMock.ExpectPing()
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected readiness to pass with a reachable database, got %d", code)
+	}
+	sqlMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail with an unreachable database, got %d", code)
+	}
+
+	// Draining fails readiness without touching the database, while the
+	// process stays live
+	s.BeginDrain()
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail while draining, got %d", code)
+	}
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to pass while draining, got %d", code)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected database use: %v", err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	s, sqlMock := newPingableServer(t)
+	jobStopped := make(chan struct{})
+	s.BackgroundJobs = append(s.BackgroundJobs, func(stop <-chan struct{}) {
+		<-stop
+		close(jobStopped)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+
+	// A slow readiness check is in flight when the shutdown signal arrives
+	sqlMock.ExpectPing().WillDelayFor(300 * time.Millisecond)
+	sqlMock.ExpectClose()
+	response := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/readyz")
+		if err != nil {
+			response <- 0
+			return
+		}
+		resp.Body.Close()
+		response <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if code := <-response; code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", code)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not shut down")
+	}
+	select {
+	case <-jobStopped:
+	default:
+		t.Error("Expected background jobs to be stopped")
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected the database pool to be closed: %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/healthz"); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/config"
+	"h-two/internal/dto"
+	"h-two/internal/errors"
+	"h-two/internal/helpers"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLines decodes the JSON lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("Expected JSON log lines, got %q", line)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func TestLogRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(config.LogConfig{Level: "debug", Format: "json"}, &buf)
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+
+	logger.InfoContext(ctx, "Login attempt for jane.doe@example.com",
+		"password", "hunter2",
+		"refresh_token", "secret-refresh",
+		"email", "jane.doe@example.com",
+		"statusCode", 401,
+		"request", &dto.LoginRequest{Email: "jane.doe@example.com", Password: "hunter2"},
+		"error", fmt.Errorf("no user jane.doe@example.com"))
+	logger.With("newPassword", "hunter3").DebugContext(ctx, "Changed")
+
+	raw := buf.String()
+	for _, leaked := range []string{"hunter2", "hunter3", "secret-refresh", "jane.doe@"} {
+		if strings.Contains(raw, leaked) {
+			t.Errorf("Expected %q to be redacted, got %s", leaked, raw)
+		}
+	}
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+	line := lines[0]
+	if line["requestId"] != "req-1" || line["msg"] != "Login attempt for ***@example.com" {
+		t.Errorf("Expected the request ID and a masked message, got %v", line)
+	}
+	if line["password"] != "[REDACTED]" || line["email"] != "***@example.com" || line["statusCode"] != float64(401) {
+		t.Errorf("Unexpected attributes %v", line)
+	}
+	if request := line["request"].(map[string]interface{}); request["password"] != "[REDACTED]" || request["email"] != "***@example.com" {
+		t.Errorf("Expected struct fields to be redacted, got %v", request)
+	}
+
+	// Queries are logged without their bound values
+	buf.Reset()
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logging.NewGormLogger(logger, 0)})
+	sqlMock.ExpectQuery(`SELECT`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("u1"))
+	var user models.User
+	gdb.WithContext(ctx).Where("email = ?", "jane.doe@example.com").First(&user)
+	lines = logLines(t, &buf)
+	if len(lines) != 1 || !strings.Contains(lines[0]["sql"].(string), "email = $1") || lines[0]["requestId"] != "req-1" {
+		t.Errorf("Expected the parameterized query with the request ID, got %v", lines)
+	}
+	if strings.Contains(buf.String(), "example.com") {
+		t.Errorf("Expected no bound values in the query log, got %s", buf.String())
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(config.LogConfig{Level: "info", Format: "json"}, &buf)
+	r := gin.New()
+	r.Use(middleware.RequestID(), middleware.AccessLog(logger), middleware.Recovery(logger))
+	r.GET("/missing", func(c *gin.Context) {
+		helpers.AbortWithError(c, &errors.ApiError{Status: "Not found", Message: "Nothing here", StatusCode: http.StatusNotFound})
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	get := func(path string, requestId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if requestId != "" {
+			req.Header.Set("X-Request-ID", requestId)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/missing", "client-id-42")
+	var body errors.ApiError
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if rr.Header().Get("X-Request-ID") != "client-id-42" || body.RequestId != "client-id-42" {
+		t.Errorf("Expected the client's request ID in the header and error, got %q and %+v", rr.Header().Get("X-Request-ID"), body)
+	}
+	lines := logLines(t, &buf)
+	if len(lines) != 1 || lines[0]["requestId"] != "client-id-42" || lines[0]["route"] != "/missing" || lines[0]["status"] != float64(http.StatusNotFound) {
+		t.Errorf("Expected an access log line stamped with the request ID, got %v", lines)
+	}
+
+	// A missing or malformed ID is replaced with a generated one
+	for _, given := range []string{"", "bad id\ninjected"} {
+		rr = get("/missing", given)
+		if id := rr.Header().Get("X-Request-ID"); id == "" || id == given || strings.ContainsAny(id, " \n") {
+			t.Errorf("Expected a generated request ID for %q, got %q", given, id)
+		}
+	}
+
+	buf.Reset()
+	rr = get("/panic", "panic-id")
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if rr.Code != http.StatusInternalServerError || body.RequestId != "panic-id" {
+		t.Errorf("Expected a 500 carrying the request ID, got %d %+v", rr.Code, body)
+	}
+	lines = logLines(t, &buf)
+	if len(lines) != 2 || lines[0]["msg"] != "Request panicked" || lines[1]["level"] != "ERROR" {
+		t.Errorf("Expected the panic and the failed request to be logged, got %v", lines)
+	}
+}
@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/config"
+	"h-two/internal/errors"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newLimitedLoginServer returns a server whose login is rate limited, for a
+// single known user with password "password123".
+func newLimitedLoginServer(limits services.LoginLimits) (*server.Server, services.LoginLimiter, *repository.InMemoryLoginThrottleRepository) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "limited-user", Email: "limited@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "limited@example.com").Return(user, nil)
+	userRepo.On("GetUserByEmail", mock.AnythingOfType("string")).Return((*models.User)(nil), gorm.ErrRecordNotFound)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "limited-user").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	throttles := repository.NewInMemoryLoginThrottleRepository()
+	limiter := services.NewLoginLimiter(throttles, limits, logging.Discard())
+	authService := services.NewAuthService(userRepo, services.NewOrganizationService(orgRepo), refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, NewFakeLoginEventRepository(), limiter, PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	s := &server.Server{AuthService: authService, LoginLimiter: limiter, Keys: testKeys, Logger: logging.Discard()}
+	return s, limiter, throttles
+}
+
+// newLimitedLoginRouter serves /auth/login behind the rate limit.
+func newLimitedLoginRouter(limits services.LoginLimits) (*gin.Engine, services.LoginLimiter, *repository.InMemoryLoginThrottleRepository) {
+	s, limiter, throttles := newLimitedLoginServer(limits)
+	r := gin.New()
+	r.POST("/auth/login", middleware.LoginRateLimit(limiter), s.LoginHandler)
+	return r, limiter, throttles
+}
+
+func postLogin(r http.Handler, email string, password string, clientIp string) *httptest.ResponseRecorder {
+	return postLoginForwarded(r, email, password, clientIp, "")
+}
+
+// postLoginForwarded is postLogin through a proxy claiming the request came
+// from forwardedFor.
+func postLoginForwarded(r http.Handler, email string, password string, clientIp string, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(`{"email":"`+email+`","password":"`+password+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	req.RemoteAddr = clientIp + ":40000"
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// retryAfter checks rr is a 429 and returns its Retry-After in seconds.
+func retryAfter(t *testing.T, rr *httptest.ResponseRecorder) int {
+	t.Helper()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusTooManyRequests, rr.Code, rr.Body.String())
+	}
+	seconds, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("Expected a Retry-After header in seconds, got %q", rr.Header().Get("Retry-After"))
+	}
+	var body errors.ApiError
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if body.StatusCode != http.StatusTooManyRequests || body.RetryAfter != seconds {
+		t.Errorf("Expected the body to repeat the status and Retry-After, got %+v", body)
+	}
+	return seconds
+}
+
+func TestLoginLockout(t *testing.T) {
+	r, limiter, throttles := newLimitedLoginRouter(services.LoginLimits{
+		IpAttempts:    100,
+		IpWindow:      time.Minute,
+		MaxFailures:   3,
+		FailureWindow: time.Minute,
+		Lockout:       time.Minute,
+		MaxLockout:    3 * time.Minute,
+	})
+	ctx := context.Background()
+	fail := func(email string) {
+		for i := 0; i < 3; i++ {
+			if rr := postLogin(r, email, "wrong-password", "198.51.100.1"); rr.Code != http.StatusUnauthorized {
+				t.Fatalf("Expected a wrong password to fail with %d, got %d", http.StatusUnauthorized, rr.Code)
+			}
+		}
+	}
+
+	fail("limited@example.com")
+	if seconds := retryAfter(t, postLogin(r, "limited@example.com", "password123", "198.51.100.1")); seconds < 55 || seconds > 60 {
+		t.Errorf("Expected the first lockout to last a minute, got %ds", seconds)
+	}
+	// The address is normalized, so changing its case does not help
+	retryAfter(t, postLogin(r, "LIMITED@example.com", "password123", "198.51.100.1"))
+
+	// Each further lockout doubles, up to the maximum
+	for i, want := range []int{120, 180} {
+		// Let the previous lockout run out
+		throttles.Lock(ctx, "account:limited@example.com", time.Now().Add(-time.Second), i+1)
+		fail("limited@example.com")
+		if seconds := retryAfter(t, postLogin(r, "limited@example.com", "password123", "198.51.100.1")); seconds < want-5 || seconds > want {
+			t.Errorf("Expected a lockout of %ds, got %ds", want, seconds)
+		}
+	}
+
+	if apiErr := limiter.Unlock(ctx, "limited@example.com"); apiErr != nil {
+		t.Fatalf("Expected the unlock to succeed, got %v", apiErr)
+	}
+	if rr := postLogin(r, "limited@example.com", "password123", "198.51.100.1"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed once unlocked, got %d", rr.Code)
+	}
+
+	// Unknown addresses lock out the same way, so lockouts do not reveal
+	// which accounts exist
+	fail("nobody@example.com")
+	retryAfter(t, postLogin(r, "nobody@example.com", "wrong-password", "198.51.100.1"))
+
+	// A successful login clears earlier failures
+	postLogin(r, "limited@example.com", "wrong-password", "198.51.100.1")
+	postLogin(r, "limited@example.com", "wrong-password", "198.51.100.1")
+	postLogin(r, "limited@example.com", "password123", "198.51.100.1")
+	if entry, _ := throttles.GetThrottle(ctx, "account:limited@example.com"); entry != nil {
+		t.Errorf("Expected the failures to be cleared, got %+v", entry)
+	}
+}
+
+func TestLoginIpRateLimit(t *testing.T) {
+	r, limiter, _ := newLimitedLoginRouter(services.LoginLimits{
+		IpAttempts:    2,
+		IpWindow:      time.Minute,
+		MaxFailures:   100,
+		FailureWindow: time.Minute,
+		Lockout:       time.Minute,
+		MaxLockout:    time.Hour,
+	})
+	for i := 0; i < 2; i++ {
+		if rr := postLogin(r, "limited@example.com", "wrong-password", "203.0.113.9"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected attempts within the limit to reach the login, got %d", rr.Code)
+		}
+	}
+	if seconds := retryAfter(t, postLogin(r, "limited@example.com", "password123", "203.0.113.9")); seconds < 1 || seconds > 60 {
+		t.Errorf("Expected to retry once the window ends, got %ds", seconds)
+	}
+	if rr := postLogin(r, "limited@example.com", "password123", "203.0.113.10"); rr.Code != http.StatusOK {
+		t.Errorf("Expected other addresses to be unaffected, got %d", rr.Code)
+	}
+
+	if purged, err := limiter.PurgeStaleThrottles(context.Background()); err != nil || purged != 0 {
+		t.Errorf("Expected recent throttles to be kept, purged %d (%v)", purged, err)
+	}
+}
+
+// TestLoginIpRateLimitBehindProxies checks that X-Forwarded-For only picks
+// the client address when the request comes through a trusted proxy.
+func TestLoginIpRateLimitBehindProxies(t *testing.T) {
+	limits := services.LoginLimits{
+		IpAttempts:    2,
+		IpWindow:      time.Minute,
+		MaxFailures:   100,
+		FailureWindow: time.Minute,
+		Lockout:       time.Minute,
+		MaxLockout:    time.Hour,
+	}
+	s, _, _ := newLimitedLoginServer(limits)
+	s.Config = config.Default()
+	r := s.RegisterRoutes()
+	for i := 0; i < 2; i++ {
+		if rr := postLoginForwarded(r, "limited@example.com", "wrong-password", "203.0.113.9", "198.51.100."+strconv.Itoa(i)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected attempts within the limit to reach the login, got %d", rr.Code)
+		}
+	}
+	retryAfter(t, postLoginForwarded(r, "limited@example.com", "password123", "203.0.113.9", "198.51.100.99"))
+
+	s, _, _ = newLimitedLoginServer(limits)
+	s.Config = config.Default()
+	s.Config.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	r = s.RegisterRoutes()
+	for i := 0; i < 2; i++ {
+		postLoginForwarded(r, "limited@example.com", "wrong-password", "10.0.0.5", "198.51.100.1")
+	}
+	retryAfter(t, postLoginForwarded(r, "limited@example.com", "password123", "10.0.0.5", "198.51.100.1"))
+	if rr := postLoginForwarded(r, "limited@example.com", "password123", "10.0.0.5", "198.51.100.2"); rr.Code != http.StatusOK {
+		t.Errorf("Expected clients behind a trusted proxy to have their own budget, got %d", rr.Code)
+	}
+	for i := 0; i < 2; i++ {
+		postLoginForwarded(r, "limited@example.com", "wrong-password", "203.0.113.20", "198.51.100."+strconv.Itoa(10+i))
+	}
+	retryAfter(t, postLoginForwarded(r, "limited@example.com", "password123", "203.0.113.20", "198.51.100.12"))
+}
+
+func TestLoginThrottleRepository(t *testing.T) {
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	repo := repository.NewLoginThrottleRepository(gdb)
+	ctx := context.Background()
+
+	// Attempts are counted by a single upsert
+	windowEnds := time.Now().Add(time.Minute)
+	sqlMock.ExpectQuery(`INSERT INTO login_throttles .* ON CONFLICT \(key\) DO UPDATE .* RETURNING \*`).
+		WithArgs("ip:192.0.2.1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "attempts", "window_ends", "lockouts", "locked_until", "updated_at"}).
+			AddRow("ip:192.0.2.1", 3, windowEnds, 0, nil, time.Now()))
+	entry, err := repo.Hit(ctx, "ip:192.0.2.1", time.Minute)
+	if err != nil || entry.Attempts != 3 || !entry.WindowEnds.Equal(windowEnds) {
+		t.Fatalf("Expected the updated entry, got %+v (%v)", entry, err)
+	}
+
+	sqlMock.ExpectQuery(`SELECT \* FROM "login_throttles" WHERE key = \$1`).
+		WithArgs("account:nobody@example.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	if entry, err := repo.GetThrottle(ctx, "account:nobody@example.com"); entry != nil || err != nil {
+		t.Errorf("Expected no entry for an unknown key, got %+v (%v)", entry, err)
+	}
+
+	until := time.Now().Add(time.Minute)
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "login_throttles" SET "attempts"=\$1,"locked_until"=\$2,"lockouts"=\$3,"window_ends"=\$4,"updated_at"=\$5 WHERE key = \$6`).
+		WithArgs(0, until, 2, sqlmock.AnyArg(), sqlmock.AnyArg(), "account:limited@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	if err := repo.Lock(ctx, "account:limited@example.com", until, 2); err != nil {
+		t.Errorf("Expected the lock to be stored, got %v", err)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
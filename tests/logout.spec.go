@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"h-two/internal/dto"
+	"h-two/internal/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func loginForToken(t *testing.T, r *gin.Engine) string {
+	reqBodyJSON, _ := json.Marshal(&dto.LoginRequest{
+		Email:    "john.doe@example.com",
+		Password: "password123",
+	})
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(reqBodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d", rr.Code)
+	}
+	var responseBody map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &responseBody); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	return responseBody["data"].(map[string]interface{})["accessToken"].(string)
+}
+
+func authorizedRequest(r *gin.Engine, method string, path string, token string) int {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestLogoutRevokesAccessToken(t *testing.T) {
+	s := setupServer()
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.POST("/auth/logout", authMiddleware, s.LogoutHandler)
+	r.GET("/api/organisations", authMiddleware, s.GetOrganizationsHandler)
+
+	token := loginForToken(t, r)
+	other := loginForToken(t, r)
+	if code := authorizedRequest(r, "GET", "/api/organisations", token); code != http.StatusOK {
+		t.Fatalf("Expected status code to be %d, got %d", http.StatusOK, code)
+	}
+	if code := authorizedRequest(r, "POST", "/auth/logout", token); code != http.StatusOK {
+		t.Fatalf("Expected logout to return %d, got %d", http.StatusOK, code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", token); code != http.StatusUnauthorized {
+		t.Fatalf("Expected revoked token to be rejected with %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", other); code != http.StatusOK {
+		t.Fatalf("Expected other sessions to survive logout, got %d", code)
+	}
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	s := setupServer()
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.POST("/auth/logout-all", authMiddleware, s.LogoutAllHandler)
+	r.GET("/api/organisations", authMiddleware, s.GetOrganizationsHandler)
+
+	first := loginForToken(t, r)
+	second := loginForToken(t, r)
+	if code := authorizedRequest(r, "POST", "/auth/logout-all", first); code != http.StatusOK {
+		t.Fatalf("Expected logout-all to return %d, got %d", http.StatusOK, code)
+	}
+	for _, token := range []string{first, second} {
+		if code := authorizedRequest(r, "GET", "/api/organisations", token); code != http.StatusUnauthorized {
+			t.Fatalf("Expected token to be rejected with %d, got %d", http.StatusUnauthorized, code)
+		}
+	}
+}
+
+// TestLoginRightAfterLogoutAll checks that a session started in the same
+// second as a logout-all is not caught by it.
+func TestLoginRightAfterLogoutAll(t *testing.T) {
+	s := setupServer()
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.POST("/auth/logout-all", authMiddleware, s.LogoutAllHandler)
+	r.GET("/api/organisations", authMiddleware, s.GetOrganizationsHandler)
+
+	old := loginForToken(t, r)
+	// Start at the beginning of a second so the logout and the next login
+	// share it
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	if code := authorizedRequest(r, "POST", "/auth/logout-all", old); code != http.StatusOK {
+		t.Fatalf("Expected logout-all to return %d, got %d", http.StatusOK, code)
+	}
+	loggedOutAt := time.Now()
+	fresh := loginForToken(t, r)
+	if time.Now().Unix() != loggedOutAt.Unix() {
+		t.Fatal("Expected the login to finish within the same second as the logout")
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", fresh); code != http.StatusOK {
+		t.Fatalf("Expected a session started after logout-all to work, got %d", code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", old); code != http.StatusUnauthorized {
+		t.Fatalf("Expected the earlier session to stay revoked, got %d", code)
+	}
+}
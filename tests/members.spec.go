@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestListOrganizationMembersPagination(t *testing.T) {
+	joined := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	members := []*models.OrganizationMember{
+		{UserId: "u1", FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Role: models.RoleOwner, JoinedAt: joined},
+		{UserId: "u2", FirstName: "Alan", LastName: "Turing", Email: "alan@example.com", Role: models.RoleMember, JoinedAt: joined},
+		{UserId: "u3", FirstName: "Grace", LastName: "Hopper", Email: "grace@example.com", Role: models.RoleMember, JoinedAt: joined},
+	}
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("GetOrganizationById", "u1", "org-1").Return(&models.Organization{OrgId: "org-1", Role: models.RoleOwner}, nil)
+	orgRepo.On("GetOrganizationById", "outsider", "org-1").Return((*models.Organization)(nil), gorm.ErrRecordNotFound)
+	orgRepo.On("ListOrganizationMembers", "org-1", mock.MatchedBy(func(q repository.MemberQuery) bool {
+		return q.After == nil
+	})).Return(members, nil)
+	orgRepo.On("ListOrganizationMembers", "org-1", mock.MatchedBy(func(q repository.MemberQuery) bool {
+		return q.After != nil && q.After.Id == "u2" && q.After.Value == "alan turing"
+	})).Return(members[2:], nil)
+
+	s := &server.Server{OrganizationService: services.NewOrganizationService(orgRepo)}
+	r := gin.New()
+	as := func(userId string) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Set("userId", userId) }
+	}
+	r.GET("/members/:orgId", as("u1"), s.GetOrganizationMembersHandler)
+	r.GET("/outsider/:orgId", as("outsider"), s.GetOrganizationMembersHandler)
+
+	type page struct {
+		Data struct {
+			Members []map[string]interface{} `json:"members"`
+		} `json:"data"`
+		Meta struct {
+			NextCursor *string `json:"nextCursor"`
+			Limit      int     `json:"limit"`
+		} `json:"meta"`
+	}
+	get := func(path string) (int, page) {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var body page
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body
+	}
+
+	code, first := get("/members/org-1?limit=2&q=a")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status code to be %d, got %d", http.StatusOK, code)
+	}
+	if len(first.Data.Members) != 2 || first.Meta.NextCursor == nil || first.Meta.Limit != 2 {
+		t.Fatalf("Expected a full first page with a next cursor, got %+v", first)
+	}
+	if first.Data.Members[0]["role"] != models.RoleOwner {
+		t.Fatalf("Expected member roles in the listing, got %v", first.Data.Members[0])
+	}
+
+	code, second := get("/members/org-1?limit=2&q=a&cursor=" + url.QueryEscape(*first.Meta.NextCursor))
+	if code != http.StatusOK || len(second.Data.Members) != 1 || second.Meta.NextCursor != nil {
+		t.Fatalf("Expected a last page without a next cursor, got %d %+v", code, second)
+	}
+
+	// A cursor cannot be replayed against a different ordering
+	if code, _ := get("/members/org-1?sort=email&cursor=" + url.QueryEscape(*first.Meta.NextCursor)); code != http.StatusBadRequest {
+		t.Fatalf("Expected a mismatched cursor to be rejected, got %d", code)
+	}
+	if code, _ := get("/members/org-1?role=superuser"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected an unknown role filter to be rejected, got %d", code)
+	}
+	if code, _ := get("/outsider/org-1"); code != http.StatusNotFound {
+		t.Fatalf("Expected non-members to get %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestListOrganizationMembersQuery(t *testing.T) {
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	repo := repository.NewOrganizationRepository(gdb)
+
+	// Filters, the keyset condition and the ordering all go into one query
+	sqlMock.ExpectQuery(regexp.QuoteMeta(`FROM "user_organizations" JOIN users ON users.user_id = user_organizations.user_id AND users.deleted_at IS NULL WHERE user_organizations.org_id = $1 AND user_organizations.role = $2 AND (lower(users.first_name) LIKE $3 OR lower(users.last_name) LIKE $4 OR lower(users.first_name || ' ' || users.last_name) LIKE $5 OR lower(users.email) LIKE $6) AND (lower(users.email), users.user_id) < ($7, $8) ORDER BY lower(users.email) DESC, users.user_id DESC LIMIT $9`)).
+		WithArgs("org-1", models.RoleAdmin, `50\%%`, `50\%%`, `50\%%`, `50\%%`, "m@example.com", "u9", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "first_name", "last_name", "email", "role", "joined_at"}).
+			AddRow("u1", "Ada", "Lovelace", "ada@example.com", models.RoleAdmin, time.Now()))
+
+	members, err := repo.ListOrganizationMembers(context.Background(), "org-1", repository.MemberQuery{
+		Page: repository.Page{
+			Sort:  repository.SortEmail,
+			Desc:  true,
+			After: &repository.Cursor{Sort: repository.SortEmail, Value: "m@example.com", Id: "u9"},
+			Limit: 3,
+		},
+		Role:   models.RoleAdmin,
+		Prefix: "50%",
+	})
+	if err != nil {
+		t.Fatalf("Expected the query to succeed, got %v", err)
+	}
+	if len(members) != 1 || members[0].Email != "ada@example.com" || members[0].Role != models.RoleAdmin {
+		t.Fatalf("Expected the member row to be scanned, got %+v", members)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/logging"
+	"h-two/internal/metrics"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHttpAndAuthMetrics(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "metrics-user", Email: "metrics@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "metrics@example.com").Return(user, nil)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "metrics-user").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	authService := services.NewAuthService(userRepo, services.NewOrganizationService(orgRepo), refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	s := &server.Server{AuthService: authService, Keys: testKeys}
+
+	r := gin.New()
+	r.Use(middleware.Metrics())
+	r.POST("/api/login", s.LoginHandler)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	login := func(password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBufferString(`{"email":"metrics@example.com","password":"`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	successes := testutil.ToFloat64(metrics.Logins.WithLabelValues("password", metrics.ResultSuccess))
+	rejections := testutil.ToFloat64(metrics.Logins.WithLabelValues("password", metrics.ResultRejected))
+	ok := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues(http.MethodPost, "/api/login", "200"))
+	unmatched := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues(http.MethodGet, "unmatched", "404"))
+	other := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues("OTHER", "unmatched", "404"))
+
+	if code := login("password123"); code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d", code)
+	}
+	if code := login("wrong-password"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected login to be rejected, got %d", code)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/route", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("SCAN1", "/no/such/route", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("SCAN2", "/no/such/route", nil))
+
+	if got := testutil.ToFloat64(metrics.Logins.WithLabelValues("password", metrics.ResultSuccess)) - successes; got != 1 {
+		t.Errorf("Expected one successful login to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.Logins.WithLabelValues("password", metrics.ResultRejected)) - rejections; got != 1 {
+		t.Errorf("Expected one rejected login to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues(http.MethodPost, "/api/login", "200")) - ok; got != 1 {
+		t.Errorf("Expected one request counted under the route template, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues(http.MethodGet, "unmatched", "404")) - unmatched; got != 1 {
+		t.Errorf("Expected unknown paths to share the unmatched route, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HttpRequests.WithLabelValues("OTHER", "unmatched", "404")) - other; got != 2 {
+		t.Errorf("Expected non-standard methods to share the OTHER method, got %v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		`htwo_http_request_duration_seconds_count{method="POST",route="/api/login"}`,
+		`htwo_bcrypt_duration_seconds_count{operation="verify"}`,
+		`htwo_bcrypt_duration_seconds_count{operation="hash"}`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the scrape to contain %s", want)
+		}
+	}
+	if strings.Contains(body, "/no/such/route") {
+		t.Error("Expected raw paths to stay out of the labels")
+	}
+	if strings.Contains(body, "SCAN1") {
+		t.Error("Expected raw methods to stay out of the labels")
+	}
+}
+
+func TestDatabaseMetrics(t *testing.T) {
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err := gdb.Use(metrics.GormPlugin{}); err != nil {
+		t.Fatalf("Expected the plugin to register, got %v", err)
+	}
+	scrape := func() string {
+		rr := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rr.Body.String()
+	}
+	db.SetMaxOpenConns(3)
+	if err := metrics.RegisterDBStats(db, "metrics_test"); err != nil {
+		t.Fatalf("Expected the pool stats to register, got %v", err)
+	}
+	if err := metrics.RegisterDBStats(db, "metrics_test"); err != nil {
+		t.Errorf("Expected registering the same pool again to succeed, got %v", err)
+	}
+	if !strings.Contains(scrape(), `go_sql_max_open_connections{db_name="metrics_test"} 3`) {
+		t.Error("Expected the pool's stats to be reported")
+	}
+	// A pool opened later under the same name takes over the gauges
+	replacement, _, _ := sqlmock.New()
+	defer replacement.Close()
+	replacement.SetMaxOpenConns(7)
+	if err := metrics.RegisterDBStats(replacement, "metrics_test"); err != nil {
+		t.Fatalf("Expected a new pool to replace the old one, got %v", err)
+	}
+	if body := scrape(); !strings.Contains(body, `go_sql_max_open_connections{db_name="metrics_test"} 7`) ||
+		strings.Contains(body, `go_sql_max_open_connections{db_name="metrics_test"} 3`) {
+		t.Error("Expected only the new pool's stats to be reported")
+	}
+
+	before := testutil.CollectAndCount(metrics.DbQueryDuration)
+	sqlMock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("db-user"))
+	if _, err := repository.NewUserRepository(gdb).GetUserById(context.Background(), "db-user"); err != nil {
+		t.Fatalf("Expected the query to succeed, got %v", err)
+	}
+	if testutil.CollectAndCount(metrics.DbQueryDuration) <= before {
+		t.Error("Expected a query latency series for the users table")
+	}
+
+	body := scrape()
+	for _, want := range []string{
+		`htwo_db_query_duration_seconds_count{operation="query",table="users"}`,
+		`go_sql_open_connections{db_name="metrics_test"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the scrape to contain %s", want)
+		}
+	}
+}
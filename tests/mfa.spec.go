@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"h-two/internal/totp"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// FakeMfaRepository applies MFA changes to users held in memory, so a test
+// can run enrollment and login against the same user record.
+type FakeMfaRepository struct {
+	Users         map[string]*models.User
+	RecoveryCodes map[string]map[string]bool
+}
+
+func NewFakeMfaRepository(users ...*models.User) *FakeMfaRepository {
+	r := &FakeMfaRepository{Users: map[string]*models.User{}, RecoveryCodes: map[string]map[string]bool{}}
+	for _, u := range users {
+		r.Users[u.UserId] = u
+	}
+	return r
+}
+
+func (r *FakeMfaRepository) SetTotpSecret(ctx context.Context, userId string, secret string) error {
+	r.Users[userId].TotpSecret = secret
+	r.Users[userId].TotpLastStep = 0
+	r.Users[userId].MfaEnabledAt = nil
+	return nil
+}
+
+func (r *FakeMfaRepository) EnableTotp(ctx context.Context, userId string) error {
+	now := time.Now()
+	r.Users[userId].MfaEnabledAt = &now
+	return nil
+}
+
+func (r *FakeMfaRepository) DisableTotp(ctx context.Context, userId string) error {
+	r.Users[userId].TotpSecret = ""
+	r.Users[userId].MfaEnabledAt = nil
+	delete(r.RecoveryCodes, userId)
+	return nil
+}
+
+func (r *FakeMfaRepository) AdvanceTotpStep(ctx context.Context, userId string, step int64) (bool, error) {
+	if r.Users[userId].TotpLastStep >= step {
+		return false, nil
+	}
+	r.Users[userId].TotpLastStep = step
+	return true, nil
+}
+
+func (r *FakeMfaRepository) ReplaceRecoveryCodes(ctx context.Context, userId string, hashes []string) error {
+	r.RecoveryCodes[userId] = map[string]bool{}
+	for _, hash := range hashes {
+		r.RecoveryCodes[userId][hash] = true
+	}
+	return nil
+}
+
+func (r *FakeMfaRepository) ConsumeRecoveryCode(ctx context.Context, userId string, hash string) (bool, error) {
+	if !r.RecoveryCodes[userId][hash] {
+		return false, nil
+	}
+	delete(r.RecoveryCodes[userId], hash)
+	return true, nil
+}
+
+func TestTotpEnrollmentAndMfaLogin(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "mfa-user", Email: "mfa@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserById", "mfa-user").Return(user, nil)
+	userRepo.On("GetUserByEmail", "mfa@example.com").Return(user, nil)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "mfa-user").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.MatchedBy(func(token *models.RefreshToken) bool {
+		return token.Mfa
+	})).Return(nil)
+
+	orgService := services.NewOrganizationService(orgRepo)
+	mfaService := services.NewMfaService(userRepo, NewFakeMfaRepository(user), orgService, "h-two")
+	authService := services.NewAuthService(userRepo, orgService, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, mfaService, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+
+	ctx := context.Background()
+	enrollment, err := mfaService.EnrollTotp(ctx, "mfa-user")
+	if err != nil {
+		t.Fatalf("Expected enrollment to start, got %v", err)
+	}
+	if enrollment.Secret == "" || enrollment.ProvisioningUri == "" {
+		t.Fatal("Expected a secret and provisioning URI")
+	}
+
+	// Codes from the previous step are still accepted, which keeps the
+	// confirmation and the login below on distinct steps
+	previous, _ := totp.CodeAt(enrollment.Secret, totp.Step(time.Now())-1)
+	recovery, err := mfaService.ConfirmTotp(ctx, "mfa-user", &dto.ConfirmTotpRequest{Code: previous})
+	if err != nil {
+		t.Fatalf("Expected confirmation to succeed, got %v", err)
+	}
+	if len(recovery.RecoveryCodes) != 10 {
+		t.Fatalf("Expected 10 recovery codes, got %d", len(recovery.RecoveryCodes))
+	}
+
+	login, err := authService.Login(context.Background(), &dto.LoginRequest{Email: "mfa@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Expected password step to succeed, got %v", err)
+	}
+	if !login.MfaRequired || login.MfaToken == "" || login.AccessToken != "" {
+		t.Fatal("Expected an MFA challenge instead of an access token")
+	}
+
+	// The same code cannot be used twice
+	_, err = authService.LoginMfa(context.Background(), &dto.LoginMfaRequest{MfaToken: login.MfaToken, Code: previous})
+	if err == nil || err.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a replayed code to be rejected, got %v", err)
+	}
+	// ...and a challenge cannot be retried once it was presented
+	current, _ := totp.CodeAt(enrollment.Secret, totp.Step(time.Now()))
+	_, err = authService.LoginMfa(context.Background(), &dto.LoginMfaRequest{MfaToken: login.MfaToken, Code: current})
+	if err == nil || err.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a used challenge to be rejected, got %v", err)
+	}
+
+	login, _ = authService.Login(context.Background(), &dto.LoginRequest{Email: "mfa@example.com", Password: "password123"})
+	session, err := authService.LoginMfa(context.Background(), &dto.LoginMfaRequest{MfaToken: login.MfaToken, RecoveryCode: recovery.RecoveryCodes[0]})
+	if err != nil {
+		t.Fatalf("Expected a recovery code to complete login, got %v", err)
+	}
+	if session.AccessToken == "" || session.RefreshToken == "" {
+		t.Fatal("Expected an access token and refresh token after MFA")
+	}
+	refreshRepo.AssertExpectations(t)
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"h-two/internal/migrations"
+	"h-two/internal/models"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestEmbeddedMigrationsCoverModels checks that the migrations create every
+// table and column the models use, so the two cannot drift apart.
+func TestEmbeddedMigrationsCoverModels(t *testing.T) {
+	embedded, err := migrations.Embedded()
+	if err != nil {
+		t.Fatalf("Expected embedded migrations to load, got %v", err)
+	}
+	var up, down strings.Builder
+	for i, m := range embedded {
+		if i > 0 && m.Version <= embedded[i-1].Version {
+			t.Fatalf("Expected migrations in version order, got %d after %d", m.Version, embedded[i-1].Version)
+		}
+		up.WriteString(m.Up)
+		down.WriteString(m.Down)
+	}
+	tables := []interface{}{
+		&models.User{},
+		&models.Organization{},
+		&models.UserOrganization{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserTokenRevocation{},
+		&models.UserToken{},
+		&models.MfaRecoveryCode{},
+		&models.Invitation{},
+		&models.EmailChange{},
+		&models.LoginEvent{},
+		&models.PersonalAccessToken{},
+	}
+	for _, model := range tables {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("Error parsing model: %v", err)
+		}
+		create := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS ` + s.Table + ` \((.*?)\n\);`).FindStringSubmatch(up.String())
+		if create == nil {
+			t.Fatalf("Expected a migration to create %s", s.Table)
+		}
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			if !regexp.MustCompile(`(?m)^\s+` + field.DBName + ` `).MatchString(create[1]) {
+				t.Fatalf("Expected %s to have a %s column", s.Table, field.DBName)
+			}
+		}
+		if !strings.Contains(down.String(), "DROP TABLE IF EXISTS "+s.Table+";") {
+			t.Fatalf("Expected a down migration to drop %s", s.Table)
+		}
+	}
+	if !strings.Contains(up.String(), "CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));") {
+		t.Fatal("Expected email addresses to be unique regardless of case")
+	}
+}
+
+// TestInitialMigrationAdoptsLegacySchema checks that a database created by
+// an older AutoMigrate, whose tables lack the columns added since, gets
+// those columns before anything in the initial migration relies on them.
+func TestInitialMigrationAdoptsLegacySchema(t *testing.T) {
+	embedded, err := migrations.Embedded()
+	if err != nil {
+		t.Fatalf("Expected embedded migrations to load, got %v", err)
+	}
+	initial := embedded[0]
+	// Columns each table had when AutoMigrate first created it
+	legacy := map[interface{}][]string{
+		&models.User{}:                {"id", "created_at", "updated_at", "deleted_at", "user_id", "email", "password", "first_name", "last_name", "phone"},
+		&models.Organization{}:        {"org_id", "name", "description", "owner"},
+		&models.UserOrganization{}:    {"org_id", "user_id", "id"},
+		&models.RefreshToken{}:        {"id", "user_id", "family_id", "token_hash", "expires_at", "revoked_at", "replaced_by", "created_at"},
+		&models.UserTokenRevocation{}: {"user_id", "revoked_before"},
+	}
+	for model, columns := range legacy {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("Error parsing model: %v", err)
+		}
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.IgnoreMigration || slices.Contains(columns, field.DBName) {
+				continue
+			}
+			add := regexp.MustCompile(`ALTER TABLE ` + s.Table + ` ADD COLUMN (IF NOT EXISTS )?` + field.DBName + ` `).FindStringIndex(initial.Up)
+			if add == nil {
+				t.Fatalf("Expected the initial migration to add %s.%s to an existing table", s.Table, field.DBName)
+			}
+			for _, use := range []string{"ON " + s.Table + " (" + field.DBName + ")", "UPDATE " + s.Table + " SET " + field.DBName + " "} {
+				if i := strings.Index(initial.Up, use); i >= 0 && i < add[0] {
+					t.Fatalf("Expected %s.%s to be added before %q", s.Table, field.DBName, use)
+				}
+			}
+		}
+	}
+	if !regexp.MustCompile(`(?s)ADD COLUMN email_verified_at timestamptz;\s+UPDATE users SET email_verified_at = COALESCE\(created_at, now\(\)\);`).MatchString(initial.Up) {
+		t.Fatal("Expected existing users to count as verified when the column is added")
+	}
+
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	sqlMock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(regexp.QuoteMeta("SELECT version, applied_at FROM schema_migrations")).WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM schema_migrations WHERE version = $1")).WithArgs(initial.Version).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	sqlMock.ExpectExec(regexp.QuoteMeta(initial.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)")).WithArgs(initial.Version, initial.Name).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	done, err := migrations.New(gdb, embedded[:1]).Up(0)
+	if err != nil || len(done) != 1 {
+		t.Fatalf("Expected the initial migration to apply in one transaction, got %v, %v", done, err)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet database expectations: %v", err)
+	}
+}
+
+func TestMigrationFilesAndCreate(t *testing.T) {
+	_, err := migrations.Load(fstest.MapFS{"0001_widgets.up.sql": {Data: []byte("CREATE TABLE widgets ();")}})
+	if err == nil {
+		t.Fatal("Expected a migration without a down file to be rejected")
+	}
+	_, err = migrations.Load(fstest.MapFS{"widgets.sql": {Data: []byte("")}})
+	if err == nil {
+		t.Fatal("Expected a badly named migration file to be rejected")
+	}
+
+	dir := t.TempDir()
+	if _, _, err := migrations.Create(dir, "Add Widgets"); err == nil {
+		t.Fatal("Expected an invalid migration name to be rejected")
+	}
+	up, down, err := migrations.Create(dir, "add_widgets")
+	if err != nil || !strings.HasSuffix(up, "0001_add_widgets.up.sql") || !strings.HasSuffix(down, "0001_add_widgets.down.sql") {
+		t.Fatalf("Expected the first migration to be numbered 0001, got %s, %s, %v", up, down, err)
+	}
+	up, _, err = migrations.Create(dir, "add_gadgets")
+	if err != nil || !strings.HasSuffix(up, "0002_add_gadgets.up.sql") {
+		t.Fatalf("Expected the next migration to be numbered 0002, got %s, %v", up, err)
+	}
+	loaded, err := migrations.Load(os.DirFS(dir))
+	if err != nil || len(loaded) != 2 || loaded[1].Name != "add_gadgets" {
+		t.Fatalf("Expected the created migrations to load, got %v, %v", loaded, err)
+	}
+}
+
+func TestMigratorUpAndCheck(t *testing.T) {
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	migrator := migrations.New(gdb, []*migrations.Migration{
+		{Version: 1, Name: "create_widgets", Up: "CREATE TABLE widgets (id int)", Down: "DROP TABLE widgets"},
+		{Version: 2, Name: "add_widget_name", Up: "ALTER TABLE widgets ADD COLUMN name text", Down: "ALTER TABLE widgets DROP COLUMN name"},
+	})
+	appliedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ensureTable := regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")
+	selectApplied := regexp.QuoteMeta("SELECT version, applied_at FROM schema_migrations")
+
+	sqlMock.ExpectExec(ensureTable).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(selectApplied).WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, appliedAt))
+	var behind *migrations.SchemaBehindError
+	if err := migrator.Check(); !errors.As(err, &behind) || len(behind.Pending) != 1 || behind.Pending[0].Version != 2 {
+		t.Fatalf("Expected the schema to be one migration behind, got %v", err)
+	}
+
+	sqlMock.ExpectExec(ensureTable).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(selectApplied).WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, appliedAt))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM schema_migrations WHERE version = $1")).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	sqlMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE widgets ADD COLUMN name text")).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)")).WithArgs(2, "add_widget_name").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	done, err := migrator.Up(0)
+	if err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("Expected only the pending migration to be applied, got %v, %v", done, err)
+	}
+
+	sqlMock.ExpectExec(ensureTable).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(selectApplied).WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).
+		AddRow(1, appliedAt).AddRow(2, appliedAt))
+	if err := migrator.Check(); err != nil {
+		t.Fatalf("Expected an up to date schema to pass the check, got %v", err)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet database expectations: %v", err)
+	}
+}
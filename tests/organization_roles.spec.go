@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/models"
+	"h-two/internal/services"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOrganizationRoles(t *testing.T) {
+	orgRepo := new(MockOrganizationRepository)
+	membership := func(userId string, role string) {
+		orgRepo.On("GetOrganizationById", userId, "org-1").Return(&models.Organization{OrgId: "org-1", Owner: "owner", Role: role}, nil)
+	}
+	membership("owner", models.RoleOwner)
+	membership("admin", models.RoleAdmin)
+	membership("other-admin", models.RoleAdmin)
+	membership("member", models.RoleMember)
+	orgRepo.On("GetOrganizationById", "outsider", "org-1").Return((*models.Organization)(nil), gorm.ErrRecordNotFound)
+	orgRepo.On("AddUserToOrganization", "org-1", "new-user", models.RoleMember).Return(nil)
+	orgRepo.On("UpdateMemberRole", "org-1", "member", models.RoleAdmin).Return(nil)
+	orgRepo.On("UpdateMemberRole", "org-1", "other-admin", models.RoleMember).Return(nil)
+	orgService := services.NewOrganizationService(orgRepo)
+
+	added, err := orgService.AddUserToOrganization(context.Background(), "admin", "org-1", "new-user", "")
+	if err != nil {
+		t.Fatalf("Expected an admin to add a member, got %v", err)
+	}
+	if added.Role != models.RoleMember {
+		t.Fatalf("Expected new members to default to %s, got %s", models.RoleMember, added.Role)
+	}
+	if _, err := orgService.AddUserToOrganization(context.Background(), "member", "org-1", "new-user", ""); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a member to be forbidden from adding members, got %v", err)
+	}
+	if _, err := orgService.AddUserToOrganization(context.Background(), "outsider", "org-1", "new-user", ""); err == nil || err.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected a non-member to get %d, got %v", http.StatusNotFound, err)
+	}
+
+	if _, err := orgService.UpdateMemberRole(context.Background(), "member", "org-1", "admin", models.RoleMember); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a member to be forbidden from changing roles, got %v", err)
+	}
+	if _, err := orgService.UpdateMemberRole(context.Background(), "admin", "org-1", "owner", models.RoleMember); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the owner's role to be fixed, got %v", err)
+	}
+	updated, err := orgService.UpdateMemberRole(context.Background(), "owner", "org-1", "member", models.RoleAdmin)
+	if err != nil || updated.Role != models.RoleAdmin {
+		t.Fatalf("Expected the owner to promote a member, got %v", err)
+	}
+	// Admins cannot demote each other, which would let them remove one
+	// another
+	if _, err := orgService.UpdateMemberRole(context.Background(), "admin", "org-1", "other-admin", models.RoleMember); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected an admin to be forbidden from demoting another admin, got %v", err)
+	}
+	orgRepo.AssertNumberOfCalls(t, "UpdateMemberRole", 1)
+	if _, err := orgService.UpdateMemberRole(context.Background(), "owner", "org-1", "other-admin", models.RoleMember); err != nil {
+		t.Fatalf("Expected the owner to demote an admin, got %v", err)
+	}
+
+	if _, err := orgService.SetMfaPolicy(context.Background(), "member", "org-1", true); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a member to be forbidden from editing the organization, got %v", err)
+	}
+	orgRepo.AssertExpectations(t)
+}
+
+func TestMemberRemovalAndOwnershipTransfer(t *testing.T) {
+	orgRepo := new(MockOrganizationRepository)
+	for userId, role := range map[string]string{"owner": models.RoleOwner, "admin": models.RoleAdmin, "other-admin": models.RoleAdmin, "member": models.RoleMember} {
+		orgRepo.On("GetOrganizationById", userId, "org-1").Return(&models.Organization{OrgId: "org-1", Owner: "owner", Role: role}, nil)
+	}
+	orgRepo.On("RemoveUserFromOrganization", "org-1", "member").Return(true, nil)
+	orgRepo.On("TransferOwnership", "org-1", "owner", "admin").Return(true, nil)
+	orgService := services.NewOrganizationService(orgRepo)
+
+	if err := orgService.RemoveMember(context.Background(), "admin", "org-1", "owner"); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the owner to be irremovable, got %v", err)
+	}
+	if err := orgService.RemoveMember(context.Background(), "admin", "org-1", "other-admin"); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected an admin not to remove another admin, got %v", err)
+	}
+	if err := orgService.RemoveMember(context.Background(), "member", "org-1", "admin"); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a member not to remove anyone, got %v", err)
+	}
+	if err := orgService.RemoveMember(context.Background(), "admin", "org-1", "member"); err != nil {
+		t.Fatalf("Expected an admin to remove a member, got %v", err)
+	}
+
+	if err := orgService.LeaveOrganization(context.Background(), "owner", "org-1"); err == nil || err.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected the owner to transfer ownership before leaving, got %v", err)
+	}
+	if _, err := orgService.TransferOwnership(context.Background(), "admin", "org-1", "member"); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected only the owner to transfer ownership, got %v", err)
+	}
+	org, err := orgService.TransferOwnership(context.Background(), "owner", "org-1", "admin")
+	if err != nil {
+		t.Fatalf("Expected ownership transfer to succeed, got %v", err)
+	}
+	if org.Role != models.RoleAdmin {
+		t.Fatalf("Expected the previous owner to become %s, got %s", models.RoleAdmin, org.Role)
+	}
+	orgRepo.AssertExpectations(t)
+}
+
+func TestOrganizationUpdateDeleteAndRestore(t *testing.T) {
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("GetOrganizationById", "owner", "org-1").Return(&models.Organization{OrgId: "org-1", Name: "Old", Owner: "owner", Role: models.RoleOwner}, nil)
+	orgRepo.On("GetOrganizationById", "admin", "org-1").Return(&models.Organization{OrgId: "org-1", Name: "Old", Owner: "owner", Role: models.RoleAdmin}, nil)
+	orgRepo.On("UpdateOrganization", "org-1", map[string]interface{}{"name": "New"}).Return(nil)
+	orgRepo.On("SoftDeleteOrganization", "org-1").Return(nil)
+	orgRepo.On("GetDeletedOrganization", "owner", "org-1").Return(&models.Organization{OrgId: "org-1", Name: "New", Owner: "owner", Role: models.RoleOwner}, nil)
+	withinRestorePeriod := mock.MatchedBy(func(cutoff time.Time) bool {
+		return time.Since(cutoff) > services.OrganizationRestorePeriod-time.Minute
+	})
+	orgRepo.On("RestoreOrganization", "org-1", withinRestorePeriod).Return(true, nil).Once()
+	orgRepo.On("RestoreOrganization", "org-1", withinRestorePeriod).Return(false, nil)
+	orgRepo.On("PurgeDeletedOrganizations", withinRestorePeriod).Return(int64(1), nil)
+	orgService := services.NewOrganizationService(orgRepo)
+
+	name := "New"
+	org, err := orgService.UpdateOrganization(context.Background(), "admin", "org-1", &dto.UpdateOrganizationRequest{Name: &name})
+	if err != nil || org.Name != "New" {
+		t.Fatalf("Expected an admin to rename the organization, got %v", err)
+	}
+	if _, err := orgService.DeleteOrganization(context.Background(), "admin", "org-1"); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected only the owner to delete the organization, got %v", err)
+	}
+	deletion, err := orgService.DeleteOrganization(context.Background(), "owner", "org-1")
+	if err != nil {
+		t.Fatalf("Expected the owner to delete the organization, got %v", err)
+	}
+	if time.Until(deletion.RestorableUntil) < services.OrganizationRestorePeriod-time.Minute {
+		t.Fatalf("Expected the organization to be restorable for %s", services.OrganizationRestorePeriod)
+	}
+	if _, err := orgService.RestoreOrganization(context.Background(), "owner", "org-1"); err != nil {
+		t.Fatalf("Expected the owner to restore the organization, got %v", err)
+	}
+	// Once the restore period has passed the organization is gone for good
+	if _, err := orgService.RestoreOrganization(context.Background(), "owner", "org-1"); err == nil || err.StatusCode != http.StatusGone {
+		t.Fatalf("Expected restore after the grace period to fail with %d, got %v", http.StatusGone, err)
+	}
+	if purged, err := orgService.PurgeDeletedOrganizations(context.Background()); err != nil || purged != 1 {
+		t.Fatalf("Expected one organization to be purged, got %d, %v", purged, err)
+	}
+	orgRepo.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/helpers"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestListOrganizationsPagination(t *testing.T) {
+	joined := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	orgs := []*models.Organization{
+		{OrgId: "o1", Name: "Acme", Role: models.RoleOwner, JoinedAt: &joined},
+		{OrgId: "o2", Name: "Globex", Role: models.RoleMember, JoinedAt: &joined},
+	}
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("GetOrganizationsByUser", "u1", mock.MatchedBy(func(page repository.Page) bool {
+		return page.Limit == 2 && page.Sort == repository.SortJoined && page.Desc
+	})).Return(orgs, nil)
+	orgService := services.NewOrganizationService(orgRepo)
+
+	page, meta, err := orgService.GetUserOrganizations(context.Background(), "u1", &dto.ListOrganizationsQuery{Limit: 1, Sort: repository.SortJoined, Order: "desc"})
+	if err != nil {
+		t.Fatalf("Expected organizations to be listed, got %v", err)
+	}
+	if len(page) != 1 || page[0].OrgId != "o1" || meta.NextCursor == nil {
+		t.Fatalf("Expected one organization and a next cursor, got %d, %+v", len(page), meta)
+	}
+	var cursor repository.Cursor
+	helpers.DecodeCursor(*meta.NextCursor, &cursor)
+	if cursor.Id != "o1" || cursor.Value != "2024-05-01T12:00:00.123456Z" || !cursor.Desc {
+		t.Fatalf("Expected the cursor to point after o1 by join date, got %+v", cursor)
+	}
+	if _, _, err := orgService.GetUserOrganizations(context.Background(), "u1", &dto.ListOrganizationsQuery{Cursor: *meta.NextCursor}); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a join date cursor to be rejected when sorting by name, got %v", err)
+	}
+	if _, _, err := orgService.GetUserOrganizations(context.Background(), "u1", &dto.ListOrganizationsQuery{Sort: repository.SortJoined, Order: "asc", Cursor: *meta.NextCursor}); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a descending cursor to be rejected when sorting ascending, got %v", err)
+	}
+
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	sqlMock.ExpectQuery(regexp.QuoteMeta(`WHERE user_organizations.user_id = $1 AND (user_organizations.created_at, organizations.org_id) < ($2, $3) AND "organizations"."deleted_at" IS NULL ORDER BY user_organizations.created_at DESC, organizations.org_id DESC LIMIT $4`)).
+		WithArgs("u1", joined, "o1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "name", "role", "joined_at"}).AddRow("o2", "Globex", models.RoleMember, joined))
+	rows, qerr := repository.NewOrganizationRepository(gdb).GetOrganizationsByUser(context.Background(), "u1", repository.Page{Sort: repository.SortJoined, Desc: true, After: &cursor, Limit: 2})
+	if qerr != nil {
+		t.Fatalf("Expected the keyset query to succeed, got %v", qerr)
+	}
+	if len(rows) != 1 || rows[0].Role != models.RoleMember || rows[0].JoinedAt == nil {
+		t.Fatalf("Expected role and join date to be scanned, got %+v", rows)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetFlow(t *testing.T) {
+	user := &models.User{UserId: "some-user-id", FirstName: "John", Email: "john.doe@example.com"}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "john.doe@example.com").Return(user, nil)
+	userRepo.On("GetUserByEmail", "nobody@example.com").Return((*models.User)(nil), gorm.ErrRecordNotFound)
+	userRepo.On("UpdatePassword", "some-user-id", mock.AnythingOfType("string")).Return(nil)
+	userRepo.On("GetUserById", "some-user-id").Return(user, nil)
+
+	var stored *models.UserToken
+	userTokens := new(MockUserTokenRepository)
+	userTokens.On("InvalidateUserTokens", "some-user-id", models.TokenPurposePasswordReset).Return(nil)
+	userTokens.On("CreateUserToken", mock.AnythingOfType("*models.UserToken")).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.UserToken)
+		stored.Id = "reset-token-id"
+	}).Return(nil)
+	userTokens.On("GetUserTokenByHash", mock.AnythingOfType("string"), models.TokenPurposePasswordReset).Return(func(hash string, purpose string) (*models.UserToken, error) {
+		if stored != nil && stored.TokenHash == hash {
+			return stored, nil
+		}
+		return nil, gorm.ErrRecordNotFound
+	}, nil)
+	userTokens.On("ConsumeUserToken", "reset-token-id").Run(func(args mock.Arguments) {
+		now := time.Now()
+		stored.UsedAt = &now
+	}).Return(true, nil)
+
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("RevokeUserRefreshTokens", "some-user-id", "").Return(nil)
+	mailer := &RecordingMailSender{}
+	limiter := NewTestLoginLimiter()
+	personalTokens := NewFakePersonalAccessTokenRepository()
+	personalTokenService := services.NewPersonalAccessTokenService(personalTokens, userRepo, logging.Discard())
+	pat, apiErr := personalTokenService.CreateToken(context.Background(), "some-user-id", false, &dto.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{models.ScopeUserRead}, ExpiresInDays: 30})
+	if apiErr != nil {
+		t.Fatalf("Expected the personal access token to be created, got %v", apiErr)
+	}
+	if _, err := personalTokenService.AuthenticatePersonalAccessToken(context.Background(), pat.Token); err != nil {
+		t.Fatalf("Expected the personal access token to work before the reset, got %v", err)
+	}
+	authService := services.NewAuthService(userRepo, nil, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), userTokens, personalTokens, mailer, nil, nil, nil, limiter, PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+
+	// Unknown addresses look exactly like known ones to the caller
+	if err := authService.ForgotPassword(context.Background(), &dto.ForgotPasswordRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("Expected forgot password to succeed for unknown email, got %v", err)
+	}
+	if len(mailer.Messages) != 0 {
+		t.Fatal("Expected no email for an unknown address")
+	}
+
+	if err := authService.ForgotPassword(context.Background(), &dto.ForgotPasswordRequest{Email: "john.doe@example.com"}); err != nil {
+		t.Fatalf("Expected forgot password to succeed, got %v", err)
+	}
+	if len(mailer.Messages) != 1 || mailer.Messages[0].To != "john.doe@example.com" {
+		t.Fatal("Expected a reset email to be sent to the user")
+	}
+	match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(mailer.Messages[0].Body)
+	if match == nil {
+		t.Fatal("Expected the reset email to contain a token")
+	}
+
+	// Resetting the password also lifts a lockout
+	for i := 0; i < 5; i++ {
+		limiter.RecordFailure(context.Background(), "john.doe@example.com")
+	}
+	if until, _ := limiter.LockedUntil(context.Background(), "john.doe@example.com"); until == nil {
+		t.Fatal("Expected the account to be locked out")
+	}
+	if err := authService.ResetPassword(context.Background(), &dto.ResetPasswordRequest{Token: match[1], Password: "new-password"}); err != nil {
+		t.Fatalf("Expected password reset to succeed, got %v", err)
+	}
+	if until, _ := limiter.LockedUntil(context.Background(), "john.doe@example.com"); until != nil {
+		t.Errorf("Expected the reset to unlock the account, still locked until %s", until)
+	}
+	userRepo.AssertCalled(t, "UpdatePassword", "some-user-id", mock.AnythingOfType("string"))
+	refreshRepo.AssertCalled(t, "RevokeUserRefreshTokens", "some-user-id", "")
+	// Whoever knew the old password could have created a token
+	if _, err := personalTokenService.AuthenticatePersonalAccessToken(context.Background(), pat.Token); err == nil || err.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the personal access token to be rejected after the reset, got %v", err)
+	}
+
+	err := authService.ResetPassword(context.Background(), &dto.ResetPasswordRequest{Token: match[1], Password: "another-password"})
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a used reset token to be rejected with %d, got %v", http.StatusBadRequest, err)
+	}
+}
+
+// TestResetPasswordValidation checks that a reset password is held to the
+// same rules as a changed one before the single-use token is spent.
+func TestResetPasswordValidation(t *testing.T) {
+	userTokens := new(MockUserTokenRepository)
+	authService := services.NewAuthService(new(MockUserRepository), nil, new(MockRefreshTokenRepository), repository.NewInMemoryTokenRevocationRepository(), userTokens, NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, nil, NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	s := &server.Server{AuthService: authService, Keys: testKeys}
+	r := gin.New()
+	r.POST("/auth/reset-password", s.ResetPasswordHandler)
+
+	for _, password := range []string{"short", strings.Repeat("a", 73)} {
+		rr := authorizedJSONRequest(r, "POST", "/auth/reset-password", "", &dto.ResetPasswordRequest{Token: "reset-token", Password: password})
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected a %d character password to be rejected with %d, got %d", len(password), http.StatusUnprocessableEntity, rr.Code)
+		}
+	}
+	userTokens.AssertNotCalled(t, "GetUserTokenByHash", mock.Anything, mock.Anything)
+	userTokens.AssertNotCalled(t, "ConsumeUserToken", mock.Anything)
+}
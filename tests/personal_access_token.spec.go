@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/helpers"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FakePersonalAccessTokenRepository keeps personal access tokens in memory.
+type FakePersonalAccessTokenRepository struct {
+	Tokens []*models.PersonalAccessToken
+}
+
+func NewFakePersonalAccessTokenRepository() *FakePersonalAccessTokenRepository {
+	return &FakePersonalAccessTokenRepository{}
+}
+
+func (r *FakePersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error {
+	token.Id = "token-" + token.Name
+	token.CreatedAt = time.Now()
+	r.Tokens = append(r.Tokens, token)
+	return nil
+}
+
+func (r *FakePersonalAccessTokenRepository) GetPersonalAccessTokens(ctx context.Context, userId string) ([]*models.PersonalAccessToken, error) {
+	var tokens []*models.PersonalAccessToken
+	for i := len(r.Tokens) - 1; i >= 0; i-- {
+		if r.Tokens[i].UserId == userId {
+			tokens = append(tokens, r.Tokens[i])
+		}
+	}
+	return tokens, nil
+}
+
+func (r *FakePersonalAccessTokenRepository) GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error) {
+	for _, token := range r.Tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *FakePersonalAccessTokenRepository) DeletePersonalAccessToken(ctx context.Context, userId string, id string) (bool, error) {
+	for i, token := range r.Tokens {
+		if token.UserId == userId && token.Id == id {
+			r.Tokens = append(r.Tokens[:i], r.Tokens[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *FakePersonalAccessTokenRepository) DeleteUserPersonalAccessTokens(ctx context.Context, userId string) error {
+	var kept []*models.PersonalAccessToken
+	for _, token := range r.Tokens {
+		if token.UserId != userId {
+			kept = append(kept, token)
+		}
+	}
+	r.Tokens = kept
+	return nil
+}
+
+func (r *FakePersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id string, at time.Time, ip string) error {
+	for _, token := range r.Tokens {
+		if token.Id == id {
+			token.LastUsedAt = &at
+			token.LastUsedIp = ip
+		}
+	}
+	return nil
+}
+
+func createPersonalAccessToken(t *testing.T, r *gin.Engine, session string, req *dto.CreatePersonalAccessTokenRequest) dto.CreatePersonalAccessTokenResponse {
+	t.Helper()
+	rr := authorizedJSONRequest(r, "POST", "/api/users/me/tokens", session, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected the token to be created with %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Data dto.CreatePersonalAccessTokenResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	return body.Data
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	s := setupServer()
+	user := &models.User{UserId: "some-user-id", Email: "john.doe@example.com"}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserById", "some-user-id").Return(user, nil)
+	tokens := NewFakePersonalAccessTokenRepository()
+	s.PersonalAccessTokenService = services.NewPersonalAccessTokenService(tokens, userRepo, logging.Discard())
+
+	r := gin.New()
+	r.Use(middleware.RequestContext(0))
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, s.PersonalAccessTokenService)
+	orgsRead := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, s.PersonalAccessTokenService, models.ScopeOrganizationsRead)
+	userWrite := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, s.PersonalAccessTokenService, models.ScopeUserWrite)
+	r.POST("/auth/login", s.LoginHandler)
+	r.GET("/api/users/me/tokens", authMiddleware, s.GetPersonalAccessTokensHandler)
+	r.POST("/api/users/me/tokens", authMiddleware, s.CreatePersonalAccessTokenHandler)
+	r.DELETE("/api/users/me/tokens/:tokenId", authMiddleware, s.RevokePersonalAccessTokenHandler)
+	r.GET("/api/organisations", orgsRead, s.GetOrganizationsHandler)
+	r.PATCH("/api/users/me", userWrite, s.UpdateProfileHandler)
+	session := loginForToken(t, r)
+
+	created := createPersonalAccessToken(t, r, session, &dto.CreatePersonalAccessTokenRequest{
+		Name:          "ci",
+		Scopes:        []string{models.ScopeOrganizationsRead, models.ScopeOrganizationsRead},
+		ExpiresInDays: 30,
+	})
+	if !strings.HasPrefix(created.Token, models.PersonalAccessTokenPrefix) || !strings.HasPrefix(created.Token, created.Prefix) {
+		t.Fatalf("Expected a prefixed token, got %+v", created)
+	}
+	if len(created.Scopes) != 1 || created.ExpiresAt.Before(time.Now().AddDate(0, 0, 29)) {
+		t.Fatalf("Expected the scopes and expiry to be recorded, got %+v", created)
+	}
+	if stored := tokens.Tokens[0]; stored.TokenHash != helpers.HashToken(created.Token) || strings.Contains(stored.TokenHash, created.Token) {
+		t.Fatal("Expected only the hash of the token to be stored")
+	}
+
+	rr := authorizedJSONRequest(r, "POST", "/api/users/me/tokens", session, &dto.CreatePersonalAccessTokenRequest{Name: "CI", Scopes: []string{models.ScopeUserRead}, ExpiresInDays: 30})
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected a duplicate name to be rejected with %d, got %d", http.StatusConflict, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "POST", "/api/users/me/tokens", session, &dto.CreatePersonalAccessTokenRequest{Name: "admin", Scopes: []string{"admin"}, ExpiresInDays: 30})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected an unknown scope to be rejected with %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "POST", "/api/users/me/tokens", session, &dto.CreatePersonalAccessTokenRequest{Name: "forever", Scopes: []string{models.ScopeUserRead}, ExpiresInDays: 0})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected a token without expiry to be rejected with %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// The token works on routes within its scopes and records where it was
+	// last used from
+	req := httptest.NewRequest("GET", "/api/organisations", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	req.RemoteAddr = "192.0.2.7:40000"
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the token to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if used := tokens.Tokens[0]; used.LastUsedAt == nil || used.LastUsedIp != "192.0.2.7" {
+		t.Fatalf("Expected the use to be recorded, got %+v", used)
+	}
+	rr = authorizedJSONRequest(r, "PATCH", "/api/users/me", created.Token, map[string]string{"firstName": "Jane"})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected a route outside the token's scopes to return %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	// Session-only routes refuse tokens, so a token cannot mint more tokens
+	if code := authorizedRequest(r, "GET", "/api/users/me/tokens", created.Token); code != http.StatusForbidden {
+		t.Fatalf("Expected a session-only route to return %d, got %d", http.StatusForbidden, code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", models.PersonalAccessTokenPrefix+"unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected an unknown token to return %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	rr = authorizedJSONRequest(r, "GET", "/api/users/me/tokens", session, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the tokens to be listed, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), created.Token) || strings.Contains(rr.Body.String(), tokens.Tokens[0].TokenHash) {
+		t.Fatal("Expected the list to leave out the token and its hash")
+	}
+	var list struct {
+		Data struct {
+			Tokens []dto.PersonalAccessTokenResponse `json:"tokens"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Data.Tokens) != 1 || list.Data.Tokens[0].Name != "ci" || list.Data.Tokens[0].LastUsedAt == nil {
+		t.Fatalf("Expected the token with its last use, got %+v", list.Data.Tokens)
+	}
+
+	// Tokens stop working once expired or while the account awaits deletion
+	tokens.Tokens[0].ExpiresAt = time.Now().Add(-time.Second)
+	if code := authorizedRequest(r, "GET", "/api/organisations", created.Token); code != http.StatusUnauthorized {
+		t.Fatalf("Expected an expired token to return %d, got %d", http.StatusUnauthorized, code)
+	}
+	tokens.Tokens[0].ExpiresAt = time.Now().Add(time.Hour)
+	scheduled := time.Now().Add(services.AccountDeletionCoolingOff)
+	user.DeletionScheduledAt = &scheduled
+	if code := authorizedRequest(r, "GET", "/api/organisations", created.Token); code != http.StatusUnauthorized {
+		t.Fatalf("Expected the token of an account awaiting deletion to return %d, got %d", http.StatusUnauthorized, code)
+	}
+	user.DeletionScheduledAt = nil
+
+	if code := authorizedRequest(r, "DELETE", "/api/users/me/tokens/"+created.Id, session); code != http.StatusOK {
+		t.Fatalf("Expected the token to be revoked, got %d", code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", created.Token); code != http.StatusUnauthorized {
+		t.Fatalf("Expected a revoked token to return %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := authorizedRequest(r, "DELETE", "/api/users/me/tokens/"+created.Id, session); code != http.StatusNotFound {
+		t.Fatalf("Expected revoking again to return %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestPersonalAccessTokenRepository(t *testing.T) {
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	repo := repository.NewPersonalAccessTokenRepository(gdb)
+	ctx := context.Background()
+
+	// Tokens are looked up by hash only
+	sqlMock.ExpectQuery(`SELECT \* FROM "personal_access_tokens" WHERE token_hash = \$1`).
+		WithArgs("some-hash", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes"}).AddRow("token-1", "some-user-id", "user:read organizations:read"))
+	token, err := repo.GetPersonalAccessTokenByHash(ctx, "some-hash")
+	if err != nil || !token.HasScopes(models.ScopeOrganizationsRead) || token.HasScopes(models.ScopeUserWrite) {
+		t.Fatalf("Expected the token with its scopes, got %+v (%v)", token, err)
+	}
+
+	// Users can only revoke their own tokens
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`DELETE FROM "personal_access_tokens" WHERE user_id = \$1 AND id = \$2`).
+		WithArgs("other-user-id", "token-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+	if deleted, err := repo.DeletePersonalAccessToken(ctx, "other-user-id", "token-1"); deleted || err != nil {
+		t.Errorf("Expected nothing to be revoked, got %v (%v)", deleted, err)
+	}
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`DELETE FROM "personal_access_tokens" WHERE user_id = \$1`).
+		WithArgs("some-user-id").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	sqlMock.ExpectCommit()
+	if err := repo.DeleteUserPersonalAccessTokens(ctx, "some-user-id"); err != nil {
+		t.Errorf("Expected every token of the user to be revoked, got %v", err)
+	}
+
+	at := time.Now()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "personal_access_tokens" SET "last_used_at"=\$1,"last_used_ip"=\$2 WHERE id = \$3`).
+		WithArgs(at, "192.0.2.7", "token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	if err := repo.TouchPersonalAccessToken(ctx, "token-1", at, "192.0.2.7"); err != nil {
+		t.Errorf("Expected the use to be recorded, got %v", err)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func authorizedJSONRequest(r *gin.Engine, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	reqBodyJSON, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(reqBodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUpdateProfile(t *testing.T) {
+	s := setupServer()
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.PATCH("/api/users/me", authMiddleware, s.UpdateProfileHandler)
+	token := loginForToken(t, r)
+
+	rr := authorizedJSONRequest(r, "PATCH", "/api/users/me", token, map[string]string{"phone": "not a phone"})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected an invalid phone to be rejected with %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "PATCH", "/api/users/me", token, map[string]string{"firstName": "   "})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected a blank first name to be rejected with %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "PATCH", "/api/users/me", token, map[string]string{"firstName": " Jane ", "phone": "+44 20 7946 0958"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected profile update to return %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Names are trimmed and absent fields are left alone
+	user := &models.User{UserId: "profile-user", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"}
+	userRepo := new(MockUserRepository)
+	userRepo.On("UpdateProfile", "profile-user", mock.Anything).Return(nil)
+	userRepo.On("GetUserById", "profile-user").Return(user, nil)
+	firstName := " Jane "
+	resp, apiErr := services.NewUserService(userRepo, services.EmailVerificationOff, logging.Discard()).UpdateProfile(context.Background(), "profile-user", &dto.UpdateProfileRequest{FirstName: &firstName})
+	if apiErr != nil {
+		t.Fatalf("Expected profile update to succeed, got %v", apiErr)
+	}
+	if resp.FirstName != "Jane" {
+		t.Fatalf("Expected the updated profile to be returned, got %+v", resp)
+	}
+	userRepo.AssertCalled(t, "UpdateProfile", "profile-user", map[string]interface{}{"first_name": "Jane"})
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	s := setupServer()
+	r := gin.New()
+	authMiddleware := middleware.AuthMiddleware(s.Keys, s.TokenRevocations, nil)
+	r.POST("/auth/login", s.LoginHandler)
+	r.POST("/api/users/me/password", authMiddleware, s.ChangePasswordHandler)
+	r.GET("/api/organisations", authMiddleware, s.GetOrganizationsHandler)
+
+	current := loginForToken(t, r)
+	other := loginForToken(t, r)
+
+	rr := authorizedJSONRequest(r, "POST", "/api/users/me/password", current, &dto.ChangePasswordRequest{
+		CurrentPassword: "wrong-password",
+		NewPassword:     "new-password123",
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a wrong current password to be rejected with %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = authorizedJSONRequest(r, "POST", "/api/users/me/password", current, &dto.ChangePasswordRequest{
+		CurrentPassword: "password123",
+		NewPassword:     "short",
+	})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected a short new password to be rejected with %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", other); code != http.StatusOK {
+		t.Fatalf("Expected failed attempts to leave sessions alone, got %d", code)
+	}
+
+	rr = authorizedJSONRequest(r, "POST", "/api/users/me/password", current, &dto.ChangePasswordRequest{
+		CurrentPassword: "password123",
+		NewPassword:     "new-password123",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected password change to return %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", current); code != http.StatusOK {
+		t.Fatalf("Expected the current session to survive a password change, got %d", code)
+	}
+	if code := authorizedRequest(r, "GET", "/api/organisations", other); code != http.StatusUnauthorized {
+		t.Fatalf("Expected other sessions to be rejected with %d, got %d", http.StatusUnauthorized, code)
+	}
+}
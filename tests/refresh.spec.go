@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"h-two/internal/dto"
+	"h-two/internal/helpers"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	refreshRepo := new(MockRefreshTokenRepository)
+	current := &models.RefreshToken{
+		Id:        "token-1",
+		UserId:    "some-user-id",
+		FamilyId:  "family-1",
+		TokenHash: helpers.HashToken("old-refresh-token"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	refreshRepo.On("GetRefreshTokenByHash", current.TokenHash).Return(current, nil)
+	refreshRepo.On("RotateRefreshToken", "token-1", mock.MatchedBy(func(next *models.RefreshToken) bool {
+		return next.FamilyId == "family-1" && next.UserId == "some-user-id" && next.TokenHash != current.TokenHash
+	})).Return(true, nil)
+
+	authService := services.NewAuthService(new(MockUserRepository), nil, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, nil, NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	resp, err := authService.Refresh(context.Background(), &dto.RefreshTokenRequest{RefreshToken: "old-refresh-token"})
+	if err != nil {
+		t.Fatalf("Expected refresh to succeed, got %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatal("Expected a new access token and refresh token")
+	}
+	if resp.RefreshToken == "old-refresh-token" {
+		t.Fatal("Expected the refresh token to be rotated")
+	}
+	refreshRepo.AssertNotCalled(t, "RevokeRefreshTokenFamily", mock.Anything)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	refreshRepo := new(MockRefreshTokenRepository)
+	revokedAt := time.Now().Add(-time.Minute)
+	replayed := &models.RefreshToken{
+		Id:        "token-1",
+		UserId:    "some-user-id",
+		FamilyId:  "family-1",
+		TokenHash: helpers.HashToken("stolen-refresh-token"),
+		ExpiresAt: time.Now().Add(time.Hour),
+		RevokedAt: &revokedAt,
+	}
+	refreshRepo.On("GetRefreshTokenByHash", replayed.TokenHash).Return(replayed, nil)
+	refreshRepo.On("RevokeRefreshTokenFamily", "family-1").Return(nil)
+
+	authService := services.NewAuthService(new(MockUserRepository), nil, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, nil, NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	_, err := authService.Refresh(context.Background(), &dto.RefreshTokenRequest{RefreshToken: "stolen-refresh-token"})
+	if err == nil || err.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected replayed refresh token to be rejected with %d, got %v", http.StatusUnauthorized, err)
+	}
+	refreshRepo.AssertCalled(t, "RevokeRefreshTokenFamily", "family-1")
+	refreshRepo.AssertNotCalled(t, "RotateRefreshToken", mock.Anything, mock.Anything)
+}
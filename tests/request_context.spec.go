@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestContextRecordsClient(t *testing.T) {
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "client-user", Email: "client@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "client@example.com").Return(user, nil)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "client-user").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	events := NewFakeLoginEventRepository()
+	orgService := services.NewOrganizationService(orgRepo)
+	authService := services.NewAuthService(userRepo, orgService, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, events, NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	s := &server.Server{AuthService: authService, Keys: testKeys}
+
+	r := gin.New()
+	r.Use(middleware.RequestContext(time.Second))
+	r.POST("/api/login", s.LoginHandler)
+	req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBufferString(`{"email":"client@example.com","password":"password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "h-two-test/1.0")
+	req.RemoteAddr = "203.0.113.7:51234"
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(events.Events) != 1 {
+		t.Fatalf("Expected one login event, got %d", len(events.Events))
+	}
+	if event := events.Events[0]; event.IpAddress != "203.0.113.7" || event.UserAgent != "h-two-test/1.0" {
+		t.Errorf("Expected the client to be recorded, got %s %q", event.IpAddress, event.UserAgent)
+	}
+}
+
+func TestRequestContextTimeout(t *testing.T) {
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	userRepo := repository.NewUserRepository(gdb)
+	sqlMock.ExpectQuery(`SELECT \* FROM "users"`).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("slow-user"))
+
+	var queryErr error
+	r := gin.New()
+	r.Use(middleware.RequestContext(50 * time.Millisecond))
+	r.GET("/slow", func(c *gin.Context) {
+		_, queryErr = userRepo.GetUserById(c.Request.Context(), "slow-user")
+		c.Status(http.StatusNoContent)
+	})
+
+	started := time.Now()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the query to be cancelled at the request timeout, took %s", elapsed)
+	}
+	if queryErr == nil {
+		t.Fatal("Expected the slow query to fail")
+	}
+
+	// Work started without a request keeps running to completion
+	sqlMock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("slow-user"))
+	if _, err := userRepo.GetUserById(context.Background(), "slow-user"); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected a query without a deadline to succeed, got %v", err)
+	}
+}
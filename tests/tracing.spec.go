@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/config"
+	"h-two/internal/logging"
+	"h-two/internal/middleware"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/server"
+	"h-two/internal/services"
+	"h-two/internal/tracing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordSpans installs a tracer provider keeping every ended span in memory
+// until the test finishes.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	if _, err := tracing.Setup(context.Background(), config.TracingConfig{Exporter: "none"}, nil); err != nil {
+		t.Fatalf("Expected the propagator to be installed, got %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+// endedSpan returns the ended span with the given name.
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("Expected a span named %q", name)
+	return nil
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	h, _ := services.HashPassword("password123")
+	user := &models.User{UserId: "traced-user", Email: "traced@example.com", Password: h}
+	userRepo := new(MockUserRepository)
+	userRepo.On("GetUserByEmail", "traced@example.com").Return(user, nil)
+	orgRepo := new(MockOrganizationRepository)
+	orgRepo.On("IsMfaRequiredForUser", "traced-user").Return(false, nil)
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	authService := services.NewAuthService(userRepo, services.NewOrganizationService(orgRepo), refreshRepo, repository.NewInMemoryTokenRevocationRepository(), new(MockUserTokenRepository), NewFakePersonalAccessTokenRepository(), &RecordingMailSender{}, nil, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), PassthroughTxManager{}, testKeys, testAppURL, logging.Discard())
+	s := &server.Server{AuthService: authService, Keys: testKeys}
+
+	r := gin.New()
+	r.Use(middleware.Tracing())
+	r.POST("/auth/login", s.LoginHandler)
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(`{"email":"traced@example.com","password":"password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	request := endedSpan(t, recorder, "POST /auth/login")
+	login := endedSpan(t, recorder, "AuthService.Login")
+	bcrypt := endedSpan(t, recorder, "bcrypt.verify")
+	if request.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || request.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the request span to continue the caller's trace, got %s under %s", request.SpanContext().TraceID(), request.Parent().SpanID())
+	}
+	if login.Parent().SpanID() != request.SpanContext().SpanID() || bcrypt.Parent().SpanID() != login.SpanContext().SpanID() {
+		t.Error("Expected request > service > bcrypt spans to be nested")
+	}
+	if spanAttribute(request, "http.route").AsString() != "/auth/login" || spanAttribute(request, "http.response.status_code").AsInt64() != http.StatusOK {
+		t.Errorf("Expected the route and status on the request span, got %v", request.Attributes())
+	}
+
+	// Statements are traced under the request, without their bound values
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	gdb.Use(tracing.GormPlugin{})
+	sqlMock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("traced-user"))
+	sqlMock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("traced-user"))
+	ctx, parent := tracing.Start(context.Background(), "parent")
+	repository.NewUserRepository(gdb).GetUserByEmail(ctx, "traced@example.com")
+	parent.End()
+	query := endedSpan(t, recorder, "db.query users")
+	if query.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the query span to be a child of the caller's span")
+	}
+	if statement := spanAttribute(query, "db.query.text").AsString(); !strings.Contains(statement, "$1") || strings.Contains(statement, "traced@") {
+		t.Errorf("Expected the parameterized statement, got %q", statement)
+	}
+
+	// Untraced work, like the purge jobs, does not start traces of its own
+	before := len(recorder.Ended())
+	repository.NewUserRepository(gdb).GetUserByEmail(context.Background(), "traced@example.com")
+	if len(recorder.Ended()) != before {
+		t.Error("Expected no span for a statement outside a trace")
+	}
+}
+
+func TestTracingStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), config.TracingConfig{Exporter: "stdout", ServiceName: "h-two-test", SampleRatio: 1}, &buf)
+	if err != nil {
+		t.Fatalf("Expected the stdout exporter to start, got %v", err)
+	}
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	_, span := tracing.Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected the spans to be flushed, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"exported"`) || !strings.Contains(buf.String(), "h-two-test") {
+		t.Errorf("Expected the span and service name on stdout, got %s", buf.String())
+	}
+}
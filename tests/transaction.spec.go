@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"h-two/internal/dto"
+	"h-two/internal/logging"
+	"h-two/internal/models"
+	"h-two/internal/repository"
+	"h-two/internal/services"
+	"net/http"
+	"testing"
+)
+
+// newRegistrationService wires registration to real repositories over a
+// stub database, so the statements of each unit of work can be checked.
+func newRegistrationService(t *testing.T) (services.AuthService, sqlmock.Sqlmock, *MockRefreshTokenRepository, *RecordingMailSender) {
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open stub database: %v", err)
+	}
+	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm: %v", err)
+	}
+	refreshRepo := new(MockRefreshTokenRepository)
+	refreshRepo.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+	userTokens := new(MockUserTokenRepository)
+	userTokens.On("InvalidateUserTokens", mock.AnythingOfType("string"), models.TokenPurposeEmailVerification).Return(nil)
+	userTokens.On("CreateUserToken", mock.AnythingOfType("*models.UserToken")).Return(nil)
+	mailer := &RecordingMailSender{}
+	orgService := services.NewOrganizationService(repository.NewOrganizationRepository(gdb))
+	authService := services.NewAuthService(repository.NewUserRepository(gdb), orgService, refreshRepo, repository.NewInMemoryTokenRevocationRepository(), userTokens, NewFakePersonalAccessTokenRepository(), mailer, nil, nil, NewFakeLoginEventRepository(), NewTestLoginLimiter(), repository.NewTxManager(gdb), testKeys, testAppURL, logging.Discard())
+	return authService, sqlMock, refreshRepo, mailer
+}
+
+// expectNewUser expects the statements that store a new user inside the
+// registration transaction, up to the savepoint opened for the organization.
+func expectNewUser(sqlMock sqlmock.Sqlmock) {
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE lower\(email\) = lower\(\$1\)`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE lower\(email\) = lower\(\$1\)`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	sqlMock.ExpectQuery(`INSERT INTO "users"`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("new-user"))
+	sqlMock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestRegistrationIsAtomic(t *testing.T) {
+	req := func() *dto.CreateUserRequest {
+		return &dto.CreateUserRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "password123", Phone: "555"}
+	}
+
+	// The membership insert fails after the user and organization rows were
+	// written; the whole registration is rolled back
+	authService, sqlMock, refreshRepo, mailer := newRegistrationService(t)
+	expectNewUser(sqlMock)
+	sqlMock.ExpectQuery(`INSERT INTO "organizations"`).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow("new-org"))
+	sqlMock.ExpectQuery(`INSERT INTO "user_organizations"`).WillReturnError(errors.New("connection reset"))
+	sqlMock.ExpectExec(`ROLLBACK TO SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectRollback()
+	_, apiErr := authService.CreateUserAndOrganization(context.Background(), req())
+	if apiErr == nil || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected registration to fail, got %v", apiErr)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected the user to be rolled back with the organization: %v", err)
+	}
+	if len(mailer.Messages) != 0 {
+		t.Error("Expected no verification email for a rolled back account")
+	}
+	refreshRepo.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+
+	// The organization insert fails right after the user row was written
+	authService, sqlMock, _, _ = newRegistrationService(t)
+	expectNewUser(sqlMock)
+	sqlMock.ExpectQuery(`INSERT INTO "organizations"`).WillReturnError(errors.New("connection reset"))
+	sqlMock.ExpectExec(`ROLLBACK TO SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectRollback()
+	if _, apiErr = authService.CreateUserAndOrganization(context.Background(), req()); apiErr == nil {
+		t.Fatal("Expected registration to fail")
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected the user to be rolled back: %v", err)
+	}
+
+	// Everything is committed together, and the session only starts after
+	authService, sqlMock, refreshRepo, mailer = newRegistrationService(t)
+	expectNewUser(sqlMock)
+	sqlMock.ExpectQuery(`INSERT INTO "organizations"`).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow("new-org"))
+	sqlMock.ExpectQuery(`INSERT INTO "user_organizations"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("membership"))
+	sqlMock.ExpectCommit()
+	resp, apiErr := authService.CreateUserAndOrganization(context.Background(), req())
+	if apiErr != nil {
+		t.Fatalf("Expected registration to succeed, got %v", apiErr)
+	}
+	if resp.User.UserId != "new-user" || resp.AccessToken == "" {
+		t.Errorf("Expected a session for the new user, got %+v", resp)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected one committed transaction: %v", err)
+	}
+	if len(mailer.Messages) != 1 {
+		t.Errorf("Expected a verification email after commit, got %d", len(mailer.Messages))
+	}
+	refreshRepo.AssertNumberOfCalls(t, "CreateRefreshToken", 1)
+}
+
+func TestCreateOrganizationIsAtomic(t *testing.T) {
+	db, sqlMock, _ := sqlmock.New()
+	gdb, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	orgService := services.NewOrganizationService(repository.NewOrganizationRepository(gdb))
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "organizations"`).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow("new-org"))
+	sqlMock.ExpectQuery(`INSERT INTO "user_organizations"`).WillReturnError(errors.New("connection reset"))
+	sqlMock.ExpectRollback()
+	if _, apiErr := orgService.CreateOrganization(context.Background(), "owner", &dto.CreateOrganizationRequest{Name: "Acme"}); apiErr == nil {
+		t.Fatal("Expected organization creation to fail")
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected the organization to be rolled back without its owner: %v", err)
+	}
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "organizations"`).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow("new-org"))
+	sqlMock.ExpectQuery(`INSERT INTO "user_organizations"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("membership"))
+	sqlMock.ExpectCommit()
+	org, apiErr := orgService.CreateOrganization(context.Background(), "owner", &dto.CreateOrganizationRequest{Name: "Acme"})
+	if apiErr != nil {
+		t.Fatalf("Expected the organization to be created, got %v", apiErr)
+	}
+	if org.OrgId != "new-org" || org.Role != models.RoleOwner {
+		t.Errorf("Expected the owner's view of the new organization, got %+v", org)
+	}
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected one committed transaction: %v", err)
+	}
+}